@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	accessLogCombined = "combined"
+	accessLogJSON     = "json"
+)
+
+// accessLogger writes one line per request in Apache Combined Log Format or as JSON lines.
+type accessLogger struct {
+	format string
+	mu     sync.Mutex
+	out    io.Writer
+	closer io.Closer
+	// excluded holds request paths that are not logged.
+	excluded map[string]bool
+	// sampleRate logs 1 in sampleRate requests answered without a 5xx; 0 and 1 log all.
+	sampleRate int64
+	sampled    atomic.Int64
+}
+
+// newAccessLogger creates an access logger writing to stdout, stderr or the file at destination.
+func newAccessLogger(format, destination string) (*accessLogger, error) {
+	if format != accessLogCombined && format != accessLogJSON {
+		return nil, fmt.Errorf("unknown access log format %q", format)
+	}
+
+	al := &accessLogger{format: format}
+	switch destination {
+	case "", "stdout":
+		al.out = os.Stdout
+	case "stderr":
+		al.out = os.Stderr
+	default:
+		f, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		al.out = f
+		al.closer = f
+	}
+	return al, nil
+}
+
+func (al *accessLogger) Close() error {
+	if al.closer != nil {
+		return al.closer.Close()
+	}
+	return nil
+}
+
+// accessLogEntry holds everything recorded about a single request.
+type accessLogEntry struct {
+	ClientIP  string        `json:"client_ip"`
+	Time      time.Time     `json:"timestamp"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Protocol  string        `json:"protocol"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Referer   string        `json:"referer"`
+	UserAgent string        `json:"user_agent"`
+	Upstream  string        `json:"upstream"`
+	Latency   time.Duration `json:"-"`
+	LatencyMs float64       `json:"latency_ms"`
+}
+
+func (al *accessLogger) log(e *accessLogEntry) {
+	var line []byte
+	if al.format == accessLogJSON {
+		e.LatencyMs = float64(e.Latency.Microseconds()) / 1000
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	} else {
+		line = []byte(formatCombined(e))
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.out.Write(line)
+}
+
+// formatCombined renders the entry in Combined Log Format, followed by the upstream and latency in seconds.
+func formatCombined(e *accessLogEntry) string {
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = strconv.FormatInt(e.Bytes, 10)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s %q %q %q %.3f\n",
+		orDash(e.ClientIP),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.Path, e.Protocol,
+		e.Status, bytes,
+		orDash(e.Referer), orDash(e.UserAgent), orDash(e.Upstream),
+		e.Latency.Seconds(),
+	)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+type accessLogContextKey struct{}
+
+// setUpstream records the backend address that served the request for the access log.
+func setUpstream(r *http.Request, addr string) {
+	if e, ok := r.Context().Value(accessLogContextKey{}).(*accessLogEntry); ok {
+		e.Upstream = addr
+	}
+}
+
+// exclude stops logging requests to these exact paths, such as "/health".
+func (al *accessLogger) exclude(paths ...string) {
+	al.excluded = pathSet(paths)
+}
+
+// sample logs only 1 in n requests answered without a server error, to cut the log volume at
+// high request rates. Responses with a 5xx status are always logged.
+func (al *accessLogger) sample(n int) {
+	al.sampleRate = int64(n)
+}
+
+// keep reports whether the request answered with status is logged.
+func (al *accessLogger) keep(status int) bool {
+	if al.sampleRate <= 1 || status >= http.StatusInternalServerError {
+		return true
+	}
+	return al.sampled.Add(1)%al.sampleRate == 1
+}
+
+// Middleware to write an access log line for each request once it completes
+func accessLogMiddleware(al *accessLogger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if al.excluded[r.URL.Path] {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		entry := &accessLogEntry{
+			ClientIP:  clientIP(r),
+			Time:      time.Now(),
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+			Protocol:  r.Proto,
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+		}
+		sw := &statusWriter{ResponseWriter: rw}
+
+		ctx := context.WithValue(r.Context(), accessLogContextKey{}, entry)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		entry.Status = sw.statusCode()
+		if !al.keep(entry.Status) {
+			return
+		}
+		entry.Bytes = sw.bytes
+		entry.Latency = time.Since(entry.Time)
+		al.log(entry)
+	})
+}
+
+func remoteHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// statusWriter records the status code and number of body bytes written to the client.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	// Informational responses are followed by the real status.
+	if w.status == 0 && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestAccessLog_CombinedFormat(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("hello"))
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)})
+
+	var buf bytes.Buffer
+	al := &accessLogger{format: accessLogCombined, out: &buf}
+	handler := accessLogMiddleware(al, http.HandlerFunc(lb.serveProxy))
+
+	req := httptest.NewRequest("GET", "/path?q=1", nil)
+	req.RemoteAddr = "192.0.2.10:51234"
+	req.Header.Set("Referer", "http://example.com/")
+	req.Header.Set("User-Agent", "test-agent/1.0")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	pattern := `^192\.0\.2\.10 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /path\?q=1 HTTP/1\.1" 200 5 "http://example\.com/" "test-agent/1\.0" "` +
+		regexp.QuoteMeta(backendServer.URL) + `" \d+\.\d{3}\n$`
+	if !regexp.MustCompile(pattern).Match(buf.Bytes()) {
+		t.Errorf("Access log line %q does not match combined format", buf.String())
+	}
+}
+
+func TestAccessLog_JSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	al := &accessLogger{format: accessLogJSON, out: &buf}
+	handler := accessLogMiddleware(al, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		setUpstream(req, "http://backend")
+		rw.WriteHeader(http.StatusNotFound)
+	}))
+
+	req := httptest.NewRequest("POST", "/missing", nil)
+	req.RemoteAddr = "192.0.2.10:51234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a JSON log line; got %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"client_ip": "192.0.2.10",
+		"method":    "POST",
+		"path":      "/missing",
+		"protocol":  "HTTP/1.1",
+		"status":    float64(404),
+		"upstream":  "http://backend",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("Expected %s=%v; got %v", k, v, entry[k])
+		}
+	}
+	for _, k := range []string{"timestamp", "latency_ms", "bytes", "referer", "user_agent"} {
+		if _, ok := entry[k]; !ok {
+			t.Errorf("Expected field %q in log line", k)
+		}
+	}
+}
+
+func TestAccessLog_Sampling(t *testing.T) {
+	var buf bytes.Buffer
+	al := &accessLogger{format: accessLogJSON, out: &buf}
+	al.sample(10)
+	handler := accessLogMiddleware(al, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+
+	for i := 0; i < 1000; i++ {
+		path := "/"
+		if i%50 == 0 {
+			path = "/fail"
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	logged := make(map[int]int)
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var entry accessLogEntry
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		logged[entry.Status]++
+	}
+	if logged[http.StatusBadGateway] != 20 {
+		t.Errorf("Expected all 20 failed requests to be logged; got %d", logged[http.StatusBadGateway])
+	}
+	if n := logged[http.StatusOK]; n < 90 || n > 110 {
+		t.Errorf("Expected about 1 in 10 of the 980 successful requests to be logged; got %d", n)
+	}
+}
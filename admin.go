@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// adminHandler serves the operational endpoints, which are meant to be exposed on a separate,
+// internal-only address.
+func (lb *LoadBalancer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", lb.handleStatus)
+	mux.HandleFunc("GET /metrics", lb.handleMetrics)
+	mux.HandleFunc("GET /ready", lb.handleReady)
+	mux.HandleFunc("POST /drain", lb.handleDrain)
+	mux.HandleFunc("POST /undrain", lb.handleUndrain)
+	mux.HandleFunc("POST /reload", lb.handleReload)
+	mux.HandleFunc("POST /backends/reset", lb.handleReset)
+	mux.HandleFunc("GET /backends/{addr}/weight", lb.handleGetWeight)
+	mux.HandleFunc("PUT /backends/{addr}/weight", lb.handleSetWeight)
+	mux.HandleFunc("GET /pools/active", lb.handleActivePool)
+	mux.HandleFunc("POST /pools/switch", lb.handleSwitch)
+	mux.HandleFunc("GET /version", handleVersion)
+	return mux
+}
+
+type backendStatus struct {
+	Address string `json:"address"`
+	Alive   bool   `json:"alive"`
+	// HealthError says why the last health check failed.
+	HealthError string `json:"health_error,omitempty"`
+	Draining    bool   `json:"draining"`
+	// Standby is set for the backends of the blue-green pool not receiving traffic.
+	Standby        bool  `json:"standby,omitempty"`
+	Requests       int64 `json:"requests"`
+	ActiveRequests int64 `json:"active_requests"`
+	BytesSent      int64 `json:"bytes_sent"`
+	BytesReceived  int64 `json:"bytes_received"`
+	// Share is the backend's fraction of all requests proxied to the listed backends.
+	Share float64 `json:"share"`
+	// Breaker is the circuit breaker state, when circuit breaking is enabled, and
+	// NextTrialSeconds the time until an open breaker lets a trial request through.
+	Breaker          string  `json:"breaker,omitempty"`
+	NextTrialSeconds float64 `json:"next_trial_seconds,omitempty"`
+}
+
+func (lb *LoadBalancer) backendStatuses() []backendStatus {
+	servers := lb.allServers()
+	standby := lb.blueGreen.standbyServers()
+	statuses := make([]backendStatus, 0, len(servers))
+	for _, s := range servers {
+		st := lb.statsFor(s)
+		breaker, nextTrial := lb.breakers.status(s)
+		statuses = append(statuses, backendStatus{
+			Address:        s.Address(),
+			Alive:          lb.isAlive(s),
+			HealthError:    lb.healthReason(s),
+			Draining:       lb.isDraining(s),
+			Standby:        slices.Contains(standby, s),
+			Requests:       st.requests.Load(),
+			ActiveRequests: st.activeRequests.Load(),
+			BytesSent:      st.bytesSent.Load(),
+			BytesReceived:  st.bytesReceived.Load(),
+
+			Breaker:          breaker,
+			NextTrialSeconds: nextTrial.Seconds(),
+		})
+	}
+
+	var total int64
+	for _, st := range statuses {
+		total += st.Requests
+	}
+	if total > 0 {
+		for i := range statuses {
+			statuses[i].Share = float64(statuses[i].Requests) / float64(total)
+		}
+	}
+	return statuses
+}
+
+// distributionSkew is the coefficient of variation (standard deviation over mean) of the
+// backends' request counts: 0 when traffic is spread evenly, growing with the imbalance.
+// Backends with different weights are expected to have some skew.
+func distributionSkew(statuses []backendStatus) float64 {
+	if len(statuses) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, st := range statuses {
+		sum += float64(st.Requests)
+	}
+	mean := sum / float64(len(statuses))
+	if mean == 0 {
+		return 0
+	}
+	var variance float64
+	for _, st := range statuses {
+		d := float64(st.Requests) - mean
+		variance += d * d
+	}
+	return math.Sqrt(variance/float64(len(statuses))) / mean
+}
+
+func (lb *LoadBalancer) handleStatus(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	statuses := lb.backendStatuses()
+	json.NewEncoder(rw).Encode(map[string]any{
+		"backends": statuses,
+		"skew":     distributionSkew(statuses),
+	})
+}
+
+// ready reports whether at least one backend is healthy and not draining, i.e. whether the
+// load balancer can serve traffic at all.
+func (lb *LoadBalancer) ready() bool {
+	for _, s := range lb.allServers() {
+		if lb.available(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleReady answers readiness probes from orchestrators, so they stop sending traffic
+// while no backend can serve it or the load balancer is draining.
+func (lb *LoadBalancer) handleReady(rw http.ResponseWriter, req *http.Request) {
+	if lb.Draining() {
+		http.Error(rw, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	if !lb.ready() {
+		http.Error(rw, "no healthy backends", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(rw, "ready")
+}
+
+// handleDrain drains the whole load balancer. With ?timeout=30s it waits up to that long for
+// the requests in flight to finish. It answers 200 once none is left, and 202 Accepted with
+// the number still in flight otherwise.
+func (lb *LoadBalancer) handleDrain(rw http.ResponseWriter, req *http.Request) {
+	var timeout time.Duration
+	if t := req.URL.Query().Get("timeout"); t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil || d < 0 {
+			http.Error(rw, "invalid timeout "+strconv.Quote(t), http.StatusBadRequest)
+			return
+		}
+		timeout = d
+	}
+
+	lb.DrainInstance()
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		lb.waitAllIdle(ctx)
+	}
+	if n := lb.inFlight(); n > 0 {
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "draining, %d requests in flight\n", n)
+		return
+	}
+	fmt.Fprintln(rw, "drained")
+}
+
+// handleUndrain cancels /drain. It answers 409 Conflict while the drain file keeps the load
+// balancer draining.
+func (lb *LoadBalancer) handleUndrain(rw http.ResponseWriter, req *http.Request) {
+	lb.UndrainInstance()
+	if lb.Draining() {
+		http.Error(rw, fmt.Sprintf("still draining: drain file %q exists", lb.drainFile), http.StatusConflict)
+		return
+	}
+	fmt.Fprintln(rw, "undrained")
+}
+
+// handleReload reloads the config file's backends; see Reload.
+func (lb *LoadBalancer) handleReload(rw http.ResponseWriter, req *http.Request) {
+	if lb.reloader == nil {
+		http.Error(rw, "config reloading is not enabled", http.StatusNotFound)
+		return
+	}
+	if err := lb.Reload(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(rw, "reloaded, %d backends\n", len(lb.pool.All()))
+}
+
+// handleMetrics writes the backend counters in the Prometheus text exposition format.
+func (lb *LoadBalancer) handleMetrics(rw http.ResponseWriter, req *http.Request) {
+	statuses := lb.backendStatuses()
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metric := func(name, kind, help string, value func(backendStatus) int64) {
+		fmt.Fprintf(rw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, st := range statuses {
+			fmt.Fprintf(rw, "%s{backend=%q} %d\n", name, st.Address, value(st))
+		}
+	}
+	metric("lb_backend_up", "gauge", "Whether the backend is healthy.", func(st backendStatus) int64 {
+		if st.Alive {
+			return 1
+		}
+		return 0
+	})
+	metric("lb_backend_requests_total", "counter", "Requests proxied to the backend.", func(st backendStatus) int64 { return st.Requests })
+	metric("lb_backend_active_requests", "gauge", "Requests currently in flight to the backend.", func(st backendStatus) int64 { return st.ActiveRequests })
+	metric("lb_backend_sent_bytes_total", "counter", "Request body bytes sent to the backend.", func(st backendStatus) int64 { return st.BytesSent })
+	metric("lb_backend_received_bytes_total", "counter", "Response body bytes received from the backend.", func(st backendStatus) int64 { return st.BytesReceived })
+
+	fmt.Fprintf(rw, "# HELP lb_backend_request_share Fraction of all requests proxied to the backend.\n# TYPE lb_backend_request_share gauge\n")
+	for _, st := range statuses {
+		fmt.Fprintf(rw, "lb_backend_request_share{backend=%q} %g\n", st.Address, st.Share)
+	}
+	fmt.Fprintf(rw, "# HELP lb_request_distribution_skew Coefficient of variation of the backends' request counts.\n# TYPE lb_request_distribution_skew gauge\n")
+	fmt.Fprintf(rw, "lb_request_distribution_skew %g\n", distributionSkew(statuses))
+	if m, ok := lb.metrics.(io.WriterTo); ok {
+		m.WriteTo(rw)
+	}
+}
+
+// handleReset clears the counters and health state of every backend, or only of the one
+// named by the "backend" query parameter, and triggers a fresh health check.
+func (lb *LoadBalancer) handleReset(rw http.ResponseWriter, req *http.Request) {
+	servers := lb.allServers()
+	if address := req.URL.Query().Get("backend"); address != "" {
+		servers = slices.DeleteFunc(servers, func(s Server) bool { return s.Address() != address })
+		if len(servers) == 0 {
+			http.Error(rw, "unknown backend", http.StatusNotFound)
+			return
+		}
+	}
+
+	for _, s := range servers {
+		lb.statsFor(s).reset()
+	}
+	if lb.health != nil {
+		lb.health.reset(servers)
+		lb.pool.invalidate()
+	}
+	fmt.Fprintf(rw, "reset %d backends\n", len(servers))
+}
+
+// weightStatus is the body of the weight endpoints: the configured weight and the weight
+// balancing currently uses, which differs when the backend advertises its own weight or
+// reports load.
+type weightStatus struct {
+	Address   string  `json:"address"`
+	Weight    int     `json:"weight"`
+	Effective float64 `json:"effective"`
+}
+
+// adjustableWeight is a backend whose weight can be changed while it serves traffic.
+type adjustableWeight interface {
+	Server
+	Weight() int
+	SetWeight(weight int)
+}
+
+// weightedBackend returns the backend named by the request's {addr}, which must be escaped,
+// e.g. "/backends/http:%2F%2F10.0.0.1:8080/weight". It answers the request when there is none.
+func (lb *LoadBalancer) weightedBackend(rw http.ResponseWriter, req *http.Request) (adjustableWeight, bool) {
+	address := req.PathValue("addr")
+	servers := lb.allServers()
+	i := slices.IndexFunc(servers, func(s Server) bool { return s.Address() == address })
+	if i < 0 {
+		http.Error(rw, "unknown backend", http.StatusNotFound)
+		return nil, false
+	}
+	s, ok := servers[i].(adjustableWeight)
+	if !ok {
+		http.Error(rw, "backend has no adjustable weight", http.StatusConflict)
+		return nil, false
+	}
+	return s, true
+}
+
+func (lb *LoadBalancer) writeWeight(rw http.ResponseWriter, s adjustableWeight) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(weightStatus{Address: s.Address(), Weight: s.Weight(), Effective: lb.weight(s)})
+}
+
+func (lb *LoadBalancer) handleGetWeight(rw http.ResponseWriter, req *http.Request) {
+	if s, ok := lb.weightedBackend(rw, req); ok {
+		lb.writeWeight(rw, s)
+	}
+}
+
+// handleSetWeight changes a backend's configured weight, taking effect on the next request.
+// The body is {"weight": N} with N between 1 and 1000.
+func (lb *LoadBalancer) handleSetWeight(rw http.ResponseWriter, req *http.Request) {
+	s, ok := lb.weightedBackend(rw, req)
+	if !ok {
+		return
+	}
+	var body struct {
+		Weight int `json:"weight"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(rw, "invalid body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Weight < minAdvertisedWeight || body.Weight > maxAdvertisedWeight {
+		http.Error(rw, fmt.Sprintf("weight must be between %d and %d", minAdvertisedWeight, maxAdvertisedWeight), http.StatusBadRequest)
+		return
+	}
+	s.SetWeight(body.Weight)
+	fmt.Printf("Weight of backend %q set to %d\n", s.Address(), body.Weight)
+	lb.writeWeight(rw, s)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAdmin_Ready(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !healthy.Load() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	a, b := httptest.NewServer(handler), httptest.NewServer(handler)
+	defer a.Close()
+	defer b.Close()
+
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(a.URL), newSimpleServer(b.URL)},
+		WithClock(clock), WithHealthCheck(time.Second, time.Second))
+	ready := func() int {
+		clock.Advance(lb.health.probeDue(lb.pool.All()).Sub(clock.Now()))
+		rw := httptest.NewRecorder()
+		lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("GET", "/ready", nil))
+		return rw.Code
+	}
+
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("Expected ready with healthy backends; got %d", code)
+	}
+	healthy.Store(false)
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready with zero healthy backends; got %d", code)
+	}
+	healthy.Store(true)
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("Expected ready again after recovery; got %d", code)
+	}
+
+	// Draining backends can't take traffic either.
+	for _, s := range lb.pool.All() {
+		lb.Drain(s)
+	}
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not ready while every backend is draining; got %d", code)
+	}
+}
+
+func TestAdmin_ResetBackends(t *testing.T) {
+	var probes atomic.Int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			probes.Add(1)
+		}
+		rw.Write([]byte("ok"))
+	}))
+	defer backendServer.Close()
+
+	clock := newFakeClock()
+	server := newSimpleServer(backendServer.URL)
+	lb := NewLoadBalancer("8000", []Server{server}, WithClock(clock), WithHealthCheck(time.Minute, time.Minute))
+	lb.health.probeDue(lb.pool.All())
+	for i := 0; i < 3; i++ {
+		lb.serveProxy(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	if st := lb.statsFor(server); st.requests.Load() != 3 || st.bytesReceived.Load() == 0 {
+		t.Fatalf("Expected counters to be recorded before the reset")
+	}
+
+	reset := func(target string) int {
+		rw := httptest.NewRecorder()
+		lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("POST", target, nil))
+		return rw.Code
+	}
+	if code := reset("/backends/reset?backend=http://unknown"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown backend; got %d", code)
+	}
+	if code := reset("/backends/reset?backend=" + backendServer.URL); code != http.StatusOK {
+		t.Fatalf("Expected reset to succeed; got %d", code)
+	}
+
+	st := lb.statsFor(server)
+	if st.requests.Load() != 0 || st.bytesSent.Load() != 0 || st.bytesReceived.Load() != 0 {
+		t.Errorf("Expected counters to reset to zero; got %d requests, %d/%d bytes",
+			st.requests.Load(), st.bytesSent.Load(), st.bytesReceived.Load())
+	}
+	select {
+	case <-lb.health.wake:
+	default:
+		t.Errorf("Expected the reset to wake the health checker")
+	}
+	// The reset backend is due for a probe without waiting for the interval.
+	lb.health.probeDue(lb.pool.All())
+	if probes.Load() != 2 {
+		t.Errorf("Expected the reset to trigger a re-probe; got %d probes", probes.Load())
+	}
+}
+
+func TestAdmin_Version(t *testing.T) {
+	get := func() VersionInfo {
+		rw := httptest.NewRecorder()
+		lb := NewLoadBalancer("8000", []Server{newNamedBackend(t, "a")})
+		lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("GET", "/version", nil))
+		var v VersionInfo
+		if err := json.NewDecoder(rw.Body).Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	defer func(v VersionInfo) { buildVersion = v }(buildVersion)
+
+	// Unset values fall back to defaults.
+	if v := get(); v.Version == "" || v.Commit == "" || v.Date == "" || v.GoVersion != runtime.Version() {
+		t.Errorf("Expected defaults for unset build information; got %+v", v)
+	}
+
+	version, commit, buildDate = "v1.4.0", "0123abc", "2026-10-14T00:00:00Z"
+	defer func() { version, commit, buildDate = "", "", "" }()
+	buildVersion = currentVersion()
+	want := VersionInfo{Version: "v1.4.0", Commit: "0123abc", Date: "2026-10-14T00:00:00Z", GoVersion: runtime.Version()}
+	if v := get(); v != want {
+		t.Errorf("Expected the injected build information %+v; got %+v", want, v)
+	}
+}
+
+func TestAdmin_StatusBreaker(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodHead {
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer backendServer.Close()
+
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)},
+		WithClock(clock), WithCircuitBreaker(3, time.Minute))
+	status := func() backendStatus {
+		rw := httptest.NewRecorder()
+		lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("GET", "/status", nil))
+		var body struct{ Backends []backendStatus }
+		if err := json.NewDecoder(rw.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Backends[0]
+	}
+
+	if st := status(); st.Breaker != breakerClosed {
+		t.Errorf("Expected a closed breaker before any failure; got %q", st.Breaker)
+	}
+	for range 3 {
+		lb.serveProxy(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	clock.Advance(15 * time.Second)
+	if st := status(); st.Breaker != breakerOpen || st.NextTrialSeconds != 45 {
+		t.Errorf("Expected an open breaker with its trial in 45s; got %q in %gs", st.Breaker, st.NextTrialSeconds)
+	}
+	clock.Advance(45 * time.Second)
+	if st := status(); st.Breaker != breakerHalfOpen || st.NextTrialSeconds != 0 {
+		t.Errorf("Expected a half-open breaker once the cooldown passed; got %q in %gs", st.Breaker, st.NextTrialSeconds)
+	}
+}
+
+func TestAdmin_BackendWeight(t *testing.T) {
+	a, b := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	lb := NewLoadBalancer("8000", []Server{a, b}, WithStrategy(&weightedRoundRobin{}))
+	admin := lb.adminHandler()
+	weightPath := "/backends/" + url.PathEscape(a.Address()) + "/weight"
+
+	distribution := func() map[string]int {
+		counts := make(map[string]int)
+		for range 8 {
+			rw := httptest.NewRecorder()
+			lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+			counts[rw.Body.String()]++
+		}
+		return counts
+	}
+	if got := distribution(); got["a"] != 4 || got["b"] != 4 {
+		t.Errorf("Expected an even split with equal weights; got %v", got)
+	}
+
+	rw := httptest.NewRecorder()
+	admin.ServeHTTP(rw, httptest.NewRequest("PUT", weightPath, strings.NewReader(`{"weight": 3}`)))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected the weight to be updated; got %d %s", rw.Code, rw.Body.String())
+	}
+	if got := distribution(); got["a"] != 6 || got["b"] != 2 {
+		t.Errorf("Expected a 3:1 split after raising the weight; got %v", got)
+	}
+
+	rw = httptest.NewRecorder()
+	admin.ServeHTTP(rw, httptest.NewRequest("GET", weightPath, nil))
+	var status weightStatus
+	if err := json.NewDecoder(rw.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Address != a.Address() || status.Weight != 3 || status.Effective != 3 {
+		t.Errorf("Expected the updated weight to be reported; got %+v", status)
+	}
+
+	for _, tt := range []struct {
+		path, body string
+		code       int
+	}{
+		{weightPath, `{"weight": 0}`, http.StatusBadRequest},
+		{weightPath, `not json`, http.StatusBadRequest},
+		{"/backends/" + url.PathEscape("http://unknown:1") + "/weight", `{"weight": 2}`, http.StatusNotFound},
+	} {
+		rw := httptest.NewRecorder()
+		admin.ServeHTTP(rw, httptest.NewRequest("PUT", tt.path, strings.NewReader(tt.body)))
+		if rw.Code != tt.code {
+			t.Errorf("PUT %s %s: expected %d; got %d", tt.path, tt.body, tt.code, rw.Code)
+		}
+	}
+}
+
+func TestAdmin_DrainInstance(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		if req.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		rw.Write([]byte("done"))
+	}))
+	defer backendServer.Close()
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)})
+	admin := func(method, target string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		lb.adminHandler().ServeHTTP(rw, httptest.NewRequest(method, target, nil))
+		return rw
+	}
+
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/slow", nil))
+		inFlight <- rw
+	}()
+	<-started
+
+	if rw := admin("POST", "/drain?timeout=10ms"); rw.Code != http.StatusAccepted || rw.Body.String() != "draining, 1 requests in flight\n" {
+		t.Errorf("Expected /drain to report the request in flight; got %d %q", rw.Code, rw.Body.String())
+	}
+	if rw := admin("GET", "/ready"); rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /ready to answer 503 while draining; got %d", rw.Code)
+	}
+
+	// A drain waiting long enough returns once the request in flight has finished.
+	drained := make(chan *httptest.ResponseRecorder)
+	go func() { drained <- admin("POST", "/drain?timeout=10s") }()
+	close(release)
+	if rw := <-inFlight; rw.Body.String() != "done" {
+		t.Errorf("Expected the request in flight to finish while draining; got %d %q", rw.Code, rw.Body.String())
+	}
+	if rw := <-drained; rw.Code != http.StatusOK || rw.Body.String() != "drained\n" {
+		t.Errorf("Expected /drain to report the instance drained; got %d %q", rw.Code, rw.Body.String())
+	}
+
+	if rw := admin("POST", "/undrain"); rw.Code != http.StatusOK {
+		t.Errorf("Expected /undrain to succeed; got %d %q", rw.Code, rw.Body.String())
+	}
+	if rw := admin("GET", "/ready"); rw.Code != http.StatusOK {
+		t.Errorf("Expected /ready to answer 200 once undrained; got %d", rw.Code)
+	}
+	if rw := admin("POST", "/drain?timeout=soon"); rw.Code != http.StatusBadRequest || lb.Draining() {
+		t.Errorf("Expected an invalid timeout to be rejected without draining; got %d", rw.Code)
+	}
+}
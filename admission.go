@@ -0,0 +1,152 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request priorities in the admission queue; see WithPriorityHeader.
+const (
+	priorityLow = iota
+	priorityNormal
+	priorityHigh
+)
+
+// WithPriorityHeader reads the priority of requests in the admission queue from the named
+// header, such as "X-Priority": "high" or "low", anything else being normal. Waiting
+// requests are admitted highest priority first, and a request arriving at a full queue sheds
+// the newest waiter of a lower priority instead of being rejected itself. Clients shouldn't
+// be able to set the header themselves, so it is meant to be set by a trusted proxy in front.
+func WithPriorityHeader(header string) Option {
+	return func(lb *LoadBalancer) {
+		lb.priorityHeader = header
+	}
+}
+
+// requestPriority returns the admission priority of r.
+func (lb *LoadBalancer) requestPriority(r *http.Request) int {
+	if lb.priorityHeader == "" {
+		return priorityNormal
+	}
+	switch strings.ToLower(r.Header.Get(lb.priorityHeader)) {
+	case "high":
+		return priorityHigh
+	case "low":
+		return priorityLow
+	}
+	return priorityNormal
+}
+
+// admissionQueue caps how many requests are proxied at once to smooth out bursts. Requests
+// over the cap wait for up to timeout, in FIFO order within each priority, and are rejected
+// outright once maxDepth requests are already waiting, unless they can shed a waiter of a
+// lower priority.
+type admissionQueue struct {
+	maxActive int
+	maxDepth  int
+	timeout   time.Duration
+
+	mu      sync.Mutex
+	active  int
+	waiters [priorityHigh + 1]list.List // of *admissionWaiter, by priority
+	waiting int
+}
+
+type admissionWaiter struct {
+	// ready is closed once the waiter is handed a slot, setting admitted, or shed.
+	ready    chan struct{}
+	admitted bool
+}
+
+func newAdmissionQueue(maxActive, maxDepth int, timeout time.Duration) *admissionQueue {
+	return &admissionQueue{
+		maxActive: maxActive,
+		maxDepth:  maxDepth,
+		timeout:   timeout,
+	}
+}
+
+// acquire blocks until the request may proceed and reports whether it was admitted.
+// Every admitted request must call release when done.
+func (q *admissionQueue) acquire(ctx context.Context, priority int) bool {
+	q.mu.Lock()
+	if q.active < q.maxActive && q.waiting == 0 {
+		q.active++
+		q.mu.Unlock()
+		return true
+	}
+	if q.waiting >= q.maxDepth && !q.shedBelow(priority) {
+		q.mu.Unlock()
+		return false
+	}
+	w := &admissionWaiter{ready: make(chan struct{})}
+	elem := q.waiters[priority].PushBack(w)
+	q.waiting++
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+		return w.admitted
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case <-w.ready:
+		// A slot was handed over, or the waiter shed, while we were giving up.
+		return w.admitted
+	default:
+		q.waiters[priority].Remove(elem)
+		q.waiting--
+		return false
+	}
+}
+
+// shedBelow rejects the newest waiter of the lowest priority below priority, reporting
+// whether there was one.
+func (q *admissionQueue) shedBelow(priority int) bool {
+	for p := priorityLow; p < priority; p++ {
+		if back := q.waiters[p].Back(); back != nil {
+			q.waiters[p].Remove(back)
+			q.waiting--
+			close(back.Value.(*admissionWaiter).ready)
+			return true
+		}
+	}
+	return false
+}
+
+// release frees a slot, handing it straight to the oldest waiter of the highest priority if
+// there is one.
+func (q *admissionQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for p := priorityHigh; p >= priorityLow; p-- {
+		if front := q.waiters[p].Front(); front != nil {
+			q.waiters[p].Remove(front)
+			q.waiting--
+			w := front.Value.(*admissionWaiter)
+			w.admitted = true
+			close(w.ready)
+			return
+		}
+	}
+	q.active--
+}
+
+// depth returns the number of active and waiting requests.
+func (q *admissionQueue) depth() (active, waiting int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.active, q.waiting
+}
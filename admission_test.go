@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdmissionQueue_Burst(t *testing.T) {
+	unblock := make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodHead {
+			<-unblock
+		}
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithAdmissionQueue(2, 2, 5*time.Second))
+
+	// Two requests become active and two wait in the queue.
+	var wg sync.WaitGroup
+	statuses := make(chan int, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rw := httptest.NewRecorder()
+			lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+			statuses <- rw.Code
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if active, waiting := lb.admission.depth(); active == 2 && waiting == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the queue to fill")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full, so the overflow is rejected right away.
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		if rw.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected overflow request to get 503; got %d", rw.Code)
+		}
+	}
+
+	close(unblock)
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		if status != http.StatusOK {
+			t.Errorf("Expected queued request to succeed; got %d", status)
+		}
+	}
+	if active, waiting := lb.admission.depth(); active != 0 || waiting != 0 {
+		t.Errorf("Expected queue to be empty; got %d active and %d waiting", active, waiting)
+	}
+}
+
+func TestAdmissionQueue_WaitTimeout(t *testing.T) {
+	q := newAdmissionQueue(1, 1, 10*time.Millisecond)
+	if !q.acquire(context.Background(), priorityNormal) {
+		t.Fatalf("Expected first request to be admitted")
+	}
+	if q.acquire(context.Background(), priorityNormal) {
+		t.Errorf("Expected waiting request to time out")
+	}
+	q.release()
+	if !q.acquire(context.Background(), priorityNormal) {
+		t.Errorf("Expected request to be admitted after release")
+	}
+}
+
+func TestAdmissionQueue_Priorities(t *testing.T) {
+	unblock := make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodHead {
+			<-unblock
+		}
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithAdmissionQueue(1, 2, 5*time.Second), WithPriorityHeader("X-Priority"))
+	waitFor := func(active, waiting int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if a, w := lb.admission.depth(); a == active && w == waiting {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %d active and %d waiting requests", active, waiting)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	statuses := make(map[string]chan int)
+	send := func(name, priority string) {
+		status := make(chan int, 1)
+		statuses[name] = status
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Priority", priority)
+			rw := httptest.NewRecorder()
+			lb.serveProxy(rw, req)
+			status <- rw.Code
+		}()
+	}
+
+	// One request is active and two low-priority ones fill the queue.
+	send("active", "")
+	waitFor(1, 0)
+	send("low-1", "low")
+	waitFor(1, 1)
+	send("low-2", "low")
+	waitFor(1, 2)
+
+	// High-priority requests shed the queued low-priority ones, newest first.
+	send("high-1", "high")
+	if status := <-statuses["low-2"]; status != http.StatusServiceUnavailable {
+		t.Errorf("Expected the newest low-priority request to be shed with 503; got %d", status)
+	}
+	send("high-2", "high")
+	if status := <-statuses["low-1"]; status != http.StatusServiceUnavailable {
+		t.Errorf("Expected the other low-priority request to be shed with 503; got %d", status)
+	}
+	waitFor(1, 2)
+
+	// With only high-priority requests waiting, even a low-priority request can't get in.
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Priority", "low")
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, req)
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a low-priority request at a full queue to get 503; got %d", rw.Code)
+	}
+
+	close(unblock)
+	wg.Wait()
+	for _, name := range []string{"active", "high-1", "high-2"} {
+		if status := <-statuses[name]; status != http.StatusOK {
+			t.Errorf("Expected the %s request to succeed; got %d", name, status)
+		}
+	}
+}
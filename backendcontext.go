@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+type backendContextKey struct{}
+
+// selectedBackend holds the backend chosen for a request. Retried requests update it, so it is
+// the backend of the latest attempt.
+type selectedBackend struct {
+	mu     sync.Mutex
+	server Server
+}
+
+// TrackBackend returns r with a context in which the load balancer records the backend it
+// chooses for r, so that middleware wrapping the load balancer, such as logging or metrics,
+// can read it with BackendFromContext once the request was served.
+func TrackBackend(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), backendContextKey{}, &selectedBackend{}))
+}
+
+// BackendFromContext returns the backend chosen for the request with ctx. It is set before the
+// backend's Serve is called, so it is available to Server implementations and their
+// transports, and, with TrackBackend, to the middleware around the load balancer.
+func BackendFromContext(ctx context.Context) (Server, bool) {
+	selected, ok := ctx.Value(backendContextKey{}).(*selectedBackend)
+	if !ok {
+		return nil, false
+	}
+	selected.mu.Lock()
+	defer selected.mu.Unlock()
+	return selected.server, selected.server != nil
+}
+
+// withBackend records s as the backend chosen for r, in the TrackBackend holder if r has one.
+func withBackend(r *http.Request, s Server) *http.Request {
+	selected, ok := r.Context().Value(backendContextKey{}).(*selectedBackend)
+	if !ok {
+		selected = &selectedBackend{}
+		r = r.WithContext(context.WithValue(r.Context(), backendContextKey{}, selected))
+	}
+	selected.mu.Lock()
+	selected.server = s
+	selected.mu.Unlock()
+	return r
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// backendCheckingServer fails the test unless the load balancer set it as the request's
+// backend before calling Serve.
+type backendCheckingServer struct {
+	*simpleServer
+	t *testing.T
+}
+
+func (s *backendCheckingServer) Serve(rw http.ResponseWriter, r *http.Request) {
+	if got, ok := BackendFromContext(r.Context()); !ok || got != s {
+		s.t.Errorf("Expected %q as the backend in Serve; got %v", s.Address(), got)
+	}
+	s.simpleServer.Serve(rw, r)
+}
+
+func TestBackendFromContext(t *testing.T) {
+	a := &backendCheckingServer{simpleServer: newNamedBackend(t, "a"), t: t}
+	b := &backendCheckingServer{simpleServer: newNamedBackend(t, "b"), t: t}
+	lb := NewLoadBalancer("8000", []Server{a, b})
+	byName := map[string]Server{"a": a, "b": b}
+
+	var selected Server
+	tracking := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			r = TrackBackend(r)
+			next.ServeHTTP(rw, r)
+			selected, _ = BackendFromContext(r.Context())
+		})
+	}
+	handler := tracking(http.HandlerFunc(lb.serveProxy))
+
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+		if want := byName[rw.Body.String()]; selected != want {
+			t.Errorf("Expected the middleware to see backend %q, which served the request; got %v", rw.Body.String(), selected)
+		}
+	}
+
+	// Without TrackBackend the backend is only visible downstream of the load balancer.
+	req := httptest.NewRequest("GET", "/", nil)
+	lb.serveProxy(httptest.NewRecorder(), req)
+	if s, ok := BackendFromContext(req.Context()); ok {
+		t.Errorf("Expected no backend in an untracked context; got %v", s)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+const (
+	poolBlue  = "blue"
+	poolGreen = "green"
+)
+
+var errNoBlueGreen = errors.New("blue-green deployment is not configured")
+
+// WithBlueGreen sets up blue-green deployments: the backends passed to NewLoadBalancer are the
+// blue pool and green the standby green pool. Switch moves all new traffic from one pool to
+// the other. The standby pool is health-checked like the active one, so its state is known
+// before traffic is switched to it.
+func WithBlueGreen(green []Server) Option {
+	return func(lb *LoadBalancer) {
+		lb.blueGreen = &blueGreen{active: poolBlue, standby: slices.Clone(green)}
+	}
+}
+
+// blueGreen holds the standby pool of a blue-green deployment; the active one is the load
+// balancer's Pool.
+type blueGreen struct {
+	mu      sync.Mutex
+	active  string
+	standby []Server
+}
+
+func (bg *blueGreen) standbyServers() []Server {
+	if bg == nil {
+		return nil
+	}
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+	return bg.standby
+}
+
+// ActivePool returns the pool receiving traffic, "blue" or "green", or "" without
+// WithBlueGreen.
+func (lb *LoadBalancer) ActivePool() string {
+	if lb.blueGreen == nil {
+		return ""
+	}
+	lb.blueGreen.mu.Lock()
+	defer lb.blueGreen.mu.Unlock()
+	return lb.blueGreen.active
+}
+
+// Switch atomically sends all new requests to the standby pool, which becomes the active one,
+// and returns its name. Requests already sent to the previously active pool complete
+// normally. Switching again rolls back. Backends added to or removed from Pool belong to the
+// active pool.
+func (lb *LoadBalancer) Switch() (string, error) {
+	bg := lb.blueGreen
+	if bg == nil {
+		return "", errNoBlueGreen
+	}
+	bg.mu.Lock()
+	defer bg.mu.Unlock()
+
+	bg.standby = lb.pool.swap(bg.standby)
+	if bg.active == poolBlue {
+		bg.active = poolGreen
+	} else {
+		bg.active = poolBlue
+	}
+	fmt.Printf("Switched traffic to the %s pool\n", bg.active)
+	return bg.active, nil
+}
+
+type blueGreenStatus struct {
+	Active string `json:"active"`
+}
+
+func (lb *LoadBalancer) handleActivePool(rw http.ResponseWriter, req *http.Request) {
+	if lb.blueGreen == nil {
+		http.Error(rw, errNoBlueGreen.Error(), http.StatusNotFound)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(blueGreenStatus{Active: lb.ActivePool()})
+}
+
+// handleSwitch switches traffic to the standby pool and answers with the now active pool.
+func (lb *LoadBalancer) handleSwitch(rw http.ResponseWriter, req *http.Request) {
+	active, err := lb.Switch()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(blueGreenStatus{Active: active})
+}
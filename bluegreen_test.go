@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadBalancer_BlueGreen(t *testing.T) {
+	blue := []Server{newNamedBackend(t, "blue"), newNamedBackend(t, "blue")}
+	green := []Server{newNamedBackend(t, "green"), newNamedBackend(t, "green")}
+	lb := NewLoadBalancer("8000", blue, WithBlueGreen(green))
+	admin := lb.adminHandler()
+
+	served := func() map[string]int {
+		counts := make(map[string]int)
+		for range 6 {
+			rw := httptest.NewRecorder()
+			lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+			counts[rw.Body.String()]++
+		}
+		return counts
+	}
+	switchPools := func() string {
+		rw := httptest.NewRecorder()
+		admin.ServeHTTP(rw, httptest.NewRequest("POST", "/pools/switch", nil))
+		var status blueGreenStatus
+		if err := json.NewDecoder(rw.Body).Decode(&status); err != nil || rw.Code != http.StatusOK {
+			t.Fatalf("Expected the switch to succeed; got %d (%v)", rw.Code, err)
+		}
+		return status.Active
+	}
+
+	if active := lb.ActivePool(); active != poolBlue {
+		t.Fatalf("Expected the blue pool to be active; got %q", active)
+	}
+	if counts := served(); counts["blue"] != 6 {
+		t.Errorf("Expected all traffic to go to the blue pool; got %v", counts)
+	}
+
+	if active := switchPools(); active != poolGreen {
+		t.Errorf("Expected the green pool to be active after the switch; got %q", active)
+	}
+	if counts := served(); counts["green"] != 6 {
+		t.Errorf("Expected all traffic to move to the green pool; got %v", counts)
+	}
+
+	// Switching again rolls back.
+	if active := switchPools(); active != poolBlue {
+		t.Errorf("Expected the blue pool to be active after the rollback; got %q", active)
+	}
+	if counts := served(); counts["blue"] != 6 {
+		t.Errorf("Expected all traffic back on the blue pool; got %v", counts)
+	}
+
+	rw := httptest.NewRecorder()
+	admin.ServeHTTP(rw, httptest.NewRequest("GET", "/pools/active", nil))
+	if body, _ := io.ReadAll(rw.Body); string(body) != "{\"active\":\"blue\"}\n" {
+		t.Errorf("Expected /pools/active to report the blue pool; got %q", body)
+	}
+}
+
+func TestLoadBalancer_BlueGreenUnconfigured(t *testing.T) {
+	lb := NewLoadBalancer("8000", []Server{newNamedBackend(t, "a")})
+	if _, err := lb.Switch(); err == nil {
+		t.Errorf("Expected switching without WithBlueGreen to fail")
+	}
+	rw := httptest.NewRecorder()
+	lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("POST", "/pools/switch", nil))
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without WithBlueGreen; got %d", rw.Code)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Circuit breaker states, as reported by /status.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// WithCircuitBreaker stops sending requests to a backend once threshold attempts in a row
+// have failed with a connection error or a 5xx response. After cooldown the breaker is
+// half-open and lets a single trial request through: if it succeeds the backend is back in
+// rotation, otherwise the breaker opens for another cooldown.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(lb *LoadBalancer) {
+		lb.breakerThreshold = threshold
+		lb.breakerCooldown = cooldown
+	}
+}
+
+// WithBreaker overrides WithCircuitBreaker for this backend, e.g. to trip a fragile backend
+// sooner than the others, or to enable circuit breaking for it alone. A zero cooldown keeps
+// the load balancer's.
+func WithBreaker(threshold int, cooldown time.Duration) ServerOption {
+	return func(s *simpleServer) {
+		s.breakerThreshold = threshold
+		s.breakerCooldown = cooldown
+	}
+}
+
+func (s *simpleServer) CircuitBreaker() (threshold int, cooldown time.Duration) {
+	return s.breakerThreshold, s.breakerCooldown
+}
+
+func newBreakerSet(threshold int, cooldown time.Duration, clock Clock) *breakerSet {
+	return &breakerSet{
+		threshold: threshold,
+		cooldown:  cooldown,
+		clock:     clock,
+		states:    make(map[Server]*breaker),
+	}
+}
+
+// breakerSet holds the circuit breakers of the backends. A nil breakerSet lets every
+// request through, as do the breakers of backends whose threshold is 0.
+type breakerSet struct {
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+	// onChange is called after the breaker of s opened or closed, outside of mu.
+	onChange func(s Server, open bool)
+
+	// numOpen counts the breakers that are open or half-open.
+	numOpen atomic.Int64
+
+	mu     sync.Mutex
+	states map[Server]*breaker
+}
+
+// tripped returns how many breakers are open or half-open.
+func (bs *breakerSet) tripped() int64 {
+	if bs == nil {
+		return 0
+	}
+	return bs.numOpen.Load()
+}
+
+func (bs *breakerSet) changed(s Server, delta int64) {
+	bs.numOpen.Add(delta)
+	if bs.onChange != nil {
+		bs.onChange(s, delta > 0)
+	}
+}
+
+type breaker struct {
+	failures int
+	open     bool
+	// until is when an open breaker becomes half-open.
+	until time.Time
+	// trial is set while the half-open breaker's trial request is in flight.
+	trial bool
+}
+
+func (b *breaker) state(now time.Time) string {
+	switch {
+	case !b.open:
+		return breakerClosed
+	case now.Before(b.until):
+		return breakerOpen
+	default:
+		return breakerHalfOpen
+	}
+}
+
+// limits returns the threshold and cooldown of the breaker of s: its own WithBreaker ones,
+// falling back to the load balancer's.
+func (bs *breakerSet) limits(s Server) (threshold int, cooldown time.Duration) {
+	threshold, cooldown = bs.threshold, bs.cooldown
+	if b, ok := s.(interface {
+		CircuitBreaker() (int, time.Duration)
+	}); ok {
+		if t, c := b.CircuitBreaker(); t > 0 {
+			threshold = t
+			if c > 0 {
+				cooldown = c
+			}
+		}
+	}
+	return threshold, cooldown
+}
+
+// disabled reports whether s has no circuit breaker, so it needn't be looked up.
+func (bs *breakerSet) disabled(s Server) bool {
+	if bs == nil {
+		return true
+	}
+	threshold, _ := bs.limits(s)
+	return threshold <= 0
+}
+
+func (bs *breakerSet) get(s Server) *breaker {
+	b, ok := bs.states[s]
+	if !ok {
+		b = &breaker{}
+		bs.states[s] = b
+	}
+	return b
+}
+
+// allows reports whether s may be picked for a new request.
+func (bs *breakerSet) allows(s Server) bool {
+	if bs.disabled(s) {
+		return true
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b := bs.get(s)
+	switch b.state(bs.clock.Now()) {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		return !b.trial
+	}
+	return true
+}
+
+// acquire claims the trial request of a half-open breaker, reporting in trial whether it did.
+// It fails when another request claimed it first. A claimed trial must be ended by record,
+// or by release when the request has no outcome to count.
+func (bs *breakerSet) acquire(s Server) (ok, trial bool) {
+	if bs.disabled(s) {
+		return true, false
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b := bs.get(s)
+	switch b.state(bs.clock.Now()) {
+	case breakerOpen:
+		return false, false
+	case breakerHalfOpen:
+		if b.trial {
+			return false, false
+		}
+		b.trial = true
+		return true, true
+	}
+	return true, false
+}
+
+// release gives up the trial claimed by acquire without counting an outcome, e.g. because
+// the client went away, so that the next request can be the trial.
+func (bs *breakerSet) release(s Server) {
+	if bs.disabled(s) {
+		return
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if b, ok := bs.states[s]; ok {
+		b.trial = false
+	}
+}
+
+// record counts the outcome of a request to s.
+func (bs *breakerSet) record(s Server, failed bool) {
+	if bs.disabled(s) {
+		return
+	}
+	bs.mu.Lock()
+	delta := bs.update(s, failed)
+	bs.mu.Unlock()
+	if delta != 0 {
+		bs.changed(s, delta)
+	}
+}
+
+// update counts the outcome of a request to s, returning 1 if its breaker opened and -1 if
+// it closed. bs.mu must be held.
+func (bs *breakerSet) update(s Server, failed bool) int64 {
+	threshold, cooldown := bs.limits(s)
+	b := bs.get(s)
+	now := bs.clock.Now()
+	switch b.state(now) {
+	case breakerOpen:
+		// A request sent before the breaker opened.
+		return 0
+	case breakerHalfOpen:
+		if !b.trial {
+			return 0
+		}
+		b.trial = false
+		if failed {
+			b.until = now.Add(cooldown)
+			return 0
+		}
+		b.open, b.failures = false, 0
+		return -1
+	}
+	if !failed {
+		b.failures = 0
+		return 0
+	}
+	b.failures++
+	if b.failures >= threshold {
+		b.open, b.until = true, now.Add(cooldown)
+		return 1
+	}
+	return 0
+}
+
+// status returns the breaker state of s and, while it is open, the time until its trial request.
+func (bs *breakerSet) status(s Server) (state string, nextTrial time.Duration) {
+	if bs.disabled(s) {
+		return "", 0
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b := bs.get(s)
+	now := bs.clock.Now()
+	state = b.state(now)
+	if state == breakerOpen {
+		nextTrial = b.until.Sub(now)
+	}
+	return state, nextTrial
+}
+
+func (bs *breakerSet) forget(s Server) {
+	if bs == nil {
+		return
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	if b, ok := bs.states[s]; ok && b.open {
+		bs.numOpen.Add(-1)
+	}
+	delete(bs.states, s)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_CircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	var requests atomic.Int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		requests.Add(1)
+		if failing.Load() {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer backendServer.Close()
+
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)},
+		WithClock(clock), WithCircuitBreaker(2, 30*time.Second))
+	get := func() int {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		return rw.Code
+	}
+
+	for range 2 {
+		if code := get(); code != http.StatusInternalServerError {
+			t.Fatalf("Expected the backend's 500 while the breaker is closed; got %d", code)
+		}
+	}
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once the breaker opened; got %d", code)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Expected no requests to reach the backend while its breaker is open; got %d", n)
+	}
+
+	// The trial request fails, so the breaker opens again.
+	clock.Advance(30 * time.Second)
+	if code := get(); code != http.StatusInternalServerError {
+		t.Errorf("Expected the half-open breaker to let a trial through; got %d", code)
+	}
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected the breaker to reopen after a failed trial; got %d", code)
+	}
+
+	failing.Store(false)
+	clock.Advance(30 * time.Second)
+	for range 3 {
+		if code := get(); code != http.StatusOK {
+			t.Errorf("Expected the breaker to close after a successful trial; got %d", code)
+		}
+	}
+}
+
+func TestLoadBalancer_CircuitBreakerCancelledTrial(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	var requests atomic.Int32
+	entered := make(chan struct{}, 1)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		requests.Add(1)
+		if req.URL.Path == "/slow" {
+			entered <- struct{}{}
+			<-req.Context().Done()
+			return
+		}
+		if failing.Load() {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer backendServer.Close()
+
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)},
+		WithClock(clock), WithCircuitBreaker(1, 30*time.Second))
+	get := func() int {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		return rw.Code
+	}
+	if code := get(); code != http.StatusInternalServerError {
+		t.Fatalf("Expected the backend's 500 to trip the breaker; got %d", code)
+	}
+	failing.Store(false)
+
+	// The client of the trial request goes away before the backend answers.
+	clock.Advance(30 * time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	rw := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/slow", nil).WithContext(ctx))
+	}()
+	<-entered
+	cancel()
+	<-served
+	if rw.Code != statusClientClosedRequest {
+		t.Fatalf("Expected the cancelled trial to end with %d; got %d", statusClientClosedRequest, rw.Code)
+	}
+
+	before := requests.Load()
+	if code := get(); code != http.StatusOK {
+		t.Errorf("Expected the next request to be the trial after a cancelled one; got %d", code)
+	}
+	if requests.Load() != before+1 {
+		t.Errorf("Expected the next request to reach the backend")
+	}
+	if state, _ := lb.breakers.status(lb.pool.All()[0]); state != breakerClosed {
+		t.Errorf("Expected the successful trial to close the breaker; got %q", state)
+	}
+}
+
+func TestLoadBalancer_BackendBreakerThresholds(t *testing.T) {
+	fragile := newSimpleServer("http://fragile.internal", WithBreaker(1, 10*time.Second))
+	sturdy := newSimpleServer("http://sturdy.internal", WithBreaker(3, 0))
+	unconfigured := newSimpleServer("http://unconfigured.internal")
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", []Server{fragile, sturdy, unconfigured}, WithClock(clock), WithCircuitBreaker(2, 30*time.Second))
+
+	states := func() [3]string {
+		var got [3]string
+		for i, s := range []Server{fragile, sturdy, unconfigured} {
+			got[i], _ = lb.breakers.status(s)
+		}
+		return got
+	}
+	for i, want := range [][3]string{
+		{breakerOpen, breakerClosed, breakerClosed},
+		{breakerOpen, breakerClosed, breakerOpen},
+		{breakerOpen, breakerOpen, breakerOpen},
+	} {
+		for _, s := range []Server{fragile, sturdy, unconfigured} {
+			lb.breakers.record(s, true)
+		}
+		if got := states(); got != want {
+			t.Errorf("After %d failures: expected breakers %v; got %v", i+1, want, got)
+		}
+	}
+
+	// Only the fragile backend has a shorter cooldown; the others keep the global one.
+	clock.Advance(10 * time.Second)
+	if got, want := states(), [3]string{breakerHalfOpen, breakerOpen, breakerOpen}; got != want {
+		t.Errorf("After 10s: expected breakers %v; got %v", want, got)
+	}
+	clock.Advance(20 * time.Second)
+	if got, want := states(), [3]string{breakerHalfOpen, breakerHalfOpen, breakerHalfOpen}; got != want {
+		t.Errorf("After 30s: expected breakers %v; got %v", want, got)
+	}
+}
+
+func TestLoadBalancer_BackendBreakerWithoutGlobal(t *testing.T) {
+	var requests atomic.Int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		requests.Add(1)
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backendServer.Close()
+
+	// Only the backend enables circuit breaking.
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL, WithBreaker(2, time.Minute))}, WithClock(newFakeClock()))
+	var codes []int
+	for range 3 {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		codes = append(codes, rw.Code)
+	}
+	if codes[2] != http.StatusServiceUnavailable || requests.Load() != 2 {
+		t.Errorf("Expected the backend's own breaker to open after 2 failures; got %v with %d requests", codes, requests.Load())
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// commands are the subcommands of the binary, run with the arguments following their name.
+var commands = map[string]func(args []string, stdout io.Writer) error{
+	"serve":    func(args []string, _ io.Writer) error { return runServe(args) },
+	"validate": runValidate,
+	"version":  runVersion,
+}
+
+const usage = `Usage: load_balancer <command> [flags]
+
+Commands:
+  serve     run the load balancer (the default when the first argument is a flag)
+  validate  check a configuration file: validate -config lb.json
+  version   print build information
+
+Run "load_balancer <command> -h" for the flags of a command.
+`
+
+// parseCommand splits args into the subcommand and its arguments. Without a command, or
+// with flags only, it is "serve" so existing invocations keep working.
+func parseCommand(args []string) (name string, rest []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "serve", args
+	}
+	return args[0], args[1:]
+}
+
+// runCommand dispatches args to their subcommand.
+func runCommand(args []string, stdout io.Writer) error {
+	name, rest := parseCommand(args)
+	if name == "help" {
+		fmt.Fprint(stdout, usage)
+		return nil
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprint(stdout, usage)
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return run(rest, stdout)
+}
+
+// runValidate loads a configuration file the way serve would, reporting the first problem.
+func runValidate(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stdout)
+	configPath := fs.String("config", "", "JSON configuration file to check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return fmt.Errorf("validate: -config is required")
+	}
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if _, err := cfg.options(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: OK (%d backends)\n", *configPath, len(cfg.Backends))
+	return nil
+}
+
+// runVersion prints the version, commit and build date of the binary.
+func runVersion(args []string, stdout io.Writer) error {
+	fmt.Fprintln(stdout, buildVersion)
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantName string
+		wantRest []string
+	}{
+		{nil, "serve", nil},
+		{[]string{"-retries", "2"}, "serve", []string{"-retries", "2"}},
+		{[]string{"serve", "-coalesce"}, "serve", []string{"-coalesce"}},
+		{[]string{"validate", "-config", "lb.json"}, "validate", []string{"-config", "lb.json"}},
+		{[]string{"version"}, "version", []string{}},
+	}
+	for _, tt := range tests {
+		name, rest := parseCommand(tt.args)
+		if name != tt.wantName || !slices.Equal(rest, tt.wantRest) {
+			t.Errorf("parseCommand(%q) = %q, %q; want %q, %q", tt.args, name, rest, tt.wantName, tt.wantRest)
+		}
+	}
+}
+
+func TestRunCommand_Dispatch(t *testing.T) {
+	var served []string
+	serve := commands["serve"]
+	commands["serve"] = func(args []string, _ io.Writer) error {
+		served = args
+		return nil
+	}
+	defer func() { commands["serve"] = serve }()
+
+	if err := runCommand([]string{"-listeners", "2"}, io.Discard); err != nil || !slices.Equal(served, []string{"-listeners", "2"}) {
+		t.Errorf("Expected flags alone to run serve; got %q, %v", served, err)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand([]string{"version"}, &out); err != nil || !strings.HasPrefix(out.String(), "load_balancer ") {
+		t.Errorf("Expected version to print build information; got %q, %v", out.String(), err)
+	}
+	if err := runCommand([]string{"frobnicate"}, io.Discard); err == nil {
+		t.Errorf("Expected an unknown command to fail")
+	}
+}
+
+func TestRunCommand_Validate(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	os.WriteFile(valid, []byte(`{"backends": [{"address": "http://10.0.0.1:8080"}], "strategy": "round-robin"}`), 0o644)
+	unknownStrategy := filepath.Join(dir, "strategy.json")
+	os.WriteFile(unknownStrategy, []byte(`{"backends": [{"address": "http://10.0.0.1:8080"}], "strategy": "fastest"}`), 0o644)
+
+	var out bytes.Buffer
+	if err := runCommand([]string{"validate", "-config", valid}, &out); err != nil || !strings.Contains(out.String(), "OK (1 backends)") {
+		t.Errorf("Expected a valid config to pass; got %q, %v", out.String(), err)
+	}
+	for _, args := range [][]string{
+		{"validate", "-config", unknownStrategy},
+		{"validate", "-config", filepath.Join(dir, "missing.json")},
+		{"validate"},
+	} {
+		if err := runCommand(args, io.Discard); err == nil {
+			t.Errorf("Expected %q to fail", args)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIPResolver extracts the real client IP, honoring X-Forwarded-For only when the
+// immediate peer is one of the trusted proxies.
+type clientIPResolver struct {
+	trusted []*net.IPNet
+}
+
+// newClientIPResolver parses the trusted proxy list. Entries may be CIDRs or single IPs.
+func newClientIPResolver(trustedCIDRs []string) (*clientIPResolver, error) {
+	r := &clientIPResolver{}
+	for _, cidr := range trustedCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			// Not ip.To4, which would make "::ffff:192.0.2.1/32" trust a quarter of IPv6.
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, ipNet)
+	}
+	return r, nil
+}
+
+func (r *clientIPResolver) isTrusted(ip netip.Addr) bool {
+	parsed := net.IP(ip.WithZone("").AsSlice())
+	for _, ipNet := range r.trusted {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHopIP parses an address as found in RemoteAddr or X-Forwarded-For entries, which some
+// proxies write with a port, e.g. "[2001:db8::1]:4000", or a zone, e.g. "fe80::1%eth0".
+// IPv4-mapped IPv6 addresses from dual-stack sockets are returned as IPv4.
+func parseHopIP(hop string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(hop); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// ClientIP returns the address of the client that sent req. The X-Forwarded-For chain is walked
+// from the right, skipping trusted proxies, so clients cannot spoof entries added before ours.
+func (r *clientIPResolver) ClientIP(req *http.Request) string {
+	peer, ok := parseHopIP(req.RemoteAddr)
+	if !ok {
+		return remoteHost(req.RemoteAddr)
+	}
+	if !r.isTrusted(peer) {
+		return peer.String()
+	}
+
+	var hops []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHopIP(hops[i])
+		if !ok {
+			break
+		}
+		client = hop
+		if !r.isTrusted(client) {
+			break
+		}
+	}
+	return client.String()
+}
+
+type clientIPContextKey struct{}
+
+// clientIP returns the client address resolved by realIPMiddleware, or the peer address.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey{}).(string); ok {
+		return ip
+	}
+	return remoteHost(r.RemoteAddr)
+}
+
+// Middleware to resolve the real client IP once so rate limiting, ACLs and hashing agree on it
+func realIPMiddleware(resolver *clientIPResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPContextKey{}, resolver.ClientIP(r))
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := newClientIPResolver([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32", "::ffff:192.0.2.9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		want       string
+	}{
+		{"trusted peer with XFF", "10.1.2.3:4000", "203.0.113.7", "203.0.113.7"},
+		{"trusted peer with proxy chain", "192.0.2.1:4000", "198.51.100.1, 203.0.113.7, 10.0.0.5", "203.0.113.7"},
+		{"untrusted peer with XFF", "198.51.100.9:4000", "203.0.113.7", "198.51.100.9"},
+		{"trusted peer without XFF", "10.1.2.3:4000", "", "10.1.2.3"},
+		{"trusted peer with garbage XFF", "10.1.2.3:4000", "not-an-ip", "10.1.2.3"},
+		{"trusted IPv6 peer with IPv6 XFF", "[2001:db8::5]:4000", "2606:4700::1111, 2001:DB8::7", "2606:4700::1111"},
+		{"XFF entries with ports", "10.1.2.3:4000", "[2606:4700::1111]:51000, 203.0.113.7:51000", "203.0.113.7"},
+		{"bracketed IPv6 XFF with port", "10.1.2.3:4000", "[2606:4700::1111]:51000", "2606:4700::1111"},
+		{"untrusted IPv6 peer", "[2606:4700::1111]:4000", "203.0.113.7", "2606:4700::1111"},
+		{"IPv4-mapped trusted peer", "[::ffff:10.1.2.3]:4000", "203.0.113.7", "203.0.113.7"},
+		{"IPv4-mapped trusted IP only trusts itself", "[::1]:4000", "203.0.113.7", "::1"},
+		{"IPv4-mapped trusted IP", "192.0.2.9:4000", "203.0.113.7", "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if got := resolver.ClientIP(req); got != tt.want {
+				t.Errorf("Expected client IP %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRealIPMiddleware(t *testing.T) {
+	resolver, err := newClientIPResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	handler := realIPMiddleware(resolver, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		got = clientIP(req)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:4000"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "203.0.113.7" {
+		t.Errorf("Expected client IP from context to be %q; got %q", "203.0.113.7", got)
+	}
+}
+
+func TestLoadBalancer_IPv6(t *testing.T) {
+	listen := func() net.Listener {
+		l, err := net.Listen("tcp6", "[::1]:0")
+		if err != nil {
+			t.Skipf("IPv6 loopback unavailable: %v", err)
+		}
+		return l
+	}
+
+	var probes atomic.Int32
+	backendServer := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			probes.Add(1)
+			return
+		}
+		fmt.Fprintf(rw, "host=%s xff=%s", req.Host, req.Header.Get("X-Forwarded-For"))
+	}))
+	backendServer.Listener = listen()
+	backendServer.Start()
+	defer backendServer.Close()
+	if !strings.HasPrefix(backendServer.URL, "http://[::1]:") {
+		t.Fatalf("Expected an IPv6 backend URL; got %q", backendServer.URL)
+	}
+
+	server := newSimpleServer(backendServer.URL)
+	lb := NewLoadBalancer("8000", []Server{server}, WithClock(newFakeClock()), WithHealthCheck(time.Second, time.Second))
+	lb.health.probeDue(lb.pool.All())
+	if probes.Load() != 1 || !lb.isAlive(server) {
+		t.Fatalf("Expected the IPv6 backend to pass its health check")
+	}
+
+	// The client reaches a trusted proxy over IPv6, which forwards its IPv6 address.
+	resolver, err := newClientIPResolver([]string{"::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resolved string
+	front := httptest.NewUnstartedServer(realIPMiddleware(resolver, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		resolved = clientIP(req)
+		lb.serveProxy(rw, req)
+	})))
+	front.Listener = listen()
+	front.Start()
+	defer front.Close()
+
+	req, _ := http.NewRequest("GET", front.URL, nil)
+	req.Header.Set("X-Forwarded-For", "2001:db8::7")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	host := strings.TrimPrefix(backendServer.URL, "http://")
+	if want := "host=" + host + " xff=2001:db8::7, ::1"; string(body) != want {
+		t.Errorf("Expected the backend to see %q; got %q", want, body)
+	}
+	if resolved != "2001:db8::7" {
+		t.Errorf("Expected the client IP from X-Forwarded-For behind the IPv6 proxy; got %q", resolved)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// WithClientConcurrencyLimit caps how many requests a single client IP may have in flight at
+// once, so that one client can't saturate the backends. Requests over the limit get 429 Too
+// Many Requests. Behind proxies, -trusted-proxies must be set for the client IP to be the
+// real one rather than the proxy's.
+func WithClientConcurrencyLimit(n int) Option {
+	return func(lb *LoadBalancer) {
+		lb.clientLimit = &clientLimiter{limit: n, active: make(map[string]int)}
+	}
+}
+
+// clientLimiter counts the requests in flight per client IP.
+type clientLimiter struct {
+	limit int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// acquire takes a slot for client, reporting false when it already has limit requests in
+// flight. Every successful acquire must be followed by release.
+func (cl *clientLimiter) acquire(client string) bool {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.active[client] >= cl.limit {
+		return false
+	}
+	cl.active[client]++
+	return true
+}
+
+func (cl *clientLimiter) release(client string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	// Idle clients are forgotten, so the map only holds clients with requests in flight.
+	if cl.active[client]--; cl.active[client] <= 0 {
+		delete(cl.active, client)
+	}
+}
+
+// limitClient enforces the per-client concurrency limit, answering 429 Too Many Requests
+// when the client is over it. When it reports that the request may proceed, release must be
+// called once it is done.
+func (lb *LoadBalancer) limitClient(rw http.ResponseWriter, req *http.Request) (release func(), ok bool) {
+	if lb.clientLimit == nil {
+		return func() {}, true
+	}
+	client := clientIP(req)
+	if !lb.clientLimit.acquire(client) {
+		lb.writeError(rw, req, http.StatusTooManyRequests, "too many concurrent requests")
+		return nil, false
+	}
+	return func() { lb.clientLimit.release(client) }, true
+}
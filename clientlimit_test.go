@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestLoadBalancer_ClientConcurrencyLimit(t *testing.T) {
+	started, unblock := make(chan struct{}), make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-unblock
+		}
+	}))
+	defer backendServer.Close()
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithClientConcurrencyLimit(2))
+
+	request := func(client, path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = client + ":51234"
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, req)
+		return rw.Code
+	}
+
+	// The greedy client fills its limit with requests that stay in flight.
+	var wg sync.WaitGroup
+	statuses := make(chan int, 2)
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses <- request("192.0.2.1", "/slow")
+		}()
+		<-started
+	}
+
+	if status := request("192.0.2.1", "/"); status != http.StatusTooManyRequests {
+		t.Errorf("Expected a request over the client's limit to get 429; got %d", status)
+	}
+	if status := request("192.0.2.2", "/"); status != http.StatusOK {
+		t.Errorf("Expected another client to be unaffected; got %d", status)
+	}
+
+	close(unblock)
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		if status != http.StatusOK {
+			t.Errorf("Expected the requests within the limit to succeed; got %d", status)
+		}
+	}
+	if status := request("192.0.2.1", "/"); status != http.StatusOK {
+		t.Errorf("Expected the client to be admitted again once its requests finished; got %d", status)
+	}
+	if n := len(lb.clientLimit.active); n != 0 {
+		t.Errorf("Expected idle clients to be forgotten; %d remain", n)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import "time"
+
+// Clock is the source of time for the health checker and other timed schedulers, so tests
+// can drive them deterministically instead of sleeping.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// WithClock replaces the real clock used by the health checker and webhook retries.
+func WithClock(c Clock) Option {
+	return func(lb *LoadBalancer) {
+		lb.clock = c
+	}
+}
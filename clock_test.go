@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced Clock for tests.
+type fakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	c := &fakeClock{now: time.Unix(0, 0)}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	c.cond.Broadcast()
+	return ch
+}
+
+// Advance moves the clock forward, firing every timer that has become due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = pending
+}
+
+// BlockUntil waits until n timers are pending, i.e. the code under test is idle.
+func (c *fakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+func TestFakeClock_DrivesHealthChecks(t *testing.T) {
+	var probes atomic.Int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		probes.Add(1)
+	}))
+	defer backendServer.Close()
+
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)},
+		WithHealthCheck(10*time.Second, time.Minute), WithClock(clock))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := lb.StartHealthChecks(ctx)
+
+	// The first cycle runs right away, then the checker waits for the next interval.
+	clock.BlockUntil(1)
+	if probes.Load() != 1 {
+		t.Fatalf("Expected 1 probe at startup; got %d", probes.Load())
+	}
+
+	clock.Advance(9 * time.Second)
+	if probes.Load() != 1 {
+		t.Fatalf("Expected no probe before the interval elapsed; got %d", probes.Load())
+	}
+
+	for want := int32(2); want <= 4; want++ {
+		clock.Advance(10 * time.Second)
+		clock.BlockUntil(1)
+		if probes.Load() != want {
+			t.Fatalf("Expected %d probes; got %d", want, probes.Load())
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the health checks to stop once cancelled")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultCoalesceHeaders are the request headers that, besides the method and URL, must match
+// for requests to share a response.
+var defaultCoalesceHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie"}
+
+// WithCoalescing makes concurrent identical GET and HEAD requests share a single upstream
+// call, which protects backends from stampedes on cache misses. Requests are identical when
+// their method, URL and the default headers plus the given ones match. Shared responses are
+// buffered in memory, so this is not meant for large downloads or streams.
+func WithCoalescing(headers ...string) Option {
+	return func(lb *LoadBalancer) {
+		lb.coalescer = &coalescer{
+			headers: append(append([]string(nil), defaultCoalesceHeaders...), headers...),
+			calls:   make(map[string]*coalescedCall),
+		}
+	}
+}
+
+type coalescer struct {
+	headers []string
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall is an upstream call shared by identical requests; resp is set before done
+// is closed.
+type coalescedCall struct {
+	done chan struct{}
+	resp *bufferedResponse
+}
+
+func (c *coalescer) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(r.Host)
+	b.WriteString(r.URL.RequestURI())
+	for _, h := range c.headers {
+		b.WriteByte('\n')
+		b.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// serve answers r through serve, unless an identical request is already in flight, in which
+// case it waits for that request's response instead.
+func (c *coalescer) serve(rw http.ResponseWriter, r *http.Request, serve http.HandlerFunc) {
+	key := c.key(r)
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			call.resp.writeTo(rw)
+		case <-r.Context().Done():
+		}
+		return
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	// The upstream call is shared, so it must outlive this client disconnecting.
+	resp := newBufferedResponse()
+	serve(resp, r.WithContext(context.WithoutCancel(r.Context())))
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	call.resp = resp
+	close(call.done)
+
+	resp.writeTo(rw)
+}
+
+// bufferedResponse is an http.ResponseWriter keeping the whole response in memory. It makes
+// no assumption about the upstream length: chunked bodies are collected until the end, and
+// trailers, which the ReverseProxy sets as http.TrailerPrefix headers, are replayed as such.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header)}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	// Interim responses such as 100 Continue, relayed while the request body is sent, are
+	// followed by the real status.
+	if b.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) writeTo(rw http.ResponseWriter) {
+	maps.Copy(rw.Header(), b.header)
+	rw.WriteHeader(max(b.status, http.StatusOK))
+	rw.Write(b.body.Bytes())
+}
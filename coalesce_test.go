@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_Coalescing(t *testing.T) {
+	var hits atomic.Int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		hits.Add(1)
+		started <- struct{}{}
+		<-release
+		rw.Header().Set("X-Cache", "miss")
+		rw.Write([]byte("expensive result"))
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithCoalescing())
+
+	const clients = 50
+	responses := make([]*httptest.ResponseRecorder, clients)
+	var wg sync.WaitGroup
+	for i := range responses {
+		responses[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lb.serveProxy(responses[i], httptest.NewRequest("GET", "/report?id=7", nil))
+		}()
+	}
+
+	// Let the other requests pile up behind the first one before it completes.
+	<-started
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected identical requests to share one upstream call; got %d", got)
+	}
+	for i, rw := range responses {
+		if rw.Code != http.StatusOK || rw.Body.String() != "expensive result" || rw.Header().Get("X-Cache") != "miss" {
+			t.Fatalf("Expected response %d to be the shared one; got %d %q", i, rw.Code, rw.Body.String())
+		}
+	}
+
+	// Requests differing in a keyed header are not coalesced.
+	for _, accept := range []string{"text/csv", "application/json"} {
+		req := httptest.NewRequest("GET", "/report?id=7", nil)
+		req.Header.Set("Accept", accept)
+		lb.serveProxy(httptest.NewRecorder(), req)
+		<-started
+	}
+	if got := hits.Load(); got != 3 {
+		t.Errorf("Expected distinct requests to reach the backend; got %d hits", got)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Config describes a load balancer, as loaded from a JSON file by LoadConfig.
+type Config struct {
+	Port     string          `json:"port"`
+	Backends []BackendConfig `json:"backends"`
+	// Green is the standby pool of a blue-green deployment, Backends being the blue one; see
+	// WithBlueGreen.
+	Green []BackendConfig `json:"green"`
+	// Strategy names a registered strategy; see RegisterStrategy.
+	Strategy        string         `json:"strategy"`
+	StrategyOptions StrategyConfig `json:"strategy_options"`
+	// Middleware orders the middleware of the listener, outermost first, e.g. ["recovery",
+	// "real-ip", "access-log", "logging", "request-id", "grpc-web"], the default. Middleware
+	// that isn't enabled by its flag is skipped; middleware left out isn't applied.
+	Middleware []string `json:"middleware"`
+	// ErrorPages maps statuses of errors generated by the load balancer, such as "503", to
+	// custom pages; see WithErrorPages.
+	ErrorPages map[string]ErrorPageConfig `json:"error_pages"`
+}
+
+// ErrorPageConfig is a custom error page of a Config, read from File or given inline as Body.
+type ErrorPageConfig struct {
+	File string `json:"file"`
+	Body string `json:"body"`
+	// ContentType defaults to the one of File's extension, or the one sniffed from the page.
+	ContentType string `json:"content_type"`
+}
+
+// BackendConfig describes one backend of a Config.
+type BackendConfig struct {
+	Address  string            `json:"address"`
+	Weight   int               `json:"weight"`
+	Priority int               `json:"priority"`
+	Tags     map[string]string `json:"tags"`
+	// Headers are set on every request proxied to the backend; see WithRequestHeaders.
+	Headers map[string]string `json:"headers"`
+	// HealthyStatuses are the health-check statuses that count as healthy, e.g. "200-299,302";
+	// see WithHealthyStatuses.
+	HealthyStatuses string `json:"healthy_statuses"`
+	// BreakerThreshold and BreakerCooldown, e.g. "10s", override the circuit breaker for the
+	// backend; see WithBreaker.
+	BreakerThreshold int    `json:"breaker_threshold"`
+	BreakerCooldown  string `json:"breaker_cooldown"`
+	// HealthInterval, e.g. "2s", overrides how often the backend is health-checked; see
+	// WithHealthInterval.
+	HealthInterval string `json:"health_interval"`
+	// HealthCheck is "http", the default, "tcp" to only check that a connection can be
+	// opened, or "grpc" to call the gRPC health service for HealthCheckService, within
+	// HealthCheckTimeout if set; see WithTCPHealthCheck and WithGRPCHealthCheck.
+	HealthCheck        string `json:"health_check"`
+	HealthCheckService string `json:"health_check_service"`
+	HealthCheckTimeout string `json:"health_check_timeout"`
+}
+
+// LoadConfig reads a Config from the JSON file at path.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func parseConfig(r io.Reader) (*Config, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Backends) == 0 {
+		return nil, errors.New("no backends configured")
+	}
+	if err := validateBackends("backend", cfg.Backends); err != nil {
+		return nil, err
+	}
+	if err := validateBackends("green backend", cfg.Green); err != nil {
+		return nil, err
+	}
+	if cfg.Middleware != nil {
+		if err := validateMiddlewareOrder(cfg.Middleware); err != nil {
+			return nil, err
+		}
+	}
+	for code, page := range cfg.ErrorPages {
+		status, err := strconv.Atoi(code)
+		if err != nil || !slices.Contains(errorPageStatuses, status) {
+			return nil, fmt.Errorf("error page %q: not a status of an error generated by the load balancer %v", code, errorPageStatuses)
+		}
+		if (page.File == "") == (page.Body == "") {
+			return nil, fmt.Errorf("error page %q: exactly one of file and body must be set", code)
+		}
+	}
+	if cfg.Port == "" {
+		cfg.Port = "8000"
+	}
+	return &cfg, nil
+}
+
+func validateBackends(kind string, backends []BackendConfig) error {
+	for i, b := range backends {
+		u, err := url.Parse(b.Address)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s %d: invalid address %q", kind, i, b.Address)
+		}
+		if b.Weight < 0 {
+			return fmt.Errorf("%s %d: negative weight %d", kind, i, b.Weight)
+		}
+		if b.HealthyStatuses != "" {
+			if _, err := parseStatusRanges(b.HealthyStatuses); err != nil {
+				return fmt.Errorf("%s %d: healthy_statuses: %w", kind, i, err)
+			}
+		}
+		if b.BreakerThreshold < 0 {
+			return fmt.Errorf("%s %d: negative breaker_threshold %d", kind, i, b.BreakerThreshold)
+		}
+		if b.BreakerCooldown != "" {
+			if d, err := time.ParseDuration(b.BreakerCooldown); err != nil || d < 0 {
+				return fmt.Errorf("%s %d: invalid breaker_cooldown %q", kind, i, b.BreakerCooldown)
+			}
+		}
+		if b.HealthInterval != "" {
+			if d, err := time.ParseDuration(b.HealthInterval); err != nil || d <= 0 {
+				return fmt.Errorf("%s %d: invalid health_interval %q", kind, i, b.HealthInterval)
+			}
+		}
+		switch b.HealthCheck {
+		case "", "http", "tcp", "grpc":
+		default:
+			return fmt.Errorf("%s %d: unknown health_check %q", kind, i, b.HealthCheck)
+		}
+		if b.HealthCheckTimeout != "" {
+			if d, err := time.ParseDuration(b.HealthCheckTimeout); err != nil || d <= 0 {
+				return fmt.Errorf("%s %d: invalid health_check_timeout %q", kind, i, b.HealthCheckTimeout)
+			}
+		}
+	}
+	return nil
+}
+
+// NewLoadBalancerFromConfig creates the load balancer described by cfg. opts are applied after
+// the configuration, so they take precedence.
+func NewLoadBalancerFromConfig(cfg *Config, opts ...Option) (*LoadBalancer, error) {
+	cfgOpts, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
+	if bg := cfg.blueGreen(); bg != nil {
+		cfgOpts = append(cfgOpts, bg)
+	}
+	return NewLoadBalancer(cfg.Port, cfg.servers(), append(cfgOpts, opts...)...), nil
+}
+
+// servers creates the configured backends, applying opts to each of them.
+func (cfg *Config) servers(opts ...ServerOption) []Server {
+	return newServers(cfg.Backends, opts)
+}
+
+// blueGreen returns the option setting up the configured green pool, or nil without one.
+func (cfg *Config) blueGreen(opts ...ServerOption) Option {
+	if len(cfg.Green) == 0 {
+		return nil
+	}
+	return WithBlueGreen(newServers(cfg.Green, opts))
+}
+
+func newServers(backends []BackendConfig, opts []ServerOption) []Server {
+	servers := make([]Server, 0, len(backends))
+	for _, b := range backends {
+		serverOpts := append([]ServerOption{WithTags(b.Tags), withBackendConfig(b)}, opts...)
+		if b.Weight > 0 {
+			serverOpts = append(serverOpts, WithWeight(b.Weight))
+		}
+		if b.Priority != 0 {
+			serverOpts = append(serverOpts, WithPriority(b.Priority))
+		}
+		if len(b.Headers) > 0 {
+			headers := make(http.Header, len(b.Headers))
+			for key, value := range b.Headers {
+				headers.Set(key, value)
+			}
+			serverOpts = append(serverOpts, WithRequestHeaders(headers))
+		}
+		if b.HealthyStatuses != "" {
+			// Validated when the config was parsed.
+			ranges, _ := parseStatusRanges(b.HealthyStatuses)
+			serverOpts = append(serverOpts, WithHealthyStatuses(ranges...))
+		}
+		if b.BreakerThreshold > 0 {
+			// Validated when the config was parsed.
+			cooldown, _ := time.ParseDuration(b.BreakerCooldown)
+			serverOpts = append(serverOpts, WithBreaker(b.BreakerThreshold, cooldown))
+		}
+		if b.HealthInterval != "" {
+			// Validated when the config was parsed.
+			interval, _ := time.ParseDuration(b.HealthInterval)
+			serverOpts = append(serverOpts, WithHealthInterval(interval))
+		}
+		// Validated when the config was parsed.
+		healthTimeout, _ := time.ParseDuration(b.HealthCheckTimeout)
+		switch b.HealthCheck {
+		case "tcp":
+			serverOpts = append(serverOpts, WithTCPHealthCheck(healthTimeout))
+		case "grpc":
+			serverOpts = append(serverOpts, WithGRPCHealthCheck(b.HealthCheckService, healthTimeout))
+		}
+		servers = append(servers, newSimpleServer(b.Address, serverOpts...))
+	}
+	return servers
+}
+
+// options returns the load balancer options set by cfg.
+func (cfg *Config) options() ([]Option, error) {
+	var opts []Option
+	if cfg.Strategy != "" {
+		strategy, err := newStrategy(cfg.Strategy, cfg.StrategyOptions)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithStrategy(strategy))
+	}
+	if len(cfg.ErrorPages) > 0 {
+		pages := make(map[int]ErrorPage, len(cfg.ErrorPages))
+		for code, pageCfg := range cfg.ErrorPages {
+			// Validated when the config was parsed.
+			status, _ := strconv.Atoi(code)
+			page := ErrorPage{Body: []byte(pageCfg.Body)}
+			if pageCfg.File != "" {
+				var err error
+				if page, err = LoadErrorPage(pageCfg.File); err != nil {
+					return nil, fmt.Errorf("error page %q: %w", code, err)
+				}
+			}
+			if pageCfg.ContentType != "" {
+				page.ContentType = pageCfg.ContentType
+			}
+			pages[status] = page
+		}
+		opts = append(opts, WithErrorPages(pages))
+	}
+	return opts, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pinnedStrategy always picks the candidate with the configured address.
+type pinnedStrategy struct{ address string }
+
+func (p *pinnedStrategy) Next(r *http.Request, candidates []Candidate) Server {
+	for _, c := range candidates {
+		if c.Server.Address() == p.address {
+			return c.Server
+		}
+	}
+	return candidates[0].Server
+}
+
+func TestNewLoadBalancerFromConfig_RegisteredStrategy(t *testing.T) {
+	RegisterStrategy("test-pinned", func(cfg StrategyConfig) Strategy {
+		return &pinnedStrategy{address: cfg["address"]}
+	})
+
+	a, b := newStatusBackend(t, http.StatusOK, "a"), newStatusBackend(t, http.StatusOK, "b")
+	cfg, err := parseConfig(strings.NewReader(`{
+		"port": "9000",
+		"backends": [{"address": "` + a.URL + `"}, {"address": "` + b.URL + `", "weight": 3, "tags": {"tier": "premium"}}],
+		"strategy": "test-pinned",
+		"strategy_options": {"address": "` + b.URL + `"}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb, err := NewLoadBalancerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lb.port != "9000" {
+		t.Errorf("Expected port 9000; got %q", lb.port)
+	}
+	for i := 0; i < 3; i++ {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		if rw.Body.String() != "b" {
+			t.Errorf("Expected the configured strategy to pin requests to b; got %q", rw.Body.String())
+		}
+	}
+	backend := lb.Pool().All()[1].(*simpleServer)
+	if backend.Weight() != 3 || backend.Tags()["tier"] != "premium" {
+		t.Errorf("Expected weight and tags from the config; got %d %v", backend.Weight(), backend.Tags())
+	}
+}
+
+func TestRegisterStrategy_Duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering a built-in name again to panic")
+		}
+	}()
+	RegisterStrategy("round-robin", func(StrategyConfig) Strategy { return &roundRobin{} })
+}
+
+func TestParseConfig_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"no backends":      `{"backends": []}`,
+		"bad address":      `{"backends": [{"address": "not a url"}]}`,
+		"unknown field":    `{"backends": [{"address": "http://a.internal"}], "stratgy": "round-robin"}`,
+		"negative weight":  `{"backends": [{"address": "http://a.internal", "weight": -1}]}`,
+		"bad statuses":     `{"backends": [{"address": "http://a.internal", "healthy_statuses": "200-299,3xx"}]}`,
+		"inverted range":   `{"backends": [{"address": "http://a.internal", "healthy_statuses": "299-200"}]}`,
+		"bad cooldown":     `{"backends": [{"address": "http://a.internal", "breaker_threshold": 3, "breaker_cooldown": "soon"}]}`,
+		"negative breaker": `{"backends": [{"address": "http://a.internal", "breaker_threshold": -1}]}`,
+		"bad interval":     `{"backends": [{"address": "http://a.internal", "health_interval": "0s"}]}`,
+		"unknown check":    `{"backends": [{"address": "http://a.internal", "health_check": "icmp"}]}`,
+		"bad timeout":      `{"backends": [{"address": "http://a.internal", "health_check": "tcp", "health_check_timeout": "-1s"}]}`,
+		"error page 200":   `{"backends": [{"address": "http://a.internal"}], "error_pages": {"200": {"body": "ok"}}}`,
+		"empty error page": `{"backends": [{"address": "http://a.internal"}], "error_pages": {"503": {}}}`,
+	}
+	for name, input := range tests {
+		if _, err := parseConfig(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	cfg, err := parseConfig(strings.NewReader(`{"backends": [{"address": "http://a.internal"}], "strategy": "no-such-strategy"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLoadBalancerFromConfig(cfg); err == nil {
+		t.Errorf("Expected an unknown strategy to be rejected")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Resolver looks up the IP addresses of a host. *net.Resolver implements it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// dnsRefresher resolves a backend's hostname on every new connection and remembers the last
+// answer, so a changed record can be detected and pooled connections to old IPs dropped.
+type dnsRefresher struct {
+	resolver Resolver
+	dialer   net.Dialer
+
+	mu    sync.Mutex
+	addrs []string
+}
+
+// WithDNSRefresh makes the backend follow DNS changes without a restart: new connections are
+// dialed to freshly resolved addresses, and each health check re-resolves the hostname and
+// closes idle connections when its addresses changed. A nil resolver uses the system one.
+func WithDNSRefresh(resolver Resolver) ServerOption {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return func(s *simpleServer) {
+		s.dns = &dnsRefresher{
+			resolver: resolver,
+			dialer:   net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		}
+		s.transport.DialContext = s.dns.dialContext
+	}
+}
+
+// lookup resolves host and reports whether the answer differs from the previous one.
+func (d *dnsRefresher) lookup(ctx context.Context, host string) (addrs []string, changed bool, err error) {
+	addrs, err = d.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(addrs) == 0 {
+		return nil, false, fmt.Errorf("no addresses found for %q", host)
+	}
+	addrs = slices.Clone(addrs)
+	slices.Sort(addrs)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	changed = d.addrs != nil && !slices.Equal(d.addrs, addrs)
+	d.addrs = addrs
+	return addrs, changed, nil
+}
+
+func (d *dnsRefresher) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, _, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// refreshDNS re-resolves the backend's hostname, closing idle connections if it now
+// resolves to different addresses.
+func (s *simpleServer) refreshDNS(ctx context.Context) {
+	host := s.target.Hostname()
+	if s.dns == nil || net.ParseIP(host) != nil {
+		return
+	}
+	addrs, changed, err := s.dns.lookup(ctx, host)
+	if err != nil {
+		fmt.Printf("Resolving backend %q failed: %v\n", s.address, err)
+		return
+	}
+	if changed {
+		fmt.Printf("Backend %q now resolves to %v\n", s.address, addrs)
+		s.transport.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type fakeResolver struct {
+	mu    sync.Mutex
+	hosts map[string][]string
+}
+
+func (r *fakeResolver) set(host string, addrs ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts[host] = addrs
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// serveName serves name on ln until the test ends.
+func serveName(t *testing.T, ln net.Listener, name string) {
+	backend := &httptest.Server{
+		Listener: ln,
+		Config: &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte(name))
+		})},
+	}
+	backend.Start()
+	t.Cleanup(backend.Close)
+}
+
+func TestSimpleServer_DNSRefresh(t *testing.T) {
+	oldLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(oldLn.Addr().(*net.TCPAddr).Port)
+	newLn, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", port))
+	if err != nil {
+		oldLn.Close()
+		t.Skipf("Loopback address 127.0.0.2 unavailable: %v", err)
+	}
+	serveName(t, oldLn, "old")
+	serveName(t, newLn, "new")
+
+	resolver := &fakeResolver{hosts: make(map[string][]string)}
+	resolver.set("backend.internal", "127.0.0.1")
+	server := newSimpleServer("http://backend.internal:"+port, WithDNSRefresh(resolver))
+	lb := NewLoadBalancer("8000", []Server{server})
+
+	serve := func() string {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		return rw.Body.String()
+	}
+
+	if got := serve(); got != "old" {
+		t.Fatalf("Expected the backend's current IP to serve the request; got %q", got)
+	}
+
+	// The record changes; the next health check notices and drops the pooled connection.
+	resolver.set("backend.internal", "127.0.0.2")
+	if !server.IsAlive() {
+		t.Fatalf("Expected the backend to be alive at its new IP")
+	}
+	for i := 0; i < 3; i++ {
+		if got := serve(); got != "new" {
+			t.Errorf("Expected requests to follow the DNS change; got %q", got)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// drainPollInterval is how often a draining backend is checked for in-flight requests.
+const drainPollInterval = 100 * time.Millisecond
+
+// Drain stops sending new requests to s while letting its in-flight requests finish, for
+// example before taking the backend down for maintenance.
+func (lb *LoadBalancer) Drain(s Server) {
+	if lb.setDraining(s, true) {
+		fmt.Printf("Draining backend %q\n", s.Address())
+		lb.emit(Event{Type: EventBackendDraining, Backend: s})
+	}
+}
+
+// DrainAndWait drains s and waits until its in-flight requests have completed, then closes its
+// idle connections. Requests are counted one by one, so for an HTTP/2 backend it waits for
+// every stream multiplexed on its connections, not just for the connections to go idle.
+func (lb *LoadBalancer) DrainAndWait(ctx context.Context, s Server) error {
+	lb.Drain(s)
+	if err := lb.waitIdle(ctx, s); err != nil {
+		return err
+	}
+	if c, ok := s.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+	return nil
+}
+
+// waitIdle waits until s has no in-flight requests.
+func (lb *LoadBalancer) waitIdle(ctx context.Context, s Server) error {
+	for lb.statsFor(s).activeRequests.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-lb.clock.After(drainPollInterval):
+		}
+	}
+	return nil
+}
+
+// DrainInstance drains the whole load balancer until UndrainInstance: /ready answers 503 so
+// orchestrators stop sending traffic, while requests in flight, and any that still arrive,
+// are served.
+func (lb *LoadBalancer) DrainInstance() {
+	if !lb.adminDrained.Swap(true) {
+		fmt.Println("Draining the load balancer")
+	}
+}
+
+// UndrainInstance cancels DrainInstance. The load balancer keeps draining while its
+// WithDrainFile exists.
+func (lb *LoadBalancer) UndrainInstance() {
+	if lb.adminDrained.Swap(false) {
+		fmt.Println("The load balancer is no longer draining")
+	}
+}
+
+// Draining reports whether the whole load balancer is draining, through DrainInstance or
+// its drain file.
+func (lb *LoadBalancer) Draining() bool {
+	return lb.adminDrained.Load() || lb.fileDrained.Load()
+}
+
+// inFlight returns how many requests are being proxied to any backend.
+func (lb *LoadBalancer) inFlight() int64 {
+	var n int64
+	for _, s := range lb.allServers() {
+		n += lb.statsFor(s).activeRequests.Load()
+	}
+	return n
+}
+
+// waitAllIdle waits until no request is being proxied.
+func (lb *LoadBalancer) waitAllIdle(ctx context.Context) error {
+	for lb.inFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-lb.clock.After(drainPollInterval):
+		}
+	}
+	return nil
+}
+
+// Undrain puts a drained backend back into rotation.
+func (lb *LoadBalancer) Undrain(s Server) {
+	if lb.setDraining(s, false) {
+		fmt.Printf("Backend %q is no longer draining\n", s.Address())
+		lb.emit(Event{Type: EventBackendUndrained, Backend: s})
+	}
+}
+
+// setDraining reports whether the draining state of s changed.
+func (lb *LoadBalancer) setDraining(s Server, draining bool) bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if lb.draining[s] == draining {
+		return false
+	}
+	if draining {
+		if lb.draining == nil {
+			lb.draining = make(map[Server]bool)
+		}
+		lb.draining[s] = true
+	} else {
+		delete(lb.draining, s)
+	}
+	lb.pool.invalidate()
+	return true
+}
+
+func (lb *LoadBalancer) isDraining(s Server) bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	return lb.draining[s]
+}
+
+// allDraining reports whether servers is non-empty and every one of them is draining.
+func (lb *LoadBalancer) allDraining(servers []Server) bool {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	for _, s := range servers {
+		if !lb.draining[s] {
+			return false
+		}
+	}
+	return len(servers) > 0
+}
+
+// groupFor returns the backends that should serve req within route, which is nil for the
+// default group. A group whose backends are all draining hands its requests to the route's
+// fallback; without one ok is false and the request is answered with a 503. A group with
+// fewer than the route's MinHealthy available backends fails over to the fallback too, if
+// the fallback has an available backend.
+func (lb *LoadBalancer) groupFor(route *Route) (servers []Server, ok bool) {
+	servers = lb.pool.All()
+	if route != nil && route.Servers != nil {
+		servers = route.Servers
+	}
+	if !lb.allDraining(servers) {
+		if route != nil && route.MinHealthy > 0 && len(route.Fallback) > 0 &&
+			lb.countAvailable(servers) < route.MinHealthy && lb.countAvailable(route.Fallback) > 0 {
+			return route.Fallback, true
+		}
+		return servers, true
+	}
+	if route != nil && len(route.Fallback) > 0 {
+		return route.Fallback, true
+	}
+	return nil, false
+}
+
+// countAvailable returns how many of servers can take new requests.
+func (lb *LoadBalancer) countAvailable(servers []Server) int {
+	n := 0
+	for _, s := range servers {
+		if lb.available(s) {
+			n++
+		}
+	}
+	return n
+}
+
+// writeDraining answers a request whose backends are all draining.
+func (lb *LoadBalancer) writeDraining(rw http.ResponseWriter, req *http.Request) {
+	lb.writeError(rw, req, http.StatusServiceUnavailable, "all backends are draining")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_Drain(t *testing.T) {
+	a, b := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	lb := NewLoadBalancer("8000", []Server{a, b})
+
+	lb.Drain(a)
+	for i := 0; i < 3; i++ {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		if rw.Body.String() != "b" {
+			t.Errorf("Expected draining backend to get no requests; got %q", rw.Body.String())
+		}
+	}
+
+	lb.Undrain(a)
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		seen[rw.Body.String()] = true
+	}
+	if !seen["a"] {
+		t.Errorf("Expected undrained backend to get requests again")
+	}
+}
+
+func TestLoadBalancer_AllDraining(t *testing.T) {
+	api := []Server{newNamedBackend(t, "api-1"), newNamedBackend(t, "api-2")}
+	standby := newNamedBackend(t, "standby")
+	apiRoute := &Route{PathPrefix: "/api", Servers: api, Fallback: []Server{standby}}
+	lb := NewLoadBalancer("8000", api, WithRoutes(apiRoute))
+	for _, s := range api {
+		lb.Drain(s)
+	}
+
+	// The route falls back to its standby group...
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/api/users", nil))
+	if rw.Code != http.StatusOK || rw.Body.String() != "standby" {
+		t.Errorf("Expected the fallback group to serve the request; got %d %q", rw.Code, rw.Body.String())
+	}
+
+	// ...while the default group, which has no fallback, fails fast.
+	rw = httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when all backends are draining; got %d", rw.Code)
+	}
+}
+
+func TestLoadBalancer_MinHealthyFailover(t *testing.T) {
+	var healthy [3]atomic.Bool
+	var primary []Server
+	for i := range healthy {
+		healthy[i].Store(true)
+		backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if !healthy[i].Load() {
+				rw.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			rw.Write([]byte("primary"))
+		}))
+		t.Cleanup(backend.Close)
+		primary = append(primary, newSimpleServer(backend.URL))
+	}
+	backup := newNamedBackend(t, "backup")
+	route := &Route{PathPrefix: "/", Servers: primary, Fallback: []Server{backup}, MinHealthy: 2}
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", primary, WithRoutes(route), WithHealthCheck(time.Minute, time.Minute), WithClock(clock))
+
+	serve := func() string {
+		clock.Advance(lb.health.probeDue(lb.allServers()).Sub(clock.Now()))
+		lb.health.probeDue(lb.allServers())
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		return rw.Body.String()
+	}
+
+	// One unhealthy backend leaves 2 of 3, which is still enough.
+	healthy[0].Store(false)
+	for i := 0; i < 4; i++ {
+		if got := serve(); got != "primary" {
+			t.Fatalf("Expected the primary tier to serve with 2 healthy backends; got %q", got)
+		}
+	}
+
+	// Below the threshold traffic shifts to the backup tier...
+	healthy[1].Store(false)
+	for i := 0; i < 4; i++ {
+		if got := serve(); got != "backup" {
+			t.Fatalf("Expected failover to the backup tier with 1 healthy backend; got %q", got)
+		}
+	}
+
+	// ...and comes back once enough backends recover.
+	healthy[1].Store(true)
+	if got := serve(); got != "primary" {
+		t.Errorf("Expected traffic to return to the primary tier; got %q", got)
+	}
+}
+
+func TestLoadBalancer_DrainAndWaitHTTP2Streams(t *testing.T) {
+	release := [2]chan struct{}{make(chan struct{}), make(chan struct{})}
+	started := make(chan string, 2)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		started <- fmt.Sprintf("%s %s", req.Proto, req.RemoteAddr)
+		i, _ := strconv.Atoi(req.URL.Query().Get("stream"))
+		<-release[i]
+		rw.Write([]byte("done"))
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+
+	server := newSimpleServer(backend.URL)
+	server.transport.TLSClientConfig = backend.Client().Transport.(*http.Transport).TLSClientConfig
+	lb := NewLoadBalancer("8000", []Server{server})
+
+	// Two streams in flight, multiplexed on one HTTP/2 connection.
+	var wg sync.WaitGroup
+	for i := range release {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rw := httptest.NewRecorder()
+			lb.serveProxy(rw, httptest.NewRequest("GET", fmt.Sprintf("/?stream=%d", i), nil))
+			if rw.Body.String() != "done" {
+				t.Errorf("Expected stream %d to complete while draining; got %d %q", i, rw.Code, rw.Body.String())
+			}
+		}()
+	}
+	first, second := <-started, <-started
+	if !strings.HasPrefix(first, "HTTP/2.0 ") || first != second {
+		t.Fatalf("Expected both streams on one HTTP/2 connection; got %q and %q", first, second)
+	}
+
+	drained := make(chan error)
+	go func() { drained <- lb.DrainAndWait(context.Background(), server) }()
+
+	close(release[0])
+	select {
+	case err := <-drained:
+		t.Fatalf("Expected draining to wait for the remaining stream; returned %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	close(release[1])
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+}
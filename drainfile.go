@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// drainFilePollInterval is how often WatchDrainFile checks whether the drain file exists.
+const drainFilePollInterval = time.Second
+
+// WithDrainFile drains the whole load balancer while a file exists at path, for deploy
+// systems that signal draining by touching a file. While draining, /ready answers 503 so
+// orchestrators stop sending traffic; requests in flight, and any that still arrive, are
+// served as usual. The file is watched by WatchDrainFile.
+func WithDrainFile(path string) Option {
+	return func(lb *LoadBalancer) {
+		lb.drainFile = path
+	}
+}
+
+// WatchDrainFile checks for the WithDrainFile file until ctx is cancelled. The returned
+// channel is closed once it has stopped.
+func (lb *LoadBalancer) WatchDrainFile(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	if lb.drainFile == "" {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		for {
+			lb.checkDrainFile()
+			select {
+			case <-ctx.Done():
+				return
+			case <-lb.clock.After(drainFilePollInterval):
+			}
+		}
+	}()
+	return done
+}
+
+// checkDrainFile updates the draining state from the existence of the drain file. An error
+// other than the file not existing, such as a permission error, leaves the state unchanged.
+func (lb *LoadBalancer) checkDrainFile() {
+	_, err := os.Stat(lb.drainFile)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Checking drain file %q failed: %v\n", lb.drainFile, err)
+		return
+	}
+	draining := err == nil
+	if lb.fileDrained.Swap(draining) == draining {
+		return
+	}
+	if draining {
+		fmt.Printf("Drain file %q exists, draining the load balancer\n", lb.drainFile)
+	} else {
+		fmt.Printf("Drain file %q was removed, no longer draining\n", lb.drainFile)
+	}
+}
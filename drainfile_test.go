@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_DrainFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "drain")
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", []Server{newNamedBackend(t, "a")}, WithClock(clock), WithDrainFile(path))
+	ready := func() int {
+		rw := httptest.NewRecorder()
+		lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("GET", "/ready", nil))
+		return rw.Code
+	}
+	// poll lets the watcher check for the file again.
+	poll := func() {
+		clock.Advance(drainFilePollInterval)
+		clock.BlockUntil(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := lb.WatchDrainFile(ctx)
+	clock.BlockUntil(1)
+	if lb.Draining() || ready() != http.StatusOK {
+		t.Fatalf("Expected the load balancer to be ready without a drain file")
+	}
+
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	poll()
+	if !lb.Draining() {
+		t.Fatalf("Expected the load balancer to drain once the drain file exists")
+	}
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /ready to answer 503 while draining; got %d", code)
+	}
+	rw := httptest.NewRecorder()
+	lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("POST", "/undrain", nil))
+	if rw.Code != http.StatusConflict || !lb.Draining() {
+		t.Errorf("Expected /undrain to leave the drain file in charge; got %d", rw.Code)
+	}
+	rw = httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Body.String() != "a" {
+		t.Errorf("Expected requests to still be served while draining; got %d %q", rw.Code, rw.Body.String())
+	}
+
+	os.Remove(path)
+	poll()
+	if lb.Draining() || ready() != http.StatusOK {
+		t.Errorf("Expected the load balancer to be ready again once the drain file is removed")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the watcher to stop when its context is cancelled")
+	}
+}
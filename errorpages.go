@@ -0,0 +1,61 @@
+package main
+
+import (
+	"cmp"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// errorPageStatuses are the statuses of errors generated by the load balancer that a config
+// file can give custom pages.
+var errorPageStatuses = []int{
+	http.StatusNotFound,
+	http.StatusMethodNotAllowed,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// ErrorPage is a custom response body for an error generated by the load balancer.
+type ErrorPage struct {
+	// ContentType defaults to the one sniffed from Body.
+	ContentType string
+	Body        []byte
+}
+
+// WithErrorPages answers the errors the load balancer generates itself with the page of
+// their status, such as a branded HTML page for 503 Service Unavailable, instead of the
+// WithErrorFormat body. Error responses from backends are passed through unchanged.
+func WithErrorPages(pages map[int]ErrorPage) Option {
+	return func(lb *LoadBalancer) {
+		lb.errorPages = pages
+	}
+}
+
+// LoadErrorPage reads an ErrorPage from the file at path. Its content type is the one of the
+// file's extension, or sniffed from its contents when the extension is unknown.
+func LoadErrorPage(path string) (ErrorPage, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return ErrorPage{}, err
+	}
+	return ErrorPage{ContentType: mime.TypeByExtension(filepath.Ext(path)), Body: body}, nil
+}
+
+// writeErrorPage answers with the custom page for status and reports whether there is one.
+func (lb *LoadBalancer) writeErrorPage(rw http.ResponseWriter, status int) bool {
+	page, ok := lb.errorPages[status]
+	if !ok {
+		return false
+	}
+	rw.Header().Set("Content-Type", cmp.Or(page.ContentType, http.DetectContentType(page.Body)))
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.Header().Del("Content-Length")
+	rw.WriteHeader(status)
+	rw.Write(page.Body)
+	return true
+}
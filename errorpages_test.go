@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadBalancer_ErrorPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance.html")
+	if err := os.WriteFile(path, []byte("<h1>Back soon</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig(strings.NewReader(fmt.Sprintf(`{
+		"backends": [{"address": "http://127.0.0.1:1"}],
+		"error_pages": {
+			"503": {"file": %q},
+			"405": {"body": "{\"error\": \"method not allowed\"}", "content_type": "application/json"}
+		}
+	}`, path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb, err := NewLoadBalancerFromConfig(cfg, WithBlockedMethods("DELETE"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb.pool.Remove(lb.pool.All()[0])
+
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Code != http.StatusServiceUnavailable || rw.Body.String() != "<h1>Back soon</h1>" {
+		t.Errorf("Expected the custom 503 page when no backend is available; got %d %q", rw.Code, rw.Body.String())
+	}
+	if got := rw.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Expected the page's content type from its extension; got %q", got)
+	}
+
+	rw = httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("DELETE", "/", nil))
+	if rw.Code != http.StatusMethodNotAllowed || rw.Body.String() != `{"error": "method not allowed"}` || rw.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the inline 405 page; got %d %q %q", rw.Code, rw.Header().Get("Content-Type"), rw.Body.String())
+	}
+
+	handler := lb.recoveryMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Code != http.StatusInternalServerError || !strings.Contains(rw.Body.String(), "Internal Server Error") {
+		t.Errorf("Expected the default 500 without a custom page; got %d %q", rw.Code, rw.Body.String())
+	}
+}
+
+func TestLoadBalancer_ErrorPagesSkipBackendErrors(t *testing.T) {
+	backend := newStatusBackend(t, http.StatusServiceUnavailable, "backend down")
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backend.URL)}, WithRetries(0), WithErrorPages(map[int]ErrorPage{
+		http.StatusServiceUnavailable: {Body: []byte("custom")},
+	}))
+
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Code != http.StatusServiceUnavailable || rw.Body.String() != "backend down" {
+		t.Errorf("Expected the backend's own 503 to be passed through; got %d %q", rw.Code, rw.Body.String())
+	}
+}
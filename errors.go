@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	errorFormatPlain = "plain"
+	errorFormatJSON  = "json"
+)
+
+// WithErrorFormat selects how errors generated by the load balancer itself are rendered:
+// "plain" text (the default) or "json".
+func WithErrorFormat(format string) Option {
+	return func(lb *LoadBalancer) {
+		lb.errorFormat = format
+	}
+}
+
+// WithDebugErrors includes why the last backend failed, such as its status code and the start
+// of its response body, in the load balancer's error responses. It may leak internal details,
+// so it is meant for development only.
+func WithDebugErrors() Option {
+	return func(lb *LoadBalancer) {
+		lb.debugErrors = true
+	}
+}
+
+// maxDebugBodyBytes caps how much of a failed upstream response body is surfaced.
+const maxDebugBodyBytes = 1024
+
+type errorResponse struct {
+	Status    int             `json:"status"`
+	Error     string          `json:"error"`
+	Message   string          `json:"message"`
+	RequestID string          `json:"request_id,omitempty"`
+	Upstream  *upstreamDetail `json:"upstream,omitempty"`
+}
+
+// upstreamDetail describes the failure of the last backend tried, for debugging.
+type upstreamDetail struct {
+	Status int    `json:"status,omitempty"`
+	Body   string `json:"body,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (d *upstreamDetail) String() string {
+	if d.Status != 0 {
+		return fmt.Sprintf("upstream status %d: %s", d.Status, d.Body)
+	}
+	return "upstream error: " + d.Error
+}
+
+// sanitizeDebugText makes upstream-provided text safe to echo: non-printable characters are
+// replaced and the result is truncated to maxDebugBodyBytes.
+func sanitizeDebugText(b []byte) string {
+	if len(b) > maxDebugBodyBytes {
+		b = b[:maxDebugBodyBytes]
+	}
+	return strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || (!unicode.IsPrint(r) && r != '\n' && r != '\t') {
+			return ' '
+		}
+		return r
+	}, string(b))
+}
+
+// writeUpstreamError is writeError for failures caused by the backends. With debug errors
+// enabled, the details of err are included.
+func (lb *LoadBalancer) writeUpstreamError(rw http.ResponseWriter, req *http.Request, status int, message string, err error) {
+	var detail *upstreamDetail
+	if lb.debugErrors && err != nil {
+		var statusErr *upstreamStatusError
+		if errors.As(err, &statusErr) {
+			detail = &upstreamDetail{Status: statusErr.status, Body: sanitizeDebugText(statusErr.body)}
+		} else {
+			detail = &upstreamDetail{Error: sanitizeDebugText([]byte(err.Error()))}
+		}
+	}
+	lb.writeErrorDetail(rw, req, status, message, detail)
+}
+
+// writeError answers req with an error generated by the load balancer, such as a 503 when
+// no backend is available, including the request ID so clients and logs can be correlated.
+func (lb *LoadBalancer) writeError(rw http.ResponseWriter, req *http.Request, status int, message string) {
+	lb.writeErrorDetail(rw, req, status, message, nil)
+}
+
+func (lb *LoadBalancer) writeErrorDetail(rw http.ResponseWriter, req *http.Request, status int, message string, upstream *upstreamDetail) {
+	if lb.writeErrorPage(rw, status) {
+		return
+	}
+	requestID := requestIDFromContext(req.Context())
+
+	if lb.errorFormat == errorFormatJSON {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		rw.WriteHeader(status)
+		json.NewEncoder(rw).Encode(errorResponse{
+			Status:    status,
+			Error:     http.StatusText(status),
+			Message:   message,
+			RequestID: requestID,
+			Upstream:  upstream,
+		})
+		return
+	}
+
+	if requestID != "" {
+		message = fmt.Sprintf("%s (request ID %s)", message, requestID)
+	}
+	if upstream != nil {
+		message += "\n" + upstream.String()
+	}
+	http.Error(rw, message, status)
+}
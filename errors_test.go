@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoadBalancer_JSONErrorWithRequestID(t *testing.T) {
+	deadServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer deadServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(deadServer.URL)}, WithErrorFormat(errorFormatJSON))
+	handler := requestIDMiddleware(http.HandlerFunc(lb.serveProxy))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503; got %d", rw.Code)
+	}
+	if ct := rw.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON content type; got %q", ct)
+	}
+	var body errorResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected a JSON body; got %q: %v", rw.Body.String(), err)
+	}
+	if body.RequestID != "req-123" || body.Status != http.StatusServiceUnavailable || body.Message == "" {
+		t.Errorf("Unexpected error body: %+v", body)
+	}
+	if rw.Header().Get("X-Request-ID") != "req-123" {
+		t.Errorf("Expected request ID to be echoed back; got %q", rw.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestRequestIDMiddleware_GeneratesID(t *testing.T) {
+	var forwarded, fromContext string
+	handler := requestIDMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		forwarded = req.Header.Get("X-Request-ID")
+		fromContext = requestIDFromContext(req.Context())
+	}))
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+
+	if forwarded == "" || forwarded != fromContext || rw.Header().Get("X-Request-ID") != forwarded {
+		t.Errorf("Expected a generated request ID to be forwarded, stored and echoed; got %q, %q, %q",
+			forwarded, fromContext, rw.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestLoadBalancer_PlainError(t *testing.T) {
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer("http://127.0.0.1:0")})
+	handler := requestIDMiddleware(http.HandlerFunc(lb.serveProxy))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "req-456")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusServiceUnavailable || !strings.Contains(rw.Body.String(), "req-456") {
+		t.Errorf("Expected plain 503 mentioning the request ID; got %d %q", rw.Code, rw.Body.String())
+	}
+}
+
+func TestLoadBalancer_DebugUpstreamErrors(t *testing.T) {
+	backend := newStatusBackend(t, http.StatusInternalServerError, "database connection refused\x00")
+
+	for _, debug := range []bool{false, true} {
+		opts := []Option{WithRetries(1), WithRetryStatuses(500), WithErrorFormat(errorFormatJSON)}
+		if debug {
+			opts = append(opts, WithDebugErrors())
+		}
+		lb := NewLoadBalancer("8000", []Server{newSimpleServer(backend.URL)}, opts...)
+
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+
+		if rw.Code != http.StatusBadGateway {
+			t.Fatalf("Expected status 502; got %d", rw.Code)
+		}
+		var body errorResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if !debug {
+			if body.Upstream != nil || strings.Contains(rw.Body.String(), "database") {
+				t.Errorf("Expected upstream details to be hidden by default; got %s", rw.Body.String())
+			}
+			continue
+		}
+		if body.Upstream == nil || body.Upstream.Status != 500 || body.Upstream.Body != "database connection refused " {
+			t.Errorf("Expected sanitized upstream status and body; got %s", rw.Body.String())
+		}
+	}
+}
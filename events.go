@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies what an Event reports.
+type EventType string
+
+const (
+	// EventRequestStarted is emitted when a request is sent to a backend, once per attempt.
+	EventRequestStarted EventType = "request_started"
+	// EventRequestFinished is emitted when a backend attempt has finished, with its Status
+	// or Err.
+	EventRequestFinished EventType = "request_finished"
+
+	// Backend state changes, also reported to WithStateWebhook.
+	EventBackendHealthy   EventType = "healthy"
+	EventBackendUnhealthy EventType = "unhealthy"
+	EventBackendDraining  EventType = "draining"
+	EventBackendUndrained EventType = "undrained"
+
+	// EventBreakerTripped and EventBreakerReset are emitted when a backend's circuit breaker
+	// opens and when it closes again.
+	EventBreakerTripped EventType = "breaker_tripped"
+	EventBreakerReset   EventType = "breaker_reset"
+)
+
+// Event is something that happened in the load balancer, delivered to Subscribe callbacks.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Backend is the backend the event is about.
+	Backend Server
+	// Request is the proxied request of request events.
+	Request *http.Request
+	// Status is the backend's status code for EventRequestFinished, 0 if it sent no response.
+	Status int
+	// Err is why an attempt failed, for EventRequestFinished.
+	Err error
+	// Duration is how long the attempt took, for EventRequestFinished.
+	Duration time.Duration
+}
+
+// eventBus delivers events to subscribers. The subscriber list is replaced on every change,
+// so emitting takes no lock and callbacks may subscribe or unsubscribe.
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers atomic.Pointer[[]*subscriber]
+}
+
+type subscriber struct {
+	f func(Event)
+}
+
+func (b *eventBus) subscribe(f func(Event)) (unsubscribe func()) {
+	sub := &subscriber{f: f}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.store(append(slices.Clone(b.load()), sub))
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.store(slices.DeleteFunc(slices.Clone(b.load()), func(s *subscriber) bool { return s == sub }))
+	}
+}
+
+func (b *eventBus) load() []*subscriber {
+	if subs := b.subscribers.Load(); subs != nil {
+		return *subs
+	}
+	return nil
+}
+
+func (b *eventBus) store(subs []*subscriber) {
+	b.subscribers.Store(&subs)
+}
+
+func (b *eventBus) emit(ev Event) {
+	for _, sub := range b.load() {
+		sub.f(ev)
+	}
+}
+
+// Subscribe calls f with every event emitted from then on, until the returned function is
+// called. f runs synchronously in the component emitting the event, such as the request's
+// handler or the health checker, so it must return quickly and hand slow work off.
+func (lb *LoadBalancer) Subscribe(f func(Event)) (unsubscribe func()) {
+	return lb.events.subscribe(f)
+}
+
+// emit stamps ev with the current time and delivers it to the subscribers.
+func (lb *LoadBalancer) emit(ev Event) {
+	ev.Time = lb.clock.Now()
+	lb.events.emit(ev)
+}
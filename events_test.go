@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_Events(t *testing.T) {
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	down, up := newSimpleServer(unreachable.URL), newNamedBackend(t, "up")
+	names := map[Server]string{down: "down", up: "up"}
+
+	lb := NewLoadBalancer("8000", []Server{down, up}, WithClock(newFakeClock()), WithRetries(1),
+		WithCircuitBreaker(1, time.Minute), WithHealthCheck(time.Second, time.Second))
+	var mu sync.Mutex
+	var events []string
+	unsubscribe := lb.Subscribe(func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		line := fmt.Sprintf("%s %s", ev.Type, names[ev.Backend])
+		if ev.Type == EventRequestFinished {
+			line += fmt.Sprintf(" status=%d failed=%t", ev.Status, ev.Err != nil)
+		}
+		events = append(events, line)
+	})
+
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Body.String() != "up" {
+		t.Fatalf("Expected the request to fail over to the live backend; got %d %q", rw.Code, rw.Body.String())
+	}
+	lb.health.probeDue(lb.pool.All())
+	lb.Drain(up)
+	unsubscribe()
+	lb.Undrain(up)
+
+	want := []string{
+		"request_started down",
+		"request_finished down status=0 failed=true",
+		"breaker_tripped down",
+		"request_started up",
+		"request_finished up status=200 failed=false",
+		"unhealthy down",
+		"draining up",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected the events\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(events, "\n"))
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultExpandInterval is how often WatchHosts re-resolves a hostname given no interval.
+const defaultExpandInterval = 30 * time.Second
+
+// WithHostExpansion balances across every IP address the hostname of address resolves to,
+// e.g. the pods behind a headless Kubernetes service, instead of pinning to one: each address
+// becomes a backend of the default pool, health-checked on its own, sent the hostname as Host
+// and, for HTTPS, as SNI (see WithServerName). WatchHosts re-resolves the hostname every
+// interval (30s if 0), adding backends for new addresses and removing those of addresses
+// that are gone once their requests finish. A failed lookup keeps the current backends. A nil
+// resolver uses the system one; opts are applied to the backends it creates.
+func WithHostExpansion(address string, interval time.Duration, resolver Resolver, opts ...ServerOption) Option {
+	target, err := url.Parse(address)
+	handleErr(err)
+	if interval <= 0 {
+		interval = defaultExpandInterval
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return func(lb *LoadBalancer) {
+		lb.expansions = append(lb.expansions, &hostExpansion{
+			target:   target,
+			interval: interval,
+			resolver: resolver,
+			opts:     opts,
+			servers:  make(map[string]Server),
+		})
+	}
+}
+
+type hostExpansion struct {
+	target   *url.URL
+	interval time.Duration
+	resolver Resolver
+	opts     []ServerOption
+
+	mu sync.Mutex
+	// servers are the backends created for the hostname, by IP address.
+	servers map[string]Server
+}
+
+// WatchHosts resolves the WithHostExpansion hostnames right away and then on their interval,
+// until ctx is cancelled. The returned channel is closed once it has stopped.
+func (lb *LoadBalancer) WatchHosts(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, e := range lb.expansions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				e.refresh(ctx, lb.pool)
+				select {
+				case <-ctx.Done():
+					return
+				case <-lb.clock.After(e.interval):
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// refresh resolves the hostname and brings pool's backends for it in line with the answer.
+func (e *hostExpansion) refresh(ctx context.Context, pool *Pool) {
+	host := e.target.Hostname()
+	addrs, err := e.resolver.LookupHost(ctx, host)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("no addresses found for %q", host)
+	}
+	if err != nil {
+		fmt.Printf("Resolving backend %q failed: %v\n", e.target, err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var added, removed int
+	for _, addr := range addrs {
+		if _, ok := e.servers[addr]; ok {
+			continue
+		}
+		s := newSimpleServer(e.addressOf(addr), append(slices.Clone(e.opts), WithServerName(host))...)
+		e.servers[addr] = s
+		if pool.Add(s) {
+			added++
+		}
+	}
+	for addr, s := range e.servers {
+		if !slices.Contains(addrs, addr) {
+			delete(e.servers, addr)
+			if pool.Remove(s) {
+				removed++
+			}
+		}
+	}
+	if added > 0 || removed > 0 {
+		fmt.Printf("Backend %q resolves to %v: %d backends added, %d removed\n", e.target, addrs, added, removed)
+	}
+}
+
+// addressOf returns the backend address with its hostname replaced by ip.
+func (e *hostExpansion) addressOf(ip string) string {
+	u := *e.target
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(ip, port)
+	} else if net.ParseIP(ip).To4() == nil {
+		u.Host = "[" + ip + "]"
+	} else {
+		u.Host = ip
+	}
+	return u.String()
+}
+
+// expanded reports whether s was created by a WithHostExpansion.
+func (lb *LoadBalancer) expanded(s Server) bool {
+	for _, e := range lb.expansions {
+		e.mu.Lock()
+		found := slices.Contains(slices.Collect(maps.Values(e.servers)), s)
+		e.mu.Unlock()
+		if found {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_HostExpansion(t *testing.T) {
+	lnA, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := strconv.Itoa(lnA.Addr().(*net.TCPAddr).Port)
+	lnB, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", port))
+	if err != nil {
+		lnA.Close()
+		t.Skipf("Loopback address 127.0.0.2 unavailable: %v", err)
+	}
+	serveName(t, lnA, "a")
+	serveName(t, lnB, "b")
+
+	resolver := &fakeResolver{hosts: make(map[string][]string)}
+	resolver.set("pods.internal", "127.0.0.1", "127.0.0.2")
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", nil, WithClock(clock), WithHostExpansion("http://pods.internal:"+port, time.Minute, resolver))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := lb.WatchHosts(ctx)
+	defer func() {
+		cancel()
+		<-done
+	}()
+	clock.BlockUntil(1)
+
+	if got := poolAddresses(lb); len(got) != 2 {
+		t.Fatalf("Expected a backend per IP; got %v", got)
+	}
+	for _, s := range lb.pool.All() {
+		if host := s.(*simpleServer).hostHeader(); host != "pods.internal:"+port {
+			t.Errorf("Expected the backends to be sent the hostname as Host; got %q", host)
+		}
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		counts[rw.Body.String()]++
+	}
+	if counts["a"] != 2 || counts["b"] != 2 {
+		t.Errorf("Expected the requests to be spread across both IPs; got %v", counts)
+	}
+
+	// An address leaving the record removes its backend on the next lookup; a failed lookup
+	// keeps the backends.
+	resolver.set("pods.internal", "127.0.0.2")
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+	if got := poolAddresses(lb); len(got) != 1 || got[0] != "http://127.0.0.2:"+port {
+		t.Errorf("Expected only the remaining IP's backend; got %v", got)
+	}
+	resolver.mu.Lock()
+	delete(resolver.hosts, "pods.internal")
+	resolver.mu.Unlock()
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+	if got := poolAddresses(lb); len(got) != 1 {
+		t.Errorf("Expected a failed lookup to keep the backends; got %v", got)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FlushPolicy decides how often a response body is flushed to the client while it is copied
+// from the backend: a negative interval flushes after every write, 0 only once the body is
+// complete, and a positive one at most that often. When ok is false ReverseProxy's default
+// applies, which flushes text/event-stream and responses of unknown length immediately.
+type FlushPolicy func(resp *http.Response) (interval time.Duration, ok bool)
+
+// WithFlushPolicy sets the flush interval of each response, see FlushPolicy.
+func WithFlushPolicy(policy FlushPolicy) Option {
+	return func(lb *LoadBalancer) {
+		lb.flushPolicy = policy
+	}
+}
+
+// WithFlushIntervals sets the flush interval of responses by media type, e.g. -1 for
+// "application/x-ndjson" streams and 0 to buffer "application/json".
+func WithFlushIntervals(intervals map[string]time.Duration) Option {
+	return WithFlushPolicy(func(resp *http.Response) (time.Duration, bool) {
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		interval, ok := intervals[mediaType]
+		return interval, ok
+	})
+}
+
+// applyFlushPolicy records the flush interval chosen for resp in a. ReverseProxy only calls
+// Flush for responses it considers streaming, so a response that should be flushed is marked
+// as having an unknown length; the Content-Length header sent to the client is unchanged.
+func (a *proxyAttempt) applyFlushPolicy(resp *http.Response) {
+	if a.flushPolicy == nil {
+		return
+	}
+	interval, ok := a.flushPolicy(resp)
+	if !ok {
+		return
+	}
+	a.flushInterval, a.hasFlushInterval = interval, true
+	if interval != 0 {
+		resp.ContentLength = -1
+	}
+}
+
+// flushWriter applies the flush interval chosen for an attempt to the flushes requested by
+// the ReverseProxy.
+type flushWriter struct {
+	http.ResponseWriter
+	attempt *proxyAttempt
+
+	mu      sync.Mutex
+	pending *time.Timer
+	done    bool
+}
+
+func (w *flushWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *flushWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	a := w.attempt
+	switch {
+	case !a.hasFlushInterval || a.flushInterval < 0:
+		w.flushLocked()
+	case a.flushInterval > 0 && w.pending == nil:
+		// Like ReverseProxy's own latency writer: flush at most once per interval.
+		w.pending = time.AfterFunc(a.flushInterval, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.pending = nil
+			if !w.done {
+				w.flushLocked()
+			}
+		})
+	}
+}
+
+func (w *flushWriter) flushLocked() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// stop cancels a pending delayed flush once the response is complete.
+func (w *flushWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.done = true
+	if w.pending != nil {
+		w.pending.Stop()
+		w.pending = nil
+	}
+}
+
+func (w *flushWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_FlushIntervals(t *testing.T) {
+	release := make(chan struct{})
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		rw.Header().Set("Content-Type", req.URL.Query().Get("type"))
+		rw.Write([]byte("first chunk\n"))
+		rw.(http.Flusher).Flush()
+		<-release
+		rw.Write([]byte("rest\n"))
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithFlushIntervals(map[string]time.Duration{
+		"text/event-stream": -1,
+		"application/json":  0,
+	}))
+	front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+	defer front.Close()
+
+	// firstChunkArrives reports whether the start of the body reaches the client while the
+	// backend is still holding the rest of it. Buffered responses don't even send their
+	// headers before that, so the request runs in the background.
+	firstChunkArrives := func(contentType string) bool {
+		read, done := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(done)
+			resp, err := http.Get(front.URL + "/?type=" + contentType)
+			if err != nil {
+				t.Error(err)
+				close(read)
+				return
+			}
+			defer resp.Body.Close()
+			io.ReadFull(resp.Body, make([]byte, len("first chunk\n")))
+			close(read)
+			io.Copy(io.Discard, resp.Body)
+		}()
+
+		arrived := false
+		select {
+		case <-read:
+			arrived = true
+		case <-time.After(200 * time.Millisecond):
+		}
+		release <- struct{}{}
+		<-done
+		return arrived
+	}
+
+	if !firstChunkArrives("text/event-stream") {
+		t.Errorf("Expected text/event-stream to be flushed immediately")
+	}
+	if firstChunkArrives("application/json") {
+		t.Errorf("Expected application/json to be buffered until the response is complete")
+	}
+}
@@ -1,3 +1,3 @@
 module load_balancer
 
-go 1.23.2
+go 1.24.0
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultGRPCCheckTimeout bounds gRPC health checks given no timeout.
+const defaultGRPCCheckTimeout = 5 * time.Second
+
+// grpcHealthPath is the method of the gRPC Health Checking Protocol.
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+// grpcServing is the SERVING value of grpc.health.v1.HealthCheckResponse.ServingStatus.
+const grpcServing = 1
+
+var grpcServingStatuses = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+// WithGRPCHealthCheck probes the backend with the gRPC Health Checking Protocol instead of a
+// HEAD request: it calls grpc.health.v1.Health/Check for service, "" meaning the server as a
+// whole, and counts the backend healthy while it answers SERVING within timeout (5s if 0).
+// http:// backends are called over HTTP/2 without TLS (h2c), https:// ones over HTTP/2.
+func WithGRPCHealthCheck(service string, timeout time.Duration) ServerOption {
+	return func(s *simpleServer) {
+		s.grpcCheck = true
+		s.grpcService = service
+		s.grpcCheckTimeout = cmp.Or(timeout, defaultGRPCCheckTimeout)
+	}
+}
+
+// newGRPCHealthClient returns a client calling the backend over HTTP/2 through a copy of its
+// transport, so the probes use the same TLS, proxy and DNS settings as requests.
+func (s *simpleServer) newGRPCHealthClient() *http.Client {
+	transport := s.transport.Clone()
+	transport.Protocols = new(http.Protocols)
+	if s.target.Scheme == "https" {
+		transport.Protocols.SetHTTP2(true)
+	} else {
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return &http.Client{Transport: transport}
+}
+
+func (s *simpleServer) checkGRPC() probeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), s.grpcCheckTimeout)
+	defer cancel()
+
+	// A HealthCheckRequest with its service field, in a length-prefixed gRPC message.
+	var msg []byte
+	if s.grpcService != "" {
+		msg = binary.AppendUvarint([]byte{0x0a}, uint64(len(s.grpcService)))
+		msg = append(msg, s.grpcService...)
+	}
+	body := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	body = append(body, msg...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.target.Scheme+"://"+s.target.Host+grpcHealthPath, bytes.NewReader(body))
+	if err != nil {
+		return probeResult{reason: err.Error()}
+	}
+	s.setProbeHeaders(req)
+	req.Header.Set("Content-Type", grpcContentType)
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("Grpc-Timeout", strconv.FormatInt(s.grpcCheckTimeout.Milliseconds(), 10)+"m")
+
+	resp, err := s.grpcClient.Do(req)
+	if err != nil {
+		return probeResult{reason: probeFailure(err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return probeResult{header: resp.Header, reason: "status " + resp.Status}
+	}
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return probeResult{header: resp.Header, reason: probeFailure(err)}
+	}
+	// Errors without a response message come in the headers ("Trailers-Only").
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		return probeResult{header: resp.Header, reason: fmt.Sprintf("grpc-status %s %s", cmp.Or(status, "missing"), message)}
+	}
+
+	serving, err := parseHealthCheckResponse(reply)
+	if err != nil {
+		return probeResult{header: resp.Header, reason: err.Error()}
+	}
+	if serving != grpcServing {
+		return probeResult{header: resp.Header, reason: cmp.Or(grpcServingStatuses[serving], "status "+strconv.FormatUint(serving, 10))}
+	}
+	return probeResult{alive: true, header: resp.Header}
+}
+
+// parseHealthCheckResponse returns the status field of the length-prefixed
+// HealthCheckResponse in reply, skipping any other fields.
+func parseHealthCheckResponse(reply []byte) (uint64, error) {
+	if len(reply) < 5 {
+		return 0, errors.New("short gRPC health response")
+	}
+	if reply[0] != 0 {
+		return 0, errors.New("compressed gRPC health response")
+	}
+	length := binary.BigEndian.Uint32(reply[1:5])
+	if uint64(len(reply)-5) < uint64(length) {
+		return 0, errors.New("truncated gRPC health response")
+	}
+	msg := reply[5 : 5+length]
+
+	var status uint64
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return 0, errors.New("malformed gRPC health response")
+		}
+		msg = msg[n:]
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(msg)
+			if n <= 0 {
+				return 0, errors.New("malformed gRPC health response")
+			}
+			msg = msg[n:]
+			if key>>3 == 1 {
+				status = v
+			}
+		case 1:
+			if len(msg) < 8 {
+				return 0, errors.New("malformed gRPC health response")
+			}
+			msg = msg[8:]
+		case 2:
+			l, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < l {
+				return 0, errors.New("malformed gRPC health response")
+			}
+			msg = msg[n+int(l):]
+		case 5:
+			if len(msg) < 4 {
+				return 0, errors.New("malformed gRPC health response")
+			}
+			msg = msg[4:]
+		default:
+			return 0, errors.New("malformed gRPC health response")
+		}
+	}
+	return status, nil
+}
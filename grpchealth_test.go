@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newGRPCHealthBackend starts an h2c backend implementing grpc.health.v1.Health/Check, which
+// answers each service with its status in statuses and unknown services with NOT_FOUND.
+func newGRPCHealthBackend(t *testing.T, mu *sync.Mutex, statuses map[string]byte) *httptest.Server {
+	backendServer := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 || req.URL.Path != grpcHealthPath || req.Header.Get("Content-Type") != grpcContentType {
+			t.Errorf("Expected a gRPC health call over HTTP/2; got %s %s %q", req.Proto, req.URL.Path, req.Header.Get("Content-Type"))
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		var service string
+		if len(body) > 7 && body[5] == 0x0a {
+			service = string(body[7 : 7+body[6]])
+		}
+		rw.Header().Set("Content-Type", grpcContentType)
+		mu.Lock()
+		status, ok := statuses[service]
+		mu.Unlock()
+		if !ok {
+			rw.Header().Set("Grpc-Status", "5")
+			rw.Header().Set("Grpc-Message", "unknown service")
+			return
+		}
+		rw.Header().Set("Trailer", "Grpc-Status")
+		rw.Write(binary.BigEndian.AppendUint32([]byte{0}, 2))
+		rw.Write([]byte{0x08, status})
+		rw.Header().Set("Grpc-Status", "0")
+	}))
+	backendServer.Config.Protocols = new(http.Protocols)
+	backendServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	backendServer.Start()
+	t.Cleanup(backendServer.Close)
+	return backendServer
+}
+
+func TestSimpleServer_GRPCHealthCheck(t *testing.T) {
+	const serving, notServing = 1, 2
+	var mu sync.Mutex
+	statuses := map[string]byte{"": serving, "payments": notServing}
+	backendServer := newGRPCHealthBackend(t, &mu, statuses)
+
+	server := newSimpleServer(backendServer.URL, WithGRPCHealthCheck("", 0))
+	payments := newSimpleServer(backendServer.URL, WithGRPCHealthCheck("payments", time.Second))
+	unknown := newSimpleServer(backendServer.URL, WithGRPCHealthCheck("search", 0))
+	servers := []Server{server, payments, unknown}
+	hc := newHealthChecker(healthConfig{interval: time.Second, maxInterval: time.Second}, newFakeClock())
+
+	hc.probeDue(servers)
+	if !hc.isAlive(server) {
+		t.Errorf("Expected the SERVING server to be healthy; got %q", hc.reason(server))
+	}
+	if hc.isAlive(payments) || hc.reason(payments) != "NOT_SERVING" {
+		t.Errorf("Expected the NOT_SERVING service to be unhealthy; got %q", hc.reason(payments))
+	}
+	if hc.isAlive(unknown) || !strings.Contains(hc.reason(unknown), "grpc-status 5") {
+		t.Errorf("Expected an unknown service to be unhealthy with its gRPC status; got %q", hc.reason(unknown))
+	}
+
+	mu.Lock()
+	statuses[""], statuses["payments"] = notServing, serving
+	mu.Unlock()
+	hc.reset(servers)
+	hc.probeDue(servers)
+	if hc.isAlive(server) {
+		t.Errorf("Expected the server to be unhealthy once NOT_SERVING")
+	}
+	if !hc.isAlive(payments) {
+		t.Errorf("Expected the service to be healthy once SERVING; got %q", hc.reason(payments))
+	}
+}
+
+func TestParseHealthCheckResponse(t *testing.T) {
+	frame := func(msg ...byte) []byte {
+		return append(binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg))), msg...)
+	}
+	tests := map[string]struct {
+		reply   []byte
+		status  uint64
+		wantErr bool
+	}{
+		"serving":       {reply: frame(0x08, 1), status: 1},
+		"default":       {reply: frame(), status: 0},
+		"unknown field": {reply: frame(0x12, 2, 'h', 'i', 0x08, 2), status: 2},
+		"truncated":     {reply: frame(0x08, 1)[:5], wantErr: true},
+		"compressed":    {reply: append([]byte{1}, frame(0x08, 1)[1:]...), wantErr: true},
+		"bad varint":    {reply: frame(0x08, 0x80), wantErr: true},
+	}
+	for name, tt := range tests {
+		status, err := parseHealthCheckResponse(tt.reply)
+		if (err != nil) != tt.wantErr || status != tt.status {
+			t.Errorf("%s: got status %d, error %v", name, status, err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	grpcContentType        = "application/grpc"
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// grpcWebTrailerFlag marks the length-prefixed frame that carries the trailers in gRPC-Web.
+	grpcWebTrailerFlag = 0x80
+)
+
+// isGRPCWebRequest reports whether r uses the binary or text gRPC-Web protocol.
+func isGRPCWebRequest(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(r.Header.Get("Content-Type"), grpcWebContentType)
+}
+
+// Middleware to translate gRPC-Web requests from browsers into native gRPC for the backends.
+// Request bodies of the text variant are base64 decoded, and the backend's HTTP trailers are
+// sent back as a trailer frame at the end of the body since browsers can't read trailers.
+func grpcWebMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !isGRPCWebRequest(r) {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		contentType := r.Header.Get("Content-Type")
+		text := strings.HasPrefix(contentType, grpcWebTextContentType)
+
+		// Keep any subtype suffix such as "+proto".
+		suffix := strings.TrimPrefix(strings.TrimPrefix(contentType, grpcWebTextContentType), grpcWebContentType)
+
+		out := r.Clone(r.Context())
+		out.Header.Set("Content-Type", grpcContentType+suffix)
+		out.Header.Set("Te", "trailers")
+		out.Header.Del("Content-Length")
+		if text {
+			out.Body = io.NopCloser(base64.NewDecoder(base64.StdEncoding, r.Body))
+			out.ContentLength = -1
+		}
+
+		gw := &grpcWebResponseWriter{ResponseWriter: rw, text: text}
+		if text {
+			gw.encoder = base64.NewEncoder(base64.StdEncoding, rw)
+		}
+		next.ServeHTTP(gw, out)
+		gw.finish()
+	})
+}
+
+// grpcWebResponseWriter rewrites a native gRPC response into gRPC-Web framing.
+type grpcWebResponseWriter struct {
+	http.ResponseWriter
+	text        bool
+	encoder     io.WriteCloser
+	wroteHeader bool
+	trailerKeys []string
+}
+
+func (w *grpcWebResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if contentType := h.Get("Content-Type"); strings.HasPrefix(contentType, grpcContentType) {
+		webType := grpcWebContentType
+		if w.text {
+			webType = grpcWebTextContentType
+		}
+		h.Set("Content-Type", webType+strings.TrimPrefix(contentType, grpcContentType))
+	}
+
+	// Trailers are moved into the body, so don't announce them as HTTP trailers.
+	for _, announced := range h.Values("Trailer") {
+		for _, key := range strings.Split(announced, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				w.trailerKeys = append(w.trailerKeys, http.CanonicalHeaderKey(key))
+			}
+		}
+	}
+	h.Del("Trailer")
+	h.Del("Content-Length")
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *grpcWebResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *grpcWebResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *grpcWebResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the trailers received from the backend as the final gRPC-Web frame.
+func (w *grpcWebResponseWriter) finish() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	h := w.Header()
+	trailers := http.Header{}
+	for _, key := range w.trailerKeys {
+		if values, ok := h[key]; ok {
+			trailers[key] = values
+			delete(h, key)
+		}
+	}
+	for key, values := range h {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			trailers[http.CanonicalHeaderKey(strings.TrimPrefix(key, http.TrailerPrefix))] = values
+			delete(h, key)
+		}
+	}
+
+	if len(trailers) > 0 {
+		w.Write(encodeGRPCWebTrailers(trailers))
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+}
+
+// encodeGRPCWebTrailers encodes trailers as a length-prefixed frame of lower-cased
+// "key: value" lines.
+func encodeGRPCWebTrailers(trailers http.Header) []byte {
+	keys := make([]string, 0, len(trailers))
+	for key := range trailers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var block bytes.Buffer
+	for _, key := range keys {
+		for _, value := range trailers[key] {
+			block.WriteString(strings.ToLower(key) + ": " + value + "\r\n")
+		}
+	}
+
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	return append(frame, block.Bytes()...)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func grpcFrame(flag byte, payload []byte) []byte {
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+// newGRPCEchoBackend starts an HTTP/2 backend that answers native gRPC calls with the reversed message.
+func newGRPCEchoBackend(t *testing.T) *httptest.Server {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		if req.ProtoMajor != 2 || req.Header.Get("Content-Type") != "application/grpc+proto" {
+			t.Errorf("Expected native gRPC over HTTP/2; got %s with %q", req.Proto, req.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(req.Body)
+		if len(body) < 5 {
+			t.Errorf("Expected a framed gRPC message; got %q", body)
+			return
+		}
+		msg := []byte(string(body[5:]))
+		for i, j := 0, len(msg)-1; i < j; i, j = i+1, j-1 {
+			msg[i], msg[j] = msg[j], msg[i]
+		}
+
+		rw.Header().Set("Content-Type", "application/grpc+proto")
+		rw.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		rw.Write(grpcFrame(0, msg))
+		rw.Header().Set("Grpc-Status", "0")
+		rw.Header().Set("Grpc-Message", "OK")
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	return backend
+}
+
+func TestGRPCWebMiddleware(t *testing.T) {
+	backend := newGRPCEchoBackend(t)
+	defer backend.Close()
+
+	server := newSimpleServer(backend.URL)
+	server.transport.TLSClientConfig = backend.Client().Transport.(*http.Transport).TLSClientConfig
+	lb := NewLoadBalancer("8000", []Server{server})
+
+	front := httptest.NewServer(grpcWebMiddleware(http.HandlerFunc(lb.serveProxy)))
+	defer front.Close()
+
+	wantBody := append(grpcFrame(0, []byte("olleh")), grpcFrame(0x80, []byte("grpc-message: OK\r\ngrpc-status: 0\r\n"))...)
+
+	for _, text := range []bool{false, true} {
+		contentType := "application/grpc-web+proto"
+		reqBody := grpcFrame(0, []byte("hello"))
+		if text {
+			contentType = "application/grpc-web-text+proto"
+			reqBody = []byte(base64.StdEncoding.EncodeToString(reqBody))
+		}
+
+		resp, err := http.Post(front.URL+"/echo.Echo/Reverse", contentType, bytes.NewReader(reqBody))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.Header.Get("Content-Type") != contentType {
+			t.Errorf("Expected response content type %q; got %q", contentType, resp.Header.Get("Content-Type"))
+		}
+		if text {
+			body, err = base64.StdEncoding.DecodeString(string(body))
+			if err != nil {
+				t.Fatalf("Expected a base64 body: %v", err)
+			}
+		}
+		if !bytes.Equal(body, wantBody) {
+			t.Errorf("Expected body %q; got %q", wantBody, body)
+		}
+		for key := range resp.Trailer {
+			if strings.HasPrefix(strings.ToLower(key), "grpc-") {
+				t.Errorf("Expected gRPC trailers to be moved into the body; got HTTP trailer %q", key)
+			}
+		}
+	}
+}
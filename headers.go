@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// WithStrippedHeaders removes the given headers, such as internal "X-Internal-Auth" tokens,
+// from requests before they are routed and proxied and from backend responses before they
+// reach the client, like the standard hop-by-hop headers.
+func WithStrippedHeaders(headers ...string) Option {
+	return func(lb *LoadBalancer) {
+		lb.strippedHeaders = make([]string, len(headers))
+		for i, h := range headers {
+			lb.strippedHeaders[i] = http.CanonicalHeaderKey(strings.TrimSpace(h))
+		}
+	}
+}
+
+// WithRequestHeaders sets headers, such as a routing token or an API key, on every request
+// proxied to the backend, replacing any the client sent under the same names.
+func WithRequestHeaders(headers http.Header) ServerOption {
+	return func(s *simpleServer) {
+		s.requestHeaders = headers.Clone()
+	}
+}
+
+func stripHeaders(h http.Header, headers []string) {
+	for _, name := range headers {
+		h.Del(name)
+	}
+}
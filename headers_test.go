@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadBalancer_StrippedHeaders(t *testing.T) {
+	var leaked []string
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		for _, h := range []string{"X-Internal-Auth", "X-Debug-Token"} {
+			if req.Header.Get(h) != "" {
+				leaked = append(leaked, h)
+			}
+		}
+		rw.Header().Set("X-Internal-Auth", "backend-secret")
+		rw.Header().Set("X-Debug-Token", "trace-123")
+		rw.Header().Set("X-Public", "kept")
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithStrippedHeaders("x-internal-auth", " X-Debug-Token"))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Internal-Auth", "client-spoofed")
+	req.Header.Set("X-Debug-Token", "client")
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, req)
+
+	if len(leaked) > 0 {
+		t.Errorf("Expected stripped headers not to reach the backend; got %v", leaked)
+	}
+	for _, h := range []string{"X-Internal-Auth", "X-Debug-Token"} {
+		if rw.Header().Get(h) != "" {
+			t.Errorf("Expected %s to be stripped from the response", h)
+		}
+	}
+	if rw.Header().Get("X-Public") != "kept" {
+		t.Errorf("Expected other headers to pass through")
+	}
+}
+
+func TestLoadBalancer_RequestHeaders(t *testing.T) {
+	newBackend := func(name string, got map[string]string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodHead {
+				return
+			}
+			got[name] = req.Header.Get("X-Routing-Token")
+		}))
+	}
+	got := make(map[string]string)
+	tokenBackend := newBackend("token", got)
+	defer tokenBackend.Close()
+	plainBackend := newBackend("plain", got)
+	defer plainBackend.Close()
+
+	lb := NewLoadBalancer("8000", []Server{
+		newSimpleServer(tokenBackend.URL, WithRequestHeaders(http.Header{"X-Routing-Token": {"shard-7"}})),
+		newSimpleServer(plainBackend.URL),
+	})
+	for range 2 {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Routing-Token", "client-spoofed")
+		lb.serveProxy(httptest.NewRecorder(), req)
+	}
+
+	if got["token"] != "shard-7" {
+		t.Errorf("Expected the configured backend to receive X-Routing-Token %q; got %q", "shard-7", got["token"])
+	}
+	if got["plain"] != "client-spoofed" {
+		t.Errorf("Expected the other backend to receive the client's X-Routing-Token; got %q", got["plain"])
+	}
+}
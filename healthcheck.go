@@ -0,0 +1,329 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// healthConfig configures the background health checks.
+type healthConfig struct {
+	interval    time.Duration
+	maxInterval time.Duration
+	// rise and fall are the consecutive successes and failures needed to mark a backend
+	// alive or dead again.
+	rise int
+	fall int
+	// loadHeader names a probe response header in which backends report their load
+	// between 0 (idle) and 1 (saturated).
+	loadHeader string
+	// concurrency caps how many backends are probed at once.
+	concurrency int
+}
+
+// healthChecker probes backends in the background so requests don't wait on health checks.
+// Each backend is probed on its own schedule, see WithHealthInterval. A failing backend is
+// probed with exponential backoff, capped at maxInterval, and goes back to the normal interval
+// as soon as it is healthy again.
+type healthChecker struct {
+	healthConfig
+	clock Clock
+	// onChange is called after a backend is marked alive or dead.
+	onChange func(s Server, alive bool)
+
+	mu     sync.Mutex
+	states map[Server]*healthState
+	// wake interrupts run's wait so reset backends are probed right away.
+	wake chan struct{}
+}
+
+type healthState struct {
+	checked   bool
+	alive     bool
+	successes int
+	failures  int
+	load      float64
+	nextProbe time.Time
+	// reason says why the last probe failed, empty after a successful one.
+	reason string
+	// resets counts the resets of the state, so the result of a probe that started before
+	// one is discarded.
+	resets int
+}
+
+func newHealthChecker(cfg healthConfig, clock Clock) *healthChecker {
+	cfg.maxInterval = max(cfg.maxInterval, cfg.interval)
+	cfg.rise = max(cfg.rise, 1)
+	cfg.fall = max(cfg.fall, 1)
+	cfg.concurrency = max(cfg.concurrency, 1)
+	return &healthChecker{
+		healthConfig: cfg,
+		clock:        clock,
+		states:       make(map[Server]*healthState),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// WithHealthInterval probes this backend every interval instead of at the WithHealthCheck
+// interval, e.g. more often for a critical backend than for spare ones. When it fails, its
+// probes back off from that interval up to the larger of it and the maxInterval.
+func WithHealthInterval(interval time.Duration) ServerOption {
+	return func(s *simpleServer) {
+		s.healthInterval = interval
+	}
+}
+
+func (s *simpleServer) HealthInterval() time.Duration {
+	return s.healthInterval
+}
+
+// intervalFor returns how often s is probed while it is healthy.
+func (hc *healthChecker) intervalFor(s Server) time.Duration {
+	if h, ok := s.(interface{ HealthInterval() time.Duration }); ok && h.HealthInterval() > 0 {
+		return h.HealthInterval()
+	}
+	return hc.interval
+}
+
+// backoff returns the delay before probing s after it failed the last n probes in a row.
+func (hc *healthChecker) backoff(s Server, failures int) time.Duration {
+	delay := hc.intervalFor(s)
+	limit := max(delay, hc.maxInterval)
+	for i := 0; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+// isAlive returns the result of the last probe. Backends that were never probed are assumed alive.
+func (hc *healthChecker) isAlive(s Server) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	state, ok := hc.states[s]
+	return !ok || state.alive
+}
+
+// reset forgets the health state of servers, so they are assumed alive until they are probed
+// again on the next round, which starts right away. States are reset in place because a probe
+// round may be using them.
+func (hc *healthChecker) reset(servers []Server) {
+	hc.mu.Lock()
+	for _, s := range servers {
+		if state, ok := hc.states[s]; ok {
+			*state = healthState{alive: true, resets: state.resets + 1}
+		}
+	}
+	hc.mu.Unlock()
+
+	select {
+	case hc.wake <- struct{}{}:
+	default:
+	}
+}
+
+// forget drops the health state of a backend that was removed. A probe round that still
+// includes it skips it.
+func (hc *healthChecker) forget(s Server) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	delete(hc.states, s)
+}
+
+// probeDue probes every backend whose next probe time has passed, up to concurrency at a
+// time, and returns when the earliest upcoming probe is due.
+func (hc *healthChecker) probeDue(servers []Server) time.Time {
+	now := hc.clock.Now()
+
+	var due []Server
+	hc.mu.Lock()
+	for _, s := range servers {
+		state, ok := hc.states[s]
+		if !ok {
+			state = &healthState{alive: true}
+			hc.states[s] = state
+		}
+		if !state.nextProbe.After(now) {
+			due = append(due, s)
+		}
+	}
+	hc.mu.Unlock()
+
+	work := make(chan Server)
+	var wg sync.WaitGroup
+	for i := 0; i < min(hc.concurrency, len(due)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range work {
+				hc.probeOne(s, now)
+			}
+		}()
+	}
+	for _, s := range due {
+		work <- s
+	}
+	close(work)
+	wg.Wait()
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	next := now.Add(hc.maxInterval)
+	for _, s := range servers {
+		if state, ok := hc.states[s]; ok && state.nextProbe.Before(next) {
+			next = state.nextProbe
+		}
+	}
+	return next
+}
+
+func (hc *healthChecker) probeOne(s Server, now time.Time) {
+	hc.mu.Lock()
+	state, ok := hc.states[s]
+	var resets int
+	if ok {
+		resets = state.resets
+	}
+	hc.mu.Unlock()
+	if !ok {
+		// Forgotten since the round started.
+		return
+	}
+
+	result := probe(s)
+	hc.recordLoad(state, resets, result)
+	if changed := hc.record(s, state, resets, result, now); changed && hc.onChange != nil {
+		hc.onChange(s, hc.isAlive(s))
+	}
+}
+
+// probe runs a health check, using the detailed check when the server supports one.
+func probe(s Server) probeResult {
+	if c, ok := s.(interface{ check() probeResult }); ok {
+		return c.check()
+	}
+	return probeResult{alive: s.IsAlive()}
+}
+
+// probeFailure describes why a probe got no response. Invalid TLS certificates, e.g. expired
+// ones or ones for another hostname, are called out so they aren't mistaken for an outage.
+func probeFailure(err error) string {
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return "invalid TLS certificate: " + certErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// reason returns why the last probe of s failed, or "" if it succeeded.
+func (hc *healthChecker) reason(s Server) string {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if state, ok := hc.states[s]; ok {
+		return state.reason
+	}
+	return ""
+}
+
+// load returns the last load reported by s, or 0 when unknown.
+func (hc *healthChecker) load(s Server) float64 {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if state, ok := hc.states[s]; ok {
+		return state.load
+	}
+	return 0
+}
+
+func (hc *healthChecker) recordLoad(state *healthState, resets int, result probeResult) {
+	if hc.loadHeader == "" || result.header == nil {
+		return
+	}
+	value := result.header.Get(hc.loadHeader)
+	if value == "" {
+		return
+	}
+	load, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if state.resets == resets {
+		state.load = min(max(load, 0), 1)
+	}
+}
+
+// record applies a probe result. The first probe decides the initial state; after that a
+// backend only changes state after rise successes or fall failures in a row, to avoid flapping.
+// It reports whether the backend changed state. Results of probes that started before the
+// last reset, when state had a different resets count, are ignored.
+func (hc *healthChecker) record(s Server, state *healthState, resets int, result probeResult, now time.Time) bool {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if state.resets != resets {
+		return false
+	}
+	ok := result.alive
+	state.reason = result.reason
+
+	if ok {
+		state.successes++
+		state.failures = 0
+	} else {
+		state.failures++
+		state.successes = 0
+	}
+
+	alive := state.alive
+	switch {
+	case !state.checked:
+		alive = ok
+		state.checked = true
+	case ok && state.successes >= hc.rise:
+		alive = true
+	case !ok && state.failures >= hc.fall:
+		alive = false
+	}
+
+	changed := alive != state.alive
+	if changed {
+		if alive {
+			fmt.Printf("Backend %q is healthy again\n", s.Address())
+		} else {
+			fmt.Printf("Backend %q failed its health check: %s\n", s.Address(), cmp.Or(state.reason, "unhealthy"))
+		}
+	}
+	state.alive = alive
+
+	if alive {
+		state.nextProbe = now.Add(hc.intervalFor(s))
+	} else {
+		state.nextProbe = now.Add(hc.backoff(s, state.failures-hc.fall+1))
+	}
+	return changed
+}
+
+// run probes the backends until ctx is cancelled. servers is called before every round so
+// backends added later are probed too.
+func (hc *healthChecker) run(ctx context.Context, servers func() []Server) {
+	for {
+		next := hc.probeDue(servers())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-hc.clock.After(next.Sub(hc.clock.Now())):
+		case <-hc.wake:
+		}
+	}
+}
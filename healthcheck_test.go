@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthChecker_Backoff(t *testing.T) {
+	var healthy atomic.Bool
+	var probes atomic.Int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		probes.Add(1)
+		if !healthy.Load() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backendServer.Close()
+
+	clock := newFakeClock()
+	hc := newHealthChecker(healthConfig{interval: time.Second, maxInterval: 8 * time.Second}, clock)
+	servers := []Server{newSimpleServer(backendServer.URL)}
+
+	// While the backend stays down the delay between probes doubles up to the cap.
+	var intervals []time.Duration
+	next := hc.probeDue(servers)
+	for i := 0; i < 5; i++ {
+		interval := next.Sub(clock.Now())
+		intervals = append(intervals, interval)
+		if i < 4 {
+			clock.Advance(interval)
+			next = hc.probeDue(servers)
+		}
+	}
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second, 8 * time.Second}
+	for i := range want {
+		if intervals[i] != want[i] {
+			t.Errorf("Expected probe interval %d to be %v; got %v", i, want[i], intervals[i])
+		}
+	}
+	if probes.Load() != 5 {
+		t.Errorf("Expected 5 probes; got %d", probes.Load())
+	}
+	if hc.isAlive(servers[0]) {
+		t.Errorf("Expected backend to be marked down")
+	}
+
+	// Probing before the next probe is due does nothing.
+	hc.probeDue(servers)
+	if probes.Load() != 5 {
+		t.Errorf("Expected no probe before the backoff elapsed; got %d probes", probes.Load())
+	}
+
+	// Once healthy the normal interval applies again.
+	healthy.Store(true)
+	clock.Advance(next.Sub(clock.Now()))
+	if next := hc.probeDue(servers); next.Sub(clock.Now()) != time.Second {
+		t.Errorf("Expected interval to reset to 1s after recovery; got %v", next.Sub(clock.Now()))
+	}
+	if !hc.isAlive(servers[0]) {
+		t.Errorf("Expected backend to be marked alive after recovery")
+	}
+}
+
+func TestHealthChecker_BackendIntervals(t *testing.T) {
+	newCountedBackend := func(probes *atomic.Int32) *httptest.Server {
+		backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			probes.Add(1)
+		}))
+		t.Cleanup(backendServer.Close)
+		return backendServer
+	}
+	var criticalProbes, spareProbes atomic.Int32
+	critical := newSimpleServer(newCountedBackend(&criticalProbes).URL, WithHealthInterval(time.Second))
+	spare := newSimpleServer(newCountedBackend(&spareProbes).URL)
+
+	clock := newFakeClock()
+	hc := newHealthChecker(healthConfig{interval: 5 * time.Second, maxInterval: 30 * time.Second}, clock)
+	servers := []Server{critical, spare}
+
+	// Run the rounds the checker would for 10 seconds.
+	end := clock.Now().Add(10 * time.Second)
+	for next := hc.probeDue(servers); !next.After(end); next = hc.probeDue(servers) {
+		if next.Sub(clock.Now()) != time.Second {
+			t.Fatalf("Expected the next round after the critical backend's 1s interval; got %v", next.Sub(clock.Now()))
+		}
+		clock.Advance(next.Sub(clock.Now()))
+	}
+	if got := criticalProbes.Load(); got != 11 {
+		t.Errorf("Expected the critical backend to be probed every second, 11 times; got %d", got)
+	}
+	if got := spareProbes.Load(); got != 3 {
+		t.Errorf("Expected the spare backend to be probed every 5s, 3 times; got %d", got)
+	}
+}
+
+func TestLoadBalancer_UsesBackgroundHealth(t *testing.T) {
+	var probes atomic.Int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			probes.Add(1)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithHealthCheck(time.Minute, time.Minute))
+	lb.health.probeDue(lb.pool.All())
+
+	for i := 0; i < 3; i++ {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Expected status OK; got %v", rw.Code)
+		}
+	}
+	if probes.Load() != 1 {
+		t.Errorf("Expected requests to reuse the background probe; got %d probes", probes.Load())
+	}
+}
+
+func TestHealthChecker_RiseAndFall(t *testing.T) {
+	var healthy atomic.Bool
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !healthy.Load() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backendServer.Close()
+
+	clock := newFakeClock()
+	hc := newHealthChecker(healthConfig{interval: time.Second, rise: 3, fall: 2}, clock)
+	servers := []Server{newSimpleServer(backendServer.URL)}
+	probe := func() bool {
+		clock.Advance(hc.probeDue(servers).Sub(clock.Now()))
+		return hc.isAlive(servers[0])
+	}
+
+	// The first probe decides the initial state.
+	if probe() {
+		t.Fatalf("Expected backend to start out dead")
+	}
+
+	healthy.Store(true)
+	for i := 1; i < 3; i++ {
+		if probe() {
+			t.Fatalf("Expected backend to stay dead after %d successful probes", i)
+		}
+	}
+	if !probe() {
+		t.Fatalf("Expected backend to be alive after 3 successful probes")
+	}
+
+	healthy.Store(false)
+	if !probe() {
+		t.Fatalf("Expected backend to stay alive after a single failure")
+	}
+	if probe() {
+		t.Fatalf("Expected backend to be dead after 2 failed probes")
+	}
+}
+
+func TestHealthChecker_Concurrency(t *testing.T) {
+	const backends, concurrency, probeTime = 40, 8, 50 * time.Millisecond
+	var inFlight, maxInFlight atomic.Int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if n <= seen || maxInFlight.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+		time.Sleep(probeTime)
+	}))
+	defer backendServer.Close()
+
+	var servers []Server
+	for i := 0; i < backends; i++ {
+		servers = append(servers, newSimpleServer(fmt.Sprintf("%s/backend-%d", backendServer.URL, i)))
+	}
+	hc := newHealthChecker(healthConfig{interval: time.Minute, concurrency: concurrency}, newFakeClock())
+
+	start := time.Now()
+	hc.probeDue(servers)
+	elapsed := time.Since(start)
+
+	if got := maxInFlight.Load(); got > concurrency {
+		t.Errorf("Expected at most %d concurrent probes; got %d", concurrency, got)
+	}
+	// Sequential probing would take backends*probeTime (2s).
+	if limit := 3 * backends / concurrency * probeTime; elapsed > limit {
+		t.Errorf("Expected a cycle to complete within %v; took %v", limit, elapsed)
+	}
+	for _, s := range servers {
+		if state := hc.states[s]; !state.checked || !state.alive {
+			t.Errorf("Expected %s to be probed alive", s.Address())
+		}
+	}
+}
+
+// slowProbeServer is a backend whose health checks block until release is closed.
+type slowProbeServer struct {
+	probing chan struct{}
+	release chan struct{}
+	probes  atomic.Int32
+}
+
+func (s *slowProbeServer) Address() string { return "http://slow.internal" }
+
+func (s *slowProbeServer) IsAlive() bool {
+	if s.probes.Add(1) == 1 {
+		close(s.probing)
+		<-s.release
+		return false
+	}
+	return true
+}
+
+func (s *slowProbeServer) Serve(rw http.ResponseWriter, r *http.Request) {}
+
+func TestHealthChecker_ResetDuringProbe(t *testing.T) {
+	server := &slowProbeServer{probing: make(chan struct{}), release: make(chan struct{})}
+	servers := []Server{server}
+	hc := newHealthChecker(healthConfig{interval: time.Minute}, newFakeClock())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hc.probeDue(servers)
+	}()
+	<-server.probing
+	hc.reset(servers)
+	close(server.release)
+	<-done
+
+	// The failed probe started before the reset, so it neither marks the backend dead nor
+	// postpones the fresh probe.
+	if !hc.isAlive(server) {
+		t.Errorf("Expected the result of a probe from before the reset to be discarded")
+	}
+	hc.probeDue(servers)
+	if n := server.probes.Load(); n != 2 {
+		t.Errorf("Expected the reset to trigger a fresh probe; got %d probes", n)
+	}
+	if !hc.isAlive(server) {
+		t.Errorf("Expected the fresh probe to mark the backend alive")
+	}
+}
+
+// newCertBackend starts a TLS backend presenting a self-signed certificate with the given
+// validity and IP addresses or DNS names, and returns a server with opts trusting that
+// certificate. handler may be nil.
+func newCertBackend(t *testing.T, notAfter time.Time, ips []net.IP, dnsNames []string, handler http.HandlerFunc, opts ...ServerOption) *simpleServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  ips,
+		DNSNames:     dnsNames,
+		NotBefore:    notAfter.Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if handler == nil {
+		handler = func(rw http.ResponseWriter, req *http.Request) {}
+	}
+	backendServer := httptest.NewUnstartedServer(handler)
+	backendServer.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	backendServer.StartTLS()
+	t.Cleanup(backendServer.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server := newSimpleServer(backendServer.URL, opts...)
+	if server.transport.TLSClientConfig == nil {
+		server.transport.TLSClientConfig = &tls.Config{}
+	}
+	server.transport.TLSClientConfig.RootCAs = roots
+	return server
+}
+
+func TestHealthChecker_InvalidCertificates(t *testing.T) {
+	localhost := []net.IP{net.IPv4(127, 0, 0, 1)}
+	tests := []struct {
+		name   string
+		server *simpleServer
+		reason string
+	}{
+		{"valid", newCertBackend(t, time.Now().Add(time.Hour), localhost, nil, nil), ""},
+		{"expired", newCertBackend(t, time.Now().Add(-time.Hour), localhost, nil, nil), "invalid TLS certificate: x509: certificate has expired"},
+		{"wrong host", newCertBackend(t, time.Now().Add(time.Hour), []net.IP{net.IPv4(10, 0, 0, 1)}, nil, nil), "invalid TLS certificate: x509: certificate is valid for 10.0.0.1, not 127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := NewLoadBalancer("8000", []Server{tt.server}, WithClock(newFakeClock()), WithHealthCheck(time.Second, time.Second))
+			lb.health.probeDue(lb.pool.All())
+
+			if alive := lb.isAlive(tt.server); alive != (tt.reason == "") {
+				t.Errorf("Expected alive to be %v; got %v", tt.reason == "", alive)
+			}
+			statuses := lb.backendStatuses()
+			if !strings.HasPrefix(statuses[0].HealthError, tt.reason) || (tt.reason == "") != (statuses[0].HealthError == "") {
+				t.Errorf("Expected the health error to start with %q; got %q", tt.reason, statuses[0].HealthError)
+			}
+		})
+	}
+}
+
+func TestHealthChecker_ServerName(t *testing.T) {
+	var sni, host atomic.Value
+	handler := func(rw http.ResponseWriter, req *http.Request) {
+		sni.Store(req.TLS.ServerName)
+		host.Store(req.Host)
+		rw.Write([]byte("ok"))
+	}
+	// The backend is addressed by IP but its certificate only names backend.internal.
+	byIP := newCertBackend(t, time.Now().Add(time.Hour), nil, []string{"backend.internal"}, handler)
+	named := newCertBackend(t, time.Now().Add(time.Hour), nil, []string{"backend.internal"}, handler, WithServerName("backend.internal"))
+
+	lb := NewLoadBalancer("8000", []Server{byIP, named}, WithClock(newFakeClock()), WithHealthCheck(time.Second, time.Second))
+	lb.health.probeDue(lb.pool.All())
+	if lb.isAlive(byIP) {
+		t.Errorf("Expected the probe of a backend addressed by IP to fail hostname verification")
+	}
+	if !lb.isAlive(named) {
+		t.Fatalf("Expected the probe with a server name to pass; got %q", lb.healthReason(named))
+	}
+	port := named.target.Port()
+	if sni.Load() != "backend.internal" || host.Load() != "backend.internal:"+port {
+		t.Errorf("Expected the probe to send SNI and Host for backend.internal; got %q and %q", sni.Load(), host.Load())
+	}
+
+	// Proxied requests use the name too.
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Body.String() != "ok" || host.Load() != "backend.internal:"+port {
+		t.Errorf("Expected the request to reach the backend as backend.internal; got %d %q with Host %q", rw.Code, rw.Body.String(), host.Load())
+	}
+}
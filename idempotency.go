@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// idempotencyKeyHeader carries the client-chosen key identifying retries of one request.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// WithIdempotencyKeys remembers the response to each POST or PATCH request carrying an
+// Idempotency-Key header for ttl, and answers requests with the same key, method and URL
+// from that response instead of sending them to a backend again. A duplicate arriving while
+// the first request is in flight waits for its response. 5xx responses are not kept, so the
+// client can retry after a failure.
+func WithIdempotencyKeys(ttl time.Duration) Option {
+	return func(lb *LoadBalancer) {
+		lb.idempotency = &idempotencyCache{
+			ttl:     ttl,
+			entries: make(map[string]*idempotentCall),
+		}
+	}
+}
+
+type idempotencyCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*idempotentCall
+}
+
+// idempotentCall is the response to a request with an idempotency key; resp and expires are
+// set before done is closed.
+type idempotentCall struct {
+	done    chan struct{}
+	resp    *bufferedResponse
+	expires time.Time
+}
+
+// appliesTo reports whether r is a request whose response is kept by its idempotency key.
+func (c *idempotencyCache) appliesTo(r *http.Request) bool {
+	return (r.Method == http.MethodPost || r.Method == http.MethodPatch) && r.Header.Get(idempotencyKeyHeader) != ""
+}
+
+func (c *idempotencyCache) key(r *http.Request) string {
+	return r.Method + " " + r.Host + r.URL.RequestURI() + "\n" + r.Header.Get(idempotencyKeyHeader)
+}
+
+// serve answers r from the response kept for its key, or through serve when there is none.
+func (c *idempotencyCache) serve(rw http.ResponseWriter, r *http.Request, clock Clock, serve http.HandlerFunc) {
+	key := c.key(r)
+	now := clock.Now()
+
+	c.mu.Lock()
+	if call, ok := c.entries[key]; ok {
+		select {
+		case <-call.done:
+			if now.Before(call.expires) {
+				c.mu.Unlock()
+				call.resp.writeTo(rw)
+				return
+			}
+		default:
+			c.mu.Unlock()
+			select {
+			case <-call.done:
+				call.resp.writeTo(rw)
+			case <-r.Context().Done():
+			}
+			return
+		}
+	}
+	c.sweep(now)
+	call := &idempotentCall{done: make(chan struct{})}
+	c.entries[key] = call
+	c.mu.Unlock()
+
+	// Duplicates wait for this call, so it must outlive this client disconnecting.
+	resp := newBufferedResponse()
+	serve(resp, r.WithContext(context.WithoutCancel(r.Context())))
+
+	c.mu.Lock()
+	call.resp = resp
+	call.expires = clock.Now().Add(c.ttl)
+	if resp.status >= http.StatusInternalServerError {
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	resp.writeTo(rw)
+}
+
+// sweep drops the expired responses. c.mu must be held.
+func (c *idempotencyCache) sweep(now time.Time) {
+	for key, call := range c.entries {
+		select {
+		case <-call.done:
+			if !now.Before(call.expires) {
+				delete(c.entries, key)
+			}
+		default:
+		}
+	}
+}
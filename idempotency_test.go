@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_IdempotencyKeys(t *testing.T) {
+	var hits atomic.Int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		n := hits.Add(1)
+		rw.Header().Set("X-Payment-ID", fmt.Sprint(n))
+		rw.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(rw, "payment %d", n)
+	}))
+	defer backendServer.Close()
+
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithClock(clock), WithIdempotencyKeys(time.Hour))
+	post := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/payments", strings.NewReader(`{"amount": 10}`))
+		req.Header.Set("Idempotency-Key", key)
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, req)
+		return rw
+	}
+
+	first, retry := post("key-1"), post("key-1")
+	if hits.Load() != 1 {
+		t.Errorf("Expected the backend to be hit once; got %d", hits.Load())
+	}
+	for _, rw := range []*httptest.ResponseRecorder{first, retry} {
+		if rw.Code != http.StatusCreated || rw.Body.String() != "payment 1" || rw.Header().Get("X-Payment-ID") != "1" {
+			t.Errorf("Expected both clients to get the first response; got %d %q", rw.Code, rw.Body.String())
+		}
+	}
+
+	if rw := post("key-2"); rw.Body.String() != "payment 2" {
+		t.Errorf("Expected a different key to reach the backend; got %q", rw.Body.String())
+	}
+
+	clock.Advance(time.Hour)
+	if rw := post("key-1"); rw.Body.String() != "payment 3" {
+		t.Errorf("Expected the kept response to expire after the TTL; got %q", rw.Body.String())
+	}
+}
+
+func TestLoadBalancer_IdempotencyKeysSkipServerErrors(t *testing.T) {
+	var hits atomic.Int32
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodHead && hits.Add(1) == 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithIdempotencyKeys(time.Hour))
+	for _, want := range []int{http.StatusInternalServerError, http.StatusOK} {
+		req := httptest.NewRequest("POST", "/payments", nil)
+		req.Header.Set("Idempotency-Key", "key-1")
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, req)
+		if rw.Code != want {
+			t.Errorf("Expected %d; got %d", want, rw.Code)
+		}
+	}
+}
@@ -2,50 +2,310 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"maps"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type simpleServer struct {
-	address string
-	proxy   *httputil.ReverseProxy
+	address   string
+	target    *url.URL
+	proxy     *httputil.ReverseProxy
+	transport *http.Transport
+	client    *http.Client
+
+	healthHeaders   http.Header
+	healthUserAgent string
+	healthyStatuses []StatusRange
+	healthInterval  time.Duration
+	// tcpCheckTimeout is set for backends probed with WithTCPHealthCheck.
+	tcpCheckTimeout time.Duration
+	requestHeaders  http.Header
+
+	// grpcClient is set for backends probed with WithGRPCHealthCheck.
+	grpcClient       *http.Client
+	grpcCheck        bool
+	grpcService      string
+	grpcCheckTimeout time.Duration
+
+	maxHeaderCount int
+	maxHeaderBytes int
+	maxBodyBytes   int64
+	truncateBody   bool
+
+	transformers []ResponseTransformer
+
+	weight   atomic.Int64
+	priority int
+	tags     map[string]string
+	timeout  time.Duration
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	// config is the config file entry the backend was created from, if any.
+	config *BackendConfig
+
+	dns *dnsRefresher
+
+	preserveHost bool
+	serverName   string
 }
 
-func newSimpleServer(addr string) *simpleServer {
+// ServerOption configures optional behavior of a simpleServer.
+type ServerOption func(*simpleServer)
+
+func newSimpleServer(addr string, opts ...ServerOption) *simpleServer {
 	serverUrl, err := url.Parse(addr)
 	handleErr(err)
 
-	return &simpleServer{
-		address: addr,
-		proxy:   httputil.NewSingleHostReverseProxy(serverUrl),
+	s := &simpleServer{
+		address:   addr,
+		target:    serverUrl,
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+	}
+	s.weight.Store(1)
+	s.proxy = &httputil.ReverseProxy{Director: s.director}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.proxy.Transport = s.transport
+	s.proxy.ModifyResponse = s.modifyResponse
+	s.proxy.ErrorHandler = s.errorHandler
+	s.client = &http.Client{
+		Transport: s.transport,
+		// A probe judges the backend's own response, not a page it redirects to.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	if s.grpcCheck {
+		s.grpcClient = s.newGRPCHealthClient()
+	}
+	return s
+}
+
+// WithUpstreamProxy sends proxied requests and health checks for the backend through an
+// outbound HTTP proxy, using CONNECT for HTTPS backends. Without it the HTTP_PROXY and
+// HTTPS_PROXY environment variables are honored.
+func WithUpstreamProxy(proxyAddr string) ServerOption {
+	proxyUrl, err := url.Parse(proxyAddr)
+	handleErr(err)
+
+	return func(s *simpleServer) {
+		s.transport.Proxy = http.ProxyURL(proxyUrl)
 	}
 }
 
 type LoadBalancer struct {
-	port            string
-	roundRobinCount int
-	servers         []Server
+	port             string
+	mu               sync.Mutex
+	pool             *Pool
+	strategy         Strategy
+	stickyHeader     string
+	weightHeader     string
+	advertised       map[Server]float64
+	healthConfig     healthConfig
+	health           *healthChecker
+	admission        *admissionQueue
+	priorityHeader   string
+	stats            sync.Map // Server → *backendStats
+	untrackedPaths   map[string]bool
+	draining         map[Server]bool
+	retries          int
+	retryStatuses    map[int]bool
+	retryOverrides   map[*Route]map[int]bool // Route.RetryStatuses
+	retryBufferSize  int
+	errorFormat      string
+	firstByteTimeout time.Duration
+	idleTimeout      time.Duration
+	debugErrors      bool
+	webhookURL       string
+	webhookRetries   int
+	webhook          *webhookNotifier
+	clock            Clock
+	routes           []*Route
+	limiters         map[*Route]*rateLimiter
+	coalescer        *coalescer
+	blockedMethods   map[string]bool
+	cors             *CORSConfig
+	shutdownHooks    []func(ctx context.Context)
+	flushPolicy      FlushPolicy
+	idempotency      *idempotencyCache
+	strippedHeaders  []string
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	breakers         *breakerSet
+	unmatchedStatus  int
+	metrics          Metrics
+	blueGreen        *blueGreen
+	clientLimit      *clientLimiter
+	events           eventBus
+	drainFile        string
+	fileDrained      atomic.Bool
+	adminDrained     atomic.Bool
+	reloader         *configReloader
+	stale            *staleCache
+	errorPages       map[int]ErrorPage
+	expansions       []*hostExpansion
 }
 
+// Option configures optional behavior of a LoadBalancer.
+type Option func(*LoadBalancer)
+
 type Server interface {
 	Address() string
 	IsAlive() bool
 	Serve(rw http.ResponseWriter, r *http.Request)
 }
 
-func NewLoadBalancer(port string, servers []Server) *LoadBalancer {
-	return &LoadBalancer{
+// NewLoadBalancer creates a load balancer serving port with the default backends servers. They
+// may be empty, e.g. when backends are only added to the Pool later; requests are then
+// answered with 503 Service Unavailable.
+func NewLoadBalancer(port string, servers []Server, opts ...Option) *LoadBalancer {
+	lb := &LoadBalancer{
 		port:            port,
-		roundRobinCount: 0,
-		servers:         servers,
+		clock:           realClock{},
+		retryBufferSize: defaultRetryBufferSize,
+		unmatchedStatus: http.StatusNotFound,
+	}
+	lb.pool = newPool(servers, lb.available)
+	lb.pool.cacheable = lb.availabilityCacheable
+	lb.pool.removed = lb.retire
+	WithRetryStatuses(defaultRetryStatuses...)(lb)
+	for _, opt := range opts {
+		opt(lb)
+	}
+	if lb.metrics != nil {
+		lb.Subscribe(lb.recordMetrics)
+	}
+	if lb.strategy == nil {
+		// Reported load and weights only matter to a weighted strategy.
+		if lb.healthConfig.loadHeader != "" || lb.weightHeader != "" {
+			lb.strategy = &weightedRoundRobin{}
+		} else {
+			lb.strategy = &roundRobin{}
+		}
+	}
+	if lb.stickyHeader != "" {
+		lb.strategy = &headerAffinity{header: lb.stickyHeader, next: lb.strategy}
+	}
+	sortRoutes(lb.routes)
+	for _, rt := range lb.routes {
+		if rt.RateLimit > 0 {
+			if lb.limiters == nil {
+				lb.limiters = make(map[*Route]*rateLimiter)
+			}
+			lb.limiters[rt] = newRateLimiter(rt.RateLimit, rt.Burst, lb.clock)
+		}
+		if rt.RetryStatuses != nil {
+			if lb.retryOverrides == nil {
+				lb.retryOverrides = make(map[*Route]map[int]bool)
+			}
+			lb.retryOverrides[rt] = statusSet(rt.RetryStatuses)
+		}
+	}
+	// Always created, since backends may enable their own breaker with WithBreaker.
+	lb.breakers = newBreakerSet(lb.breakerThreshold, lb.breakerCooldown, lb.clock)
+	lb.breakers.onChange = func(s Server, open bool) {
+		lb.pool.invalidate()
+		if open {
+			lb.emit(Event{Type: EventBreakerTripped, Backend: s})
+		} else {
+			lb.emit(Event{Type: EventBreakerReset, Backend: s})
+		}
+	}
+	if lb.webhookURL != "" {
+		lb.webhook = newWebhookNotifier(lb.webhookURL, lb.webhookRetries, lb.clock)
+		lb.Subscribe(lb.notifyWebhook)
+		lb.OnShutdown(func(context.Context) { lb.webhook.Close() })
+	}
+	if lb.healthConfig.interval > 0 {
+		lb.health = newHealthChecker(lb.healthConfig, lb.clock)
+		lb.health.onChange = func(s Server, alive bool) {
+			lb.pool.invalidate()
+			if alive {
+				lb.emit(Event{Type: EventBackendHealthy, Backend: s})
+			} else {
+				lb.emit(Event{Type: EventBackendUnhealthy, Backend: s})
+			}
+		}
+	}
+	return lb
+}
+
+// WithHealthCheck probes backends in the background every interval instead of on each request.
+// Failing backends are probed with exponential backoff up to maxInterval.
+func WithHealthCheck(interval, maxInterval time.Duration) Option {
+	return func(lb *LoadBalancer) {
+		lb.healthConfig.interval = interval
+		lb.healthConfig.maxInterval = maxInterval
+	}
+}
+
+// WithHealthThresholds requires rise consecutive successful probes before a dead backend is
+// put back into rotation, and fall consecutive failures before a live one is taken out.
+func WithHealthThresholds(rise, fall int) Option {
+	return func(lb *LoadBalancer) {
+		lb.healthConfig.rise = rise
+		lb.healthConfig.fall = fall
+	}
+}
+
+// WithHealthCheckConcurrency probes up to n backends at once in the background, so a cycle
+// over many backends completes quickly without opening unbounded connections.
+func WithHealthCheckConcurrency(n int) Option {
+	return func(lb *LoadBalancer) {
+		lb.healthConfig.concurrency = n
+	}
+}
+
+// WithLoadHeader makes the health checker read each backend's load (0 to 1) from the named
+// probe response header, e.g. "X-Load", and sends proportionally less traffic to loaded backends.
+// Unless another strategy is set, weighted round-robin is used.
+func WithLoadHeader(name string) Option {
+	return func(lb *LoadBalancer) {
+		lb.healthConfig.loadHeader = name
+	}
+}
+
+// WithAdmissionQueue proxies at most maxActive requests at once. Up to maxDepth further
+// requests wait in FIFO order, by priority with WithPriorityHeader, for at most timeout; the
+// rest are rejected with 503.
+func WithAdmissionQueue(maxActive, maxDepth int, timeout time.Duration) Option {
+	return func(lb *LoadBalancer) {
+		lb.admission = newAdmissionQueue(maxActive, maxDepth, timeout)
 	}
 }
 
+// StartHealthChecks runs the background health checks until ctx is cancelled. The returned
+// channel is closed once they have stopped, including any probe that was in flight.
+func (lb *LoadBalancer) StartHealthChecks(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	if lb.health == nil {
+		close(done)
+		return done
+	}
+	go func() {
+		defer close(done)
+		lb.health.run(ctx, lb.allServers)
+	}()
+	return done
+}
+
 func handleErr(err error) {
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
@@ -57,34 +317,649 @@ func (s *simpleServer) Address() string {
 	return s.address
 }
 
+// WithWeight sets the backend's share of traffic relative to the others for weighted strategies.
+func WithWeight(weight int) ServerOption {
+	return func(s *simpleServer) {
+		s.weight.Store(int64(weight))
+	}
+}
+
+func (s *simpleServer) Weight() int {
+	return int(s.weight.Load())
+}
+
+// SetWeight changes the backend's weight while it is serving traffic.
+func (s *simpleServer) SetWeight(weight int) {
+	s.weight.Store(int64(weight))
+}
+
+// WithPriority sets the backend's priority, used by the least-connections strategy to prefer
+// it over backends with as many active requests and a lower priority.
+func WithPriority(priority int) ServerOption {
+	return func(s *simpleServer) {
+		s.priority = priority
+	}
+}
+
+func (s *simpleServer) Priority() int {
+	return s.priority
+}
+
+// WithTags labels the backend, e.g. {"region": "us-east"}, for tag-based routing.
+func WithTags(tags map[string]string) ServerOption {
+	return func(s *simpleServer) {
+		s.tags = maps.Clone(tags)
+	}
+}
+
+func (s *simpleServer) Tags() map[string]string {
+	return s.tags
+}
+
+// WithPreserveHost forwards the client's Host header instead of the backend's host, for
+// backends serving name-based virtual hosts.
+func WithPreserveHost() ServerOption {
+	return func(s *simpleServer) {
+		s.preserveHost = true
+	}
+}
+
+// WithoutKeepAlive opens a new connection for every request to the backend, for backends that
+// close connections after each response without sending Connection: close. Otherwise a
+// request may be sent on a connection the backend already closed, and fail unless it is
+// idempotent and can be resent. A Connection: close from the backend is always honored.
+func WithoutKeepAlive() ServerOption {
+	return func(s *simpleServer) {
+		s.transport.DisableKeepAlives = true
+	}
+}
+
+// WithServerName connects to an HTTPS backend addressed by IP, e.g. "https://10.0.0.5:8443", as
+// the named host: the name is sent as SNI, the backend's certificate is verified against it,
+// and it replaces the IP in the Host header of health-check probes and of proxied requests not
+// using WithPreserveHost.
+func WithServerName(name string) ServerOption {
+	return func(s *simpleServer) {
+		s.serverName = name
+		if s.transport.TLSClientConfig == nil {
+			s.transport.TLSClientConfig = &tls.Config{}
+		}
+		s.transport.TLSClientConfig.ServerName = name
+	}
+}
+
+// hostHeader returns the Host header sent to the backend: its address, with the host replaced
+// by the WithServerName name when there is one.
+func (s *simpleServer) hostHeader() string {
+	if s.serverName == "" {
+		return s.target.Host
+	}
+	if port := s.target.Port(); port != "" {
+		return net.JoinHostPort(s.serverName, port)
+	}
+	return s.serverName
+}
+
+// WithHealthCheckHeaders adds headers to every health-check probe. A "Host" entry overrides
+// the Host sent to the backend.
+func WithHealthCheckHeaders(headers http.Header) ServerOption {
+	return func(s *simpleServer) {
+		s.healthHeaders = headers.Clone()
+	}
+}
+
+// WithHealthCheckUserAgent sets the User-Agent of health-check probes, such as
+// "lb-healthcheck/1.0", so backends can tell probes apart from real traffic.
+func WithHealthCheckUserAgent(userAgent string) ServerOption {
+	return func(s *simpleServer) {
+		s.healthUserAgent = userAgent
+	}
+}
+
+// StatusRange is an inclusive range of HTTP status codes, such as 200-299 or 302-302.
+type StatusRange struct {
+	Min, Max int
+}
+
+// parseStatusRanges parses comma-separated status codes and ranges, e.g. "200-299,302".
+func parseStatusRanges(s string) ([]StatusRange, error) {
+	var ranges []StatusRange
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		if !isRange {
+			hi = lo
+		}
+		first, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		last, err := strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		if first < 100 || last > 599 || first > last {
+			return nil, fmt.Errorf("invalid status range %q", part)
+		}
+		ranges = append(ranges, StatusRange{Min: first, Max: last})
+	}
+	return ranges, nil
+}
+
+// WithHealthyStatuses sets the status codes of health-check probes that count as healthy,
+// replacing the default of any status below 400. Probes don't follow redirects, so e.g. a
+// 302 can be listed or left out.
+func WithHealthyStatuses(ranges ...StatusRange) ServerOption {
+	return func(s *simpleServer) {
+		s.healthyStatuses = ranges
+	}
+}
+
+func (s *simpleServer) healthyStatus(code int) bool {
+	if len(s.healthyStatuses) == 0 {
+		return code < 400
+	}
+	for _, r := range s.healthyStatuses {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
 // Health check for server using a HEAD request to check if server is alive.
 func (s *simpleServer) IsAlive() bool {
-	resp, err := http.Head(s.address)
-	if err != nil || resp.StatusCode >= 400 {
-		return false
+	return s.check().alive
+}
+
+// probeResult is the outcome of a single health-check probe.
+type probeResult struct {
+	alive bool
+	// header holds the probe's response headers, if a response was received.
+	header http.Header
+	// reason says why a failed probe failed.
+	reason string
+}
+
+func (s *simpleServer) check() probeResult {
+	s.refreshDNS(context.Background())
+	switch {
+	case s.tcpCheckTimeout > 0:
+		return s.checkTCP()
+	case s.grpcClient != nil:
+		return s.checkGRPC()
+	}
+
+	req, err := http.NewRequest(http.MethodHead, s.address, nil)
+	if err != nil {
+		return probeResult{reason: err.Error()}
+	}
+	s.setProbeHeaders(req)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return probeResult{reason: probeFailure(err)}
+	}
+	resp.Body.Close()
+	if !s.healthyStatus(resp.StatusCode) {
+		return probeResult{header: resp.Header, reason: "status " + resp.Status}
+	}
+	return probeResult{alive: true, header: resp.Header}
+}
+
+// setProbeHeaders sets the Host, User-Agent and WithHealthCheckHeaders of a probe.
+func (s *simpleServer) setProbeHeaders(req *http.Request) {
+	req.Host = s.hostHeader()
+	if s.healthUserAgent != "" {
+		req.Header.Set("User-Agent", s.healthUserAgent)
+	}
+	for key, values := range s.healthHeaders {
+		if http.CanonicalHeaderKey(key) == "Host" {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(key)] = values
 	}
-	return true
 }
 
 func (s *simpleServer) Serve(rw http.ResponseWriter, r *http.Request) {
 	s.proxy.ServeHTTP(rw, r)
 }
 
+// director points the outgoing request at the backend, prefixing the request path with the
+// backend's base path, e.g. "/users" becomes "/api/users" for "http://host/api".
+func (s *simpleServer) director(req *http.Request) {
+	req.URL.Scheme = s.target.Scheme
+	req.URL.Host = s.target.Host
+	if s.target.RawPath != "" || req.URL.RawPath != "" {
+		req.URL.RawPath = joinURLPath(s.target.EscapedPath(), req.URL.EscapedPath())
+	}
+	req.URL.Path = joinURLPath(s.target.Path, req.URL.Path)
+
+	if s.target.RawQuery == "" || req.URL.RawQuery == "" {
+		req.URL.RawQuery = s.target.RawQuery + req.URL.RawQuery
+	} else {
+		req.URL.RawQuery = s.target.RawQuery + "&" + req.URL.RawQuery
+	}
+	if !s.preserveHost || req.Host == "" {
+		// HTTP/1.0 clients may omit Host, which HTTP/1.1 backends require.
+		req.Host = s.hostHeader()
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// Explicitly disable the default Go user agent.
+		req.Header.Set("User-Agent", "")
+	}
+	for key, values := range s.requestHeaders {
+		req.Header[http.CanonicalHeaderKey(key)] = values
+	}
+}
+
+// joinURLPath joins a base path and a request path with exactly one slash between them,
+// whether or not the base has a trailing slash or the request path a leading one.
+func joinURLPath(base, reqPath string) string {
+	base = strings.TrimRight(base, "/")
+	return base + "/" + strings.TrimLeft(reqPath, "/")
+}
+
+// WithMaxResponseHeaders limits the number of upstream response headers and their total size
+// in bytes. Responses exceeding either limit are answered with 502. Zero disables a limit.
+func WithMaxResponseHeaders(maxCount, maxBytes int) ServerOption {
+	return func(s *simpleServer) {
+		s.maxHeaderCount = maxCount
+		s.maxHeaderBytes = maxBytes
+		if maxBytes > 0 {
+			s.transport.MaxResponseHeaderBytes = int64(maxBytes)
+		}
+	}
+}
+
+func (s *simpleServer) modifyResponse(resp *http.Response) error {
+	a := attemptFromContext(resp.Request.Context())
+	if a != nil {
+		a.responded()
+		a.status = resp.StatusCode
+		stripHeaders(resp.Header, a.stripHeaders)
+		if a.weightHeader != "" {
+			if value := resp.Header.Get(a.weightHeader); value != "" {
+				a.weight, a.hasWeight = parseAdvertisedWeight(value)
+				resp.Header.Del(a.weightHeader)
+			}
+		}
+	}
+	if err := s.checkHeaderLimits(resp.Header); err != nil {
+		return err
+	}
+	if a != nil && a.retriable(resp.StatusCode) {
+		statusErr := &upstreamStatusError{status: resp.StatusCode}
+		if a.captureBody {
+			statusErr.body, _ = io.ReadAll(io.LimitReader(resp.Body, maxDebugBodyBytes))
+		}
+		return statusErr
+	}
+	if a != nil {
+		if err := a.watchIdle(resp); err != nil {
+			return err
+		}
+	}
+	if err := s.limitResponseBody(resp); err != nil {
+		return err
+	}
+	s.transformResponseBody(resp)
+	if a != nil {
+		a.applyFlushPolicy(resp)
+	}
+	return nil
+}
+
+func (s *simpleServer) checkHeaderLimits(h http.Header) error {
+	count, size := 0, 0
+	for key, values := range h {
+		count += len(values)
+		for _, value := range values {
+			// Account for the ": " separator and CRLF as on the wire.
+			size += len(key) + len(value) + 4
+		}
+	}
+
+	if s.maxHeaderCount > 0 && count > s.maxHeaderCount {
+		return fmt.Errorf("upstream sent %d response headers, limit is %d", count, s.maxHeaderCount)
+	}
+	if s.maxHeaderBytes > 0 && size > s.maxHeaderBytes {
+		return fmt.Errorf("upstream sent %d bytes of response headers, limit is %d", size, s.maxHeaderBytes)
+	}
+	return nil
+}
+
+// statusClientClosedRequest is nginx's non-standard status for requests whose client
+// disconnected before the response, so they stand out in the access log.
+const statusClientClosedRequest = 499
+
+// clientGone reports whether ctx, a request's context, was cancelled because its client
+// disconnected rather than by one of the load balancer's timeouts.
+func clientGone(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), context.Canceled)
+}
+
+func (s *simpleServer) errorHandler(rw http.ResponseWriter, r *http.Request, err error) {
+	if cause := context.Cause(r.Context()); errors.Is(cause, errFirstByteTimeout) || errors.Is(cause, errIdleTimeout) {
+		err = cause
+	}
+	if !clientGone(r.Context()) {
+		fmt.Printf("Proxy error for %q: %v\n", s.address, err)
+	}
+	if a := attemptFromContext(r.Context()); a != nil {
+		a.err = err
+		return
+	}
+	rw.WriteHeader(http.StatusBadGateway)
+}
+
+// Pool returns the load balancer's default backends, which may be changed at any time.
+func (lb *LoadBalancer) Pool() *Pool {
+	return lb.pool
+}
+
+// available reports whether s can take new requests: it is alive, not draining and its
+// circuit breaker is not open.
+func (lb *LoadBalancer) available(s Server) bool {
+	return !lb.isDraining(s) && lb.isAlive(s) && lb.breakers.allows(s)
+}
+
+// availabilityCacheable reports whether which backends are available only changes with
+// events that invalidate the pool's cache: health comes from background checks rather than a
+// probe per request, and no circuit breaker is open, since those half-open with time.
+func (lb *LoadBalancer) availabilityCacheable() bool {
+	return lb.health != nil && lb.breakers.tripped() == 0
+}
+
+func (lb *LoadBalancer) isAlive(s Server) bool {
+	if lb.health != nil {
+		return lb.health.isAlive(s)
+	}
+	return s.IsAlive()
+}
+
+// healthReason returns why the last background health check of s failed, if it did.
+func (lb *LoadBalancer) healthReason(s Server) string {
+	if lb.health != nil {
+		return lb.health.reason(s)
+	}
+	return ""
+}
+
+// getNextAvailableServer returns the next alive server chosen by the strategy, or nil when
+// every server is down.
 func (lb *LoadBalancer) getNextAvailableServer() Server {
-	server := lb.servers[lb.roundRobinCount%len(lb.servers)]
-	for !server.IsAlive() {
-		lb.roundRobinCount++
-		server = lb.servers[lb.roundRobinCount%len(lb.servers)]
+	return lb.nextServer(nil, lb.pool.All(), nil)
+}
+
+// minLoadWeight keeps fully loaded backends in rotation so they can report recovery.
+const minLoadWeight = 0.05
+
+// weight returns the configured or advertised weight of s scaled by the spare capacity it reports.
+func (lb *LoadBalancer) weight(s Server) float64 {
+	weight := 1.0
+	if w, ok := lb.advertisedWeight(s); ok {
+		weight = w
+	} else if w, ok := s.(interface{ Weight() int }); ok {
+		weight = float64(w.Weight())
+	}
+	if lb.health != nil && lb.healthConfig.loadHeader != "" {
+		weight *= max(1-lb.health.load(s), minLoadWeight)
+	}
+	return weight
+}
+
+// priority returns the priority of s, 0 unless it has one.
+func priority(s Server) int {
+	if p, ok := s.(interface{ Priority() int }); ok {
+		return p.Priority()
+	}
+	return 0
+}
+
+// nextServer picks an alive server for req among servers, skipping those in exclude.
+func (lb *LoadBalancer) nextServer(req *http.Request, servers []Server, exclude map[Server]bool) Server {
+	available := lb.availableOf(servers)
+	if p, ok := lb.strategy.(indexPicker); ok && len(exclude) == 0 {
+		if len(available) == 0 {
+			return nil
+		}
+		return available[p.pickIndex(len(available))]
+	}
+
+	candidates := make([]Candidate, 0, len(available))
+	for _, s := range available {
+		if !exclude[s] {
+			candidates = append(candidates, Candidate{
+				Server:         s,
+				Weight:         lb.weight(s),
+				ActiveRequests: lb.statsFor(s).activeRequests.Load(),
+				Priority:       priority(s),
+			})
+		}
 	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return lb.strategy.Next(req, candidates)
+}
 
-	lb.roundRobinCount++
-	return server
+// availableOf returns those of servers that can take new requests. The pool's backends are
+// answered from its cache, so picking among thousands of them doesn't ask each one.
+func (lb *LoadBalancer) availableOf(servers []Server) []Server {
+	if all := lb.pool.All(); len(servers) > 0 && len(servers) == len(all) && &servers[0] == &all[0] {
+		return lb.pool.Healthy()
+	}
+	var available []Server
+	for _, s := range servers {
+		if lb.available(s) {
+			available = append(available, s)
+		}
+	}
+	return available
 }
 
 func (lb *LoadBalancer) serveProxy(rw http.ResponseWriter, req *http.Request) {
-	targetServer := lb.getNextAvailableServer()
+	if lb.idempotency != nil && lb.idempotency.appliesTo(req) {
+		lb.idempotency.serve(rw, req, lb.clock, lb.proxyRequest)
+		return
+	}
+	if lb.coalescer != nil && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+		lb.coalescer.serve(rw, req, lb.proxyRequest)
+		return
+	}
+	lb.proxyRequest(rw, req)
+}
+
+func (lb *LoadBalancer) proxyRequest(rw http.ResponseWriter, req *http.Request) {
+	stripHeaders(req.Header, lb.strippedHeaders)
+	if lb.methodBlocked(req.Method) {
+		lb.writeError(rw, req, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	release, ok := lb.limitClient(rw, req)
+	if !ok {
+		return
+	}
+	defer release()
+	if lb.admission != nil {
+		if !lb.admission.acquire(req.Context(), lb.requestPriority(req)) {
+			lb.writeError(rw, req, http.StatusServiceUnavailable, "server busy")
+			return
+		}
+		defer lb.admission.release()
+	}
+
+	route := lb.matchRoute(req)
+	if lb.unmatched(route) {
+		lb.writeError(rw, req, lb.unmatchedStatus, "no route matches the request")
+		return
+	}
+	if req.Method == http.MethodOptions && lb.cors != nil {
+		lb.serveOptions(rw, req, route)
+		return
+	}
+	if route != nil && !route.allows(req.Method) {
+		rw.Header().Set("Allow", strings.Join(route.Methods, ", "))
+		lb.writeError(rw, req, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !lb.limitRoute(rw, req, route) {
+		return
+	}
+	candidates, ok := lb.groupFor(route)
+	if replicas := lb.replicasFor(route, req); replicas != nil {
+		candidates, ok = replicas, true
+	}
+	if !ok {
+		lb.writeDraining(rw, req)
+		return
+	}
+	if route != nil {
+		candidates = route.filterTags(req, candidates)
+	}
+
+	// Keep the start of the body so it can be replayed to another backend.
+	retries, retryStatuses := lb.retryPolicy(route)
+	var body *replayBody
+	if retries > 0 && req.Body != nil && req.Body != http.NoBody {
+		body = newReplayBody(req.Body, lb.retryBufferSize)
+		defer body.Close()
+	}
+
+	var recorder *staleRecorder
+	if lb.stale.appliesTo(req) {
+		recorder = newStaleRecorder(rw)
+		rw = recorder
+	}
+
+	var lastErr error
+	tried := make(map[Server]bool)
+	for i := 0; i <= retries; i++ {
+		targetServer := lb.nextServer(req, candidates, tried)
+		if targetServer == nil {
+			break
+		}
+		tried[targetServer] = true
+		ok, trial := lb.breakers.acquire(targetServer)
+		if !ok {
+			// Another request is the trial of its half-open breaker.
+			i--
+			continue
+		}
+		recorded := false
+		if trial {
+			// Attempts without an outcome, because the client went away, the body can't be
+			// replayed or the response was aborted, must not keep the trial forever.
+			defer func() {
+				if !recorded {
+					lb.breakers.release(targetServer)
+				}
+			}()
+		}
+
+		attempt := &proxyAttempt{captureBody: lb.debugErrors, weightHeader: lb.weightHeader, flushPolicy: lb.flushPolicy, stripHeaders: lb.strippedHeaders}
+		if i < retries {
+			attempt.retryStatuses = retryStatuses
+		}
+		if body != nil {
+			attemptBody, ok := body.attempt()
+			if !ok {
+				fmt.Printf("Not retrying: the request body outgrew the %d byte retry buffer\n", lb.retryBufferSize)
+				break
+			}
+			req.Body = attemptBody
+			attempt.body = body
+		}
+
+		attemptReq, done := lb.startAttempt(req, attempt, route, targetServer)
+		lb.serveAttempt(rw, attemptReq, targetServer)
+		done()
+		if attempt.hasWeight {
+			lb.observeWeight(targetServer, attempt.weight)
+		}
+		// A client going away says nothing about the backend.
+		if req.Context().Err() == nil {
+			lb.breakers.record(targetServer, attempt.err != nil || attempt.status >= http.StatusInternalServerError)
+			recorded = true
+		}
+		if attempt.err == nil {
+			if recorder != nil {
+				lb.stale.store(req, recorder, lb.clock.Now())
+			}
+			return
+		}
+		if clientGone(req.Context()) {
+			// There is no one left to retry for or to answer.
+			fmt.Printf("Client closed the request to %q\n", targetServer.Address())
+			rw.WriteHeader(statusClientClosedRequest)
+			return
+		}
+		lastErr = attempt.err
+		fmt.Printf("Attempt %d to %q failed: %v\n", i+1, targetServer.Address(), attempt.err)
+	}
+
+	if lb.stale.serve(rw, req, lb.clock.Now()) {
+		fmt.Printf("Serving a stale response to %s %s: no backend could serve it\n", req.Method, req.URL.Path)
+		return
+	}
+	if lastErr == nil {
+		lb.writeError(rw, req, http.StatusServiceUnavailable, "no backend available")
+		return
+	}
+	if errors.Is(lastErr, errFirstByteTimeout) || errors.Is(lastErr, errIdleTimeout) {
+		lb.writeUpstreamError(rw, req, http.StatusGatewayTimeout, "upstream timed out", lastErr)
+		return
+	}
+	lb.writeUpstreamError(rw, req, http.StatusBadGateway, "all backends failed to serve the request", lastErr)
+}
+
+func (lb *LoadBalancer) serveAttempt(rw http.ResponseWriter, req *http.Request, targetServer Server) {
 	fmt.Printf("Forwarding the request to address: %q\n", targetServer.Address())
-	targetServer.Serve(rw, req)
+	setUpstream(req, targetServer.Address())
+	req = withBackend(req, targetServer)
+
+	if a := attemptFromContext(req.Context()); a != nil && a.flushPolicy != nil {
+		fw := &flushWriter{ResponseWriter: rw, attempt: a}
+		defer fw.stop()
+		rw = fw
+	}
+
+	address := targetServer.Address()
+	defer func() {
+		if err := recover(); err != nil {
+			// ReverseProxy aborts a response whose client went away mid-stream, after closing
+			// the upstream connection.
+			if err == http.ErrAbortHandler && clientGone(req.Context()) {
+				fmt.Printf("Client closed the request to %q mid-response\n", address)
+			}
+			panic(err)
+		}
+	}()
+	st := lb.statsFor(targetServer)
+	st.activeRequests.Add(1)
+	start := lb.clock.Now()
+	lb.emit(Event{Type: EventRequestStarted, Backend: targetServer, Request: req})
+	defer func() {
+		st.activeRequests.Add(-1)
+		ev := Event{Type: EventRequestFinished, Backend: targetServer, Request: req, Duration: lb.clock.Now().Sub(start)}
+		if a := attemptFromContext(req.Context()); a != nil {
+			ev.Status, ev.Err = a.status, a.err
+		}
+		lb.emit(ev)
+	}()
+	if lb.untrackedPaths[req.URL.Path] {
+		targetServer.Serve(rw, req)
+		return
+	}
+
+	st.requests.Add(1)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &countingReader{ReadCloser: req.Body, n: &st.bytesSent}
+	}
+	sw := &statusWriter{ResponseWriter: rw}
+	targetServer.Serve(sw, req)
+	st.bytesReceived.Add(sw.bytes)
 }
 
 // Middleware to log incoming requests
@@ -96,13 +971,176 @@ func loggingMiddleware(next http.Handler) http.Handler {
 }
 
 func main() {
+	handleErr(runCommand(os.Args[1:], os.Stdout))
+}
+
+// runServe runs the load balancer until it is interrupted or upgraded.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	tlsCert := fs.String("tls-cert", "", "certificate file to serve HTTPS with, reloaded when it changes")
+	tlsKey := fs.String("tls-key", "", "private key file of -tls-cert")
+	configPath := fs.String("config", "", "JSON file with the port, backends and strategy (replaces the built-in example backends)")
+	accessLogFormat := fs.String("access-log-format", "", "access log format: combined or json (disabled when empty)")
+	accessLogDest := fs.String("access-log", "stdout", "access log destination: stdout, stderr or a file path")
+	clientConcurrency := fs.Int("client-concurrency", 0, "maximum requests in flight per client IP, above which clients get 429 (0 disables)")
+	accessLogSample := fs.Int("access-log-sample", 1, "log only 1 in this many requests, always logging those answered with a 5xx")
+	strategyName := fs.String("strategy", "", "balancing strategy: round-robin, weighted-round-robin, weighted-p2c, least-connections or a registered one (default round-robin, or weighted-round-robin with -load-header)")
+	stickyHeader := fs.String("sticky-header", "", "request header, such as X-Session-ID, whose value pins requests to a backend")
+	healthInterval := fs.Duration("health-check-interval", 10*time.Second, "probe backends in the background at this interval (0 probes on every request)")
+	healthMaxInterval := fs.Duration("health-check-max-interval", 2*time.Minute, "maximum backoff between probes of a failing backend")
+	healthRise := fs.Int("health-check-rise", 1, "consecutive successful probes before a backend is considered alive again")
+	weightHeader := fs.String("weight-header", "", "response header, such as X-LB-Weight, in which backends advertise their weight")
+	loadHeader := fs.String("load-header", "", "health-check response header in which backends report their load between 0 and 1")
+	healthUserAgent := fs.String("health-check-user-agent", "lb-healthcheck/1.0", "User-Agent sent with health-check probes")
+	healthConcurrency := fs.Int("health-check-concurrency", 10, "maximum number of backends probed at once")
+	healthFall := fs.Int("health-check-fall", 1, "consecutive failed probes before a backend is considered dead")
+	grpcWeb := fs.Bool("grpc-web", false, "translate gRPC-Web requests into native gRPC for the backends")
+	retries := fs.Int("retries", 0, "number of other backends to fail over to when a request fails")
+	retryBufferSize := fs.Int("retry-buffer-size", defaultRetryBufferSize, "bytes of each request body kept for replaying it on failover; larger bodies are streamed but not retried")
+	retryStatuses := fs.String("retry-statuses", "502,503,504", "comma-separated upstream status codes that trigger failover")
+	firstByteTimeout := fs.Duration("first-byte-timeout", 0, "maximum time a backend may take to send its response headers; streams are not limited once started (0 disables)")
+	idleTimeout := fs.Duration("idle-read-timeout", 0, "abort responses whose backend sends no body data for this long (0 disables)")
+	errorFormat := fs.String("error-format", errorFormatPlain, "format of errors returned by the load balancer: plain or json")
+	stateWebhook := fs.String("state-webhook", "", "URL to POST backend state changes to")
+	debugErrors := fs.Bool("debug-upstream-errors", false, "include the failing upstream's status and body in error responses (not for production)")
+	coalesce := fs.Bool("coalesce", false, "share one upstream call among concurrent identical GET requests")
+	drainFile := fs.String("drain-file", "", "drain the load balancer, failing /ready, while this file exists")
+	adminAddr := fs.String("admin-addr", "localhost:8001", "address of the admin server serving /status and /metrics (disabled when empty)")
+	reusePortListeners := fs.Int("listeners", 1, "number of SO_REUSEPORT listeners sharing the port, to spread accepts across cores")
+	preserveHost := fs.Bool("preserve-host", false, "forward the client's Host header instead of the backend's host")
+	dnsRefresh := fs.Bool("dns-refresh", false, "re-resolve backend hostnames on new connections and health checks to follow DNS changes")
+	expandHosts := fs.String("expand-hosts", "", "comma-separated backend addresses, such as http://api.internal:8080, balanced across every IP their hostname resolves to (replaces the built-in example backends without -config)")
+	expandInterval := fs.Duration("expand-interval", defaultExpandInterval, "how often the -expand-hosts hostnames are re-resolved")
+	untrackedPaths := fs.String("untracked-paths", "", "comma-separated paths, such as /health, left out of access logs and backend metrics")
+	proxyProtocol := fs.Bool("proxy-protocol", false, "expect a PROXY protocol v1 or v2 header on every connection, as sent by HAProxy or an AWS NLB, and take the client address from it")
+	trustedProxies := fs.String("trusted-proxies", "", "comma-separated CIDRs whose X-Forwarded-For header is trusted")
+	strippedHeaders := fs.String("strip-headers", "", "comma-separated headers, such as X-Internal-Auth, removed from requests and responses")
+	idempotencyTTL := fs.Duration("idempotency-ttl", 0, "how long responses to POST/PATCH requests with an Idempotency-Key are replayed to retries (0 disables)")
+	serveStale := fs.Duration("serve-stale-on-error", 0, "how long the last successful response to a GET request is served, marked stale, when no backend can serve it (0 disables)")
+	blockedMethods := fs.String("blocked-methods", "", "comma-separated methods, such as TRACE,TRACK, answered with 405 instead of being proxied")
+	corsOrigins := fs.String("cors-origins", "", "comma-separated origins (or *) allowed by CORS preflights; when set, OPTIONS requests are answered by the load balancer")
+	corsMaxAge := fs.Duration("cors-max-age", 0, "how long clients may cache CORS preflight results")
+	breakerThreshold := fs.Int("breaker-threshold", 0, "consecutive failed requests that open a backend's circuit breaker (0 disables)")
+	breakerCooldown := fs.Duration("breaker-cooldown", 30*time.Second, "how long an open circuit breaker waits before letting a trial request through")
+	fs.Parse(args)
+	fmt.Printf("Starting %s\n", buildVersion)
+
+	serverOpts := []ServerOption{WithHealthCheckUserAgent(*healthUserAgent)}
+	if *preserveHost {
+		serverOpts = append(serverOpts, WithPreserveHost())
+	}
+	if *dnsRefresh {
+		serverOpts = append(serverOpts, WithDNSRefresh(nil))
+	}
 	servers := []Server{
-		newSimpleServer("https://www.example.com"),
-		newSimpleServer("https://www.bing.com"),
-		newSimpleServer("https://www.google.com"),
+		newSimpleServer("https://www.example.com", serverOpts...),
+		newSimpleServer("https://www.bing.com", serverOpts...),
+		newSimpleServer("https://www.google.com", serverOpts...),
 	}
 
-	lb := NewLoadBalancer("8000", servers)
+	opts := []Option{WithErrorFormat(*errorFormat)}
+	if *expandHosts != "" {
+		servers = nil
+		for _, addr := range strings.Split(*expandHosts, ",") {
+			opts = append(opts, WithHostExpansion(addr, *expandInterval, nil, serverOpts...))
+		}
+	}
+	if *debugErrors {
+		opts = append(opts, WithDebugErrors())
+	}
+	var untracked []string
+	if *untrackedPaths != "" {
+		untracked = strings.Split(*untrackedPaths, ",")
+		opts = append(opts, WithUntrackedPaths(untracked...))
+	}
+	if *coalesce {
+		opts = append(opts, WithCoalescing())
+	}
+	if *strippedHeaders != "" {
+		opts = append(opts, WithStrippedHeaders(strings.Split(*strippedHeaders, ",")...))
+	}
+	if *idempotencyTTL > 0 {
+		opts = append(opts, WithIdempotencyKeys(*idempotencyTTL))
+	}
+	if *serveStale > 0 {
+		opts = append(opts, WithServeStaleOnError(*serveStale))
+	}
+	if *blockedMethods != "" {
+		opts = append(opts, WithBlockedMethods(strings.Split(*blockedMethods, ",")...))
+	}
+	if *corsOrigins != "" {
+		opts = append(opts, WithLocalOptions(CORSConfig{AllowedOrigins: strings.Split(*corsOrigins, ","), MaxAge: *corsMaxAge}))
+	}
+	if *breakerThreshold > 0 {
+		opts = append(opts, WithCircuitBreaker(*breakerThreshold, *breakerCooldown))
+	}
+	if *clientConcurrency > 0 {
+		opts = append(opts, WithClientConcurrencyLimit(*clientConcurrency))
+	}
+	if *idleTimeout > 0 {
+		opts = append(opts, WithIdleTimeout(*idleTimeout))
+	}
+	if *drainFile != "" {
+		opts = append(opts, WithDrainFile(*drainFile))
+	}
+	if *firstByteTimeout > 0 {
+		opts = append(opts, WithFirstByteTimeout(*firstByteTimeout))
+	}
+	if *weightHeader != "" {
+		opts = append(opts, WithWeightHeader(*weightHeader))
+	}
+	if *stickyHeader != "" {
+		opts = append(opts, WithStickyHeader(*stickyHeader))
+	}
+	if *strategyName != "" {
+		strategy, err := newStrategy(*strategyName, nil)
+		handleErr(err)
+		opts = append(opts, WithStrategy(strategy))
+	}
+	if *retries > 0 {
+		var codes []int
+		for _, code := range strings.Split(*retryStatuses, ",") {
+			status, err := strconv.Atoi(strings.TrimSpace(code))
+			handleErr(err)
+			codes = append(codes, status)
+		}
+		opts = append(opts, WithRetries(*retries), WithRetryStatuses(codes...), WithRetryBufferSize(*retryBufferSize))
+	}
+	if *stateWebhook != "" {
+		opts = append(opts, WithStateWebhook(*stateWebhook, 3))
+	}
+	if *healthInterval > 0 {
+		opts = append(opts, WithHealthCheck(*healthInterval, *healthMaxInterval), WithHealthThresholds(*healthRise, *healthFall), WithHealthCheckConcurrency(*healthConcurrency), WithLoadHeader(*loadHeader))
+	}
+	if *adminAddr != "" {
+		opts = append(opts, WithMetrics(NewPrometheusMetrics()))
+	}
+	port := "8000"
+	middlewareOrder := defaultMiddlewareOrder
+	if *configPath != "" {
+		cfg, err := LoadConfig(*configPath)
+		handleErr(err)
+		cfgOpts, err := cfg.options()
+		handleErr(err)
+		port, servers = cfg.Port, cfg.servers(serverOpts...)
+		if bg := cfg.blueGreen(serverOpts...); bg != nil {
+			cfgOpts = append(cfgOpts, bg)
+		}
+		if cfg.Middleware != nil {
+			middlewareOrder = cfg.Middleware
+		}
+		// Flags are applied last so they override the file.
+		opts = append(cfgOpts, append(opts, WithConfigReload(*configPath, serverOpts...))...)
+	}
+	lb := NewLoadBalancer(port, servers, opts...)
+
+	healthCtx, stopHealthChecks := context.WithCancel(context.Background())
+	defer stopHealthChecks()
+	healthChecksDone := lb.StartHealthChecks(healthCtx)
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	lb.WatchDrainFile(watchCtx)
+	lb.WatchHosts(watchCtx)
 
 	handleRedirect := func(rw http.ResponseWriter, req *http.Request) {
 		lb.serveProxy(rw, req)
@@ -111,36 +1149,143 @@ func main() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", handleRedirect)
 
-	// Apply logging middleware
-	loggedMux := loggingMiddleware(mux)
+	middleware := map[string]Middleware{
+		"recovery":   lb.recoveryMiddleware,
+		"logging":    loggingMiddleware,
+		"request-id": requestIDMiddleware,
+	}
+	if *grpcWeb {
+		middleware["grpc-web"] = grpcWebMiddleware
+	}
+	if *accessLogFormat != "" {
+		accessLog, err := newAccessLogger(*accessLogFormat, *accessLogDest)
+		handleErr(err)
+		defer accessLog.Close()
+		accessLog.exclude(untracked...)
+		accessLog.sample(*accessLogSample)
+		middleware["access-log"] = func(next http.Handler) http.Handler { return accessLogMiddleware(accessLog, next) }
+	}
+	if *trustedProxies != "" {
+		resolver, err := newClientIPResolver(strings.Split(*trustedProxies, ","))
+		handleErr(err)
+		middleware["real-ip"] = func(next http.Handler) http.Handler { return realIPMiddleware(resolver, next) }
+	}
+	handler := chainMiddleware(mux, middlewareOrder, middleware)
 
 	srv := &http.Server{
-		Addr:    ":8000",
-		Handler: loggedMux,
+		Addr:    ":" + lb.port,
+		Handler: handler,
+	}
+
+	// Reuse the listeners handed over by the previous process during a binary upgrade
+	ln, err := listen(srv.Addr, *reusePortListeners > 1)
+	handleErr(err)
+	listeners := map[string]net.Listener{srv.Addr: ln}
+
+	// Extra SO_REUSEPORT listeners on the same port spread accepts across kernel queues.
+	// Only the first one is handed over on upgrade; the new process opens its own extras.
+	serveListeners := []net.Listener{ln}
+	for i := 1; i < *reusePortListeners; i++ {
+		extra, err := newListener(srv.Addr, true)
+		handleErr(err)
+		serveListeners = append(serveListeners, extra)
+	}
+
+	var adminSrv *http.Server
+	if *adminAddr != "" {
+		adminSrv = &http.Server{
+			Addr:    *adminAddr,
+			Handler: lb.adminHandler(),
+		}
+		adminLn, err := listen(adminSrv.Addr, false)
+		handleErr(err)
+		listeners[adminSrv.Addr] = adminLn
+
+		go func() {
+			fmt.Printf("Serving admin endpoints at '%s'\n", *adminAddr)
+			if err := adminSrv.Serve(adminLn); err != nil && err != http.ErrServerClosed {
+				handleErr(err)
+			}
+		}()
 	}
 
 	// Graceful shutdown
-	go func() {
-		fmt.Printf("Serving requests at 'localhost:%s'\n", lb.port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			handleErr(err)
+	serve := srv.Serve
+	if *tlsCert != "" {
+		certs, err := newCertReloader(*tlsCert, *tlsKey)
+		handleErr(err)
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate}
+		serve = func(l net.Listener) error {
+			return srv.ServeTLS(l, "", "")
 		}
-	}()
+	}
+
+	if *proxyProtocol {
+		for i, l := range serveListeners {
+			serveListeners[i] = newProxyProtocolListener(l)
+		}
+	}
 
-	// Capture interrupt signal to gracefully shutdown the server
+	fmt.Printf("Serving requests at 'localhost:%s'\n", lb.port)
+	for _, l := range serveListeners {
+		go func() {
+			if err := serve(l); err != nil && err != http.ErrServerClosed {
+				handleErr(err)
+			}
+		}()
+	}
+
+	// Capture interrupt signal to gracefully shutdown the server. On an upgrade signal the
+	// listener is first handed to a new process so no connections are dropped.
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
+	upgradeRequested := make(chan os.Signal, 1)
+	notifyUpgrade(upgradeRequested)
+	reloadRequested := make(chan os.Signal, 1)
+	notifyReload(reloadRequested)
 
-	<-stop
+wait:
+	for {
+		select {
+		case <-stop:
+			break wait
+		case <-reloadRequested:
+			go func() {
+				if err := lb.Reload(); err != nil {
+					fmt.Printf("Reloading the config failed: %v\n", err)
+				}
+			}()
+		case <-upgradeRequested:
+			if err := upgrade(listeners); err != nil {
+				fmt.Printf("Binary upgrade failed: %v\n", err)
+				continue
+			}
+			fmt.Println("\nUpgraded process started, handing over the listeners...")
+			break wait
+		}
+	}
 	fmt.Println("\nShutting down the server...")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if adminSrv != nil {
+		adminSrv.Shutdown(ctx)
+	}
 	if err := srv.Shutdown(ctx); err != nil {
 		fmt.Printf("Shutdown error: %v\n", err)
 	} else {
 		fmt.Println("Server gracefully stopped.")
 	}
+	// Let in-flight probes finish so their state changes are still reported.
+	stopHealthChecks()
+	select {
+	case <-healthChecksDone:
+	case <-ctx.Done():
+	}
+	if err := lb.Shutdown(ctx); err != nil {
+		fmt.Printf("Shutdown hooks did not finish: %v\n", err)
+	}
+	return nil
 }
 //Author: Morteza Farrokhnejad
\ No newline at end of file
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSimpleServerIsAlive(t *testing.T) {
@@ -64,3 +73,482 @@ func TestLoadBalancer_ServeProxy(t *testing.T) {
 
 	//Author: Morteza Farrokhnejad
 }
+
+// newConnectProxy starts a stub HTTP proxy that tunnels CONNECT requests and counts them.
+func newConnectProxy(t *testing.T, tunnels *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodConnect {
+			http.Error(rw, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadGateway)
+			return
+		}
+		tunnels.Add(1)
+
+		rw.WriteHeader(http.StatusOK)
+		conn, _, err := http.NewResponseController(rw).Hijack()
+		if err != nil {
+			t.Errorf("Hijack failed: %v", err)
+			upstream.Close()
+			return
+		}
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+}
+
+func TestSimpleServer_UpstreamProxy(t *testing.T) {
+	backendServer := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("through the tunnel"))
+	}))
+	defer backendServer.Close()
+
+	var tunnels atomic.Int32
+	proxyServer := newConnectProxy(t, &tunnels)
+	defer proxyServer.Close()
+
+	server := newSimpleServer(backendServer.URL, WithUpstreamProxy(proxyServer.URL))
+	server.transport.TLSClientConfig = backendServer.Client().Transport.(*http.Transport).TLSClientConfig
+
+	if !server.IsAlive() {
+		t.Fatalf("Expected backend to be alive through the proxy")
+	}
+
+	rw := httptest.NewRecorder()
+	server.Serve(rw, httptest.NewRequest("GET", "/", nil))
+
+	if rw.Code != http.StatusOK || rw.Body.String() != "through the tunnel" {
+		t.Errorf("Expected proxied response; got %d %q", rw.Code, rw.Body.String())
+	}
+	if tunnels.Load() == 0 {
+		t.Errorf("Expected requests to traverse the upstream proxy")
+	}
+}
+
+func TestSimpleServerIsAlive_HealthCheckHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" || req.Host != "health.internal" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	if newSimpleServer(server.URL).IsAlive() {
+		t.Errorf("Expected server to be unhealthy without the health-check headers")
+	}
+
+	headers := http.Header{}
+	headers.Set("Authorization", "Bearer secret")
+	headers.Set("Host", "health.internal")
+	if !newSimpleServer(server.URL, WithHealthCheckHeaders(headers)).IsAlive() {
+		t.Errorf("Expected server to be alive when the health-check headers are sent")
+	}
+}
+
+func TestSimpleServerIsAlive_HealthCheckUserAgent(t *testing.T) {
+	userAgents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		userAgents <- req.UserAgent()
+	}))
+	defer server.Close()
+
+	if !newSimpleServer(server.URL, WithHealthCheckUserAgent("lb-healthcheck/1.0")).IsAlive() {
+		t.Fatalf("Expected server to be alive")
+	}
+	if ua := <-userAgents; ua != "lb-healthcheck/1.0" {
+		t.Errorf("Expected probe User-Agent %q; got %q", "lb-healthcheck/1.0", ua)
+	}
+}
+
+func TestSimpleServerIsAlive_HealthyStatuses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/login" {
+			rw.WriteHeader(http.StatusOK)
+			return
+		}
+		// The probe judges the redirect itself, not the login page.
+		http.Redirect(rw, req, "/login", http.StatusFound)
+	}))
+	defer server.Close()
+
+	healthy := newSimpleServer(server.URL, WithHealthyStatuses(StatusRange{200, 299}, StatusRange{302, 302}))
+	if !healthy.IsAlive() {
+		t.Errorf("Expected a 302 to be healthy when configured so")
+	}
+	unhealthy := newSimpleServer(server.URL, WithHealthyStatuses(StatusRange{200, 299}))
+	if result := unhealthy.check(); result.alive || result.reason != "status 302 Found" {
+		t.Errorf("Expected a 302 to be unhealthy when only 2xx is; got %+v", result)
+	}
+	if !newSimpleServer(server.URL).IsAlive() {
+		t.Errorf("Expected a 302 to be healthy by default")
+	}
+}
+
+func TestSimpleServer_MaxResponseHeaders(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/large":
+			rw.Header().Set("X-Large", strings.Repeat("a", 8<<10))
+		case "/many":
+			for i := 0; i < 50; i++ {
+				rw.Header().Add(fmt.Sprintf("X-Header-%d", i), "v")
+			}
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer backendServer.Close()
+
+	server := newSimpleServer(backendServer.URL, WithMaxResponseHeaders(20, 4<<10))
+
+	tests := map[string]int{
+		"/":      http.StatusOK,
+		"/large": http.StatusBadGateway,
+		"/many":  http.StatusBadGateway,
+	}
+	for path, want := range tests {
+		rw := httptest.NewRecorder()
+		server.Serve(rw, httptest.NewRequest("GET", path, nil))
+		if rw.Code != want {
+			t.Errorf("Expected status %d for %s; got %d", want, path, rw.Code)
+		}
+	}
+}
+
+func TestLoadBalancer_LoadHeaderWeights(t *testing.T) {
+	newLoadedBackend := func(load string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("X-Load", load)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	busy := newSimpleServer(newLoadedBackend("0.8").URL)
+	idle := newSimpleServer(newLoadedBackend("0").URL)
+
+	lb := NewLoadBalancer("8000", []Server{busy, idle}, WithHealthCheck(time.Minute, time.Minute), WithLoadHeader("X-Load"))
+	lb.health.probeDue(lb.pool.All())
+
+	counts := map[Server]int{}
+	for i := 0; i < 600; i++ {
+		counts[lb.getNextAvailableServer()]++
+	}
+
+	// Weights are the spare capacity: 0.2 for the busy backend and 1 for the idle one.
+	if counts[busy] < 90 || counts[busy] > 110 || counts[idle] < 490 || counts[idle] > 510 {
+		t.Errorf("Expected about 100 picks of the busy backend and 500 of the idle one; got %d and %d", counts[busy], counts[idle])
+	}
+}
+
+func TestSimpleServer_PathJoining(t *testing.T) {
+	tests := []struct {
+		base, reqPath, want string
+	}{
+		{"", "/users", "/users"},
+		{"", "", "/"},
+		{"/", "/users", "/users"},
+		{"/api", "/users", "/api/users"},
+		{"/api/", "/users", "/api/users"},
+		{"/api", "users", "/api/users"},
+		{"/api/", "//users", "/api/users"},
+		{"/api", "/", "/api/"},
+		{"/api", "/users/", "/api/users/"},
+	}
+
+	for _, tt := range tests {
+		server := newSimpleServer("http://backend.internal" + tt.base)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = tt.reqPath
+		server.director(req)
+
+		if req.URL.Path != tt.want {
+			t.Errorf("Joining %q and %q: expected %q; got %q", tt.base, tt.reqPath, tt.want, req.URL.Path)
+		}
+		if req.URL.Host != "backend.internal" {
+			t.Errorf("Expected request to target the backend host; got %q", req.URL.Host)
+		}
+	}
+}
+
+func TestSimpleServer_BasePathProxying(t *testing.T) {
+	var gotPath, gotQuery string
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		gotPath = req.URL.EscapedPath()
+		gotQuery = req.URL.RawQuery
+	}))
+	defer backendServer.Close()
+
+	server := newSimpleServer(backendServer.URL + "/api/?key=1")
+	server.Serve(httptest.NewRecorder(), httptest.NewRequest("GET", "/users/a%2Fb?page=2", nil))
+
+	if gotPath != "/api/users/a%2Fb" {
+		t.Errorf("Expected backend to receive %q; got %q", "/api/users/a%2Fb", gotPath)
+	}
+	if gotQuery != "key=1&page=2" {
+		t.Errorf("Expected query %q; got %q", "key=1&page=2", gotQuery)
+	}
+}
+
+func TestLoadBalancer_HTTP10Clients(t *testing.T) {
+	type upstreamRequest struct{ proto, host string }
+	received := make(chan upstreamRequest, 1)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		received <- upstreamRequest{req.Proto, req.Host}
+		rw.Write([]byte("hello"))
+	}))
+	defer backendServer.Close()
+	backendURL, _ := url.Parse(backendServer.URL)
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)})
+	front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+	defer front.Close()
+
+	tests := []struct {
+		name      string
+		request   string
+		wantClose bool
+		wantHost  string
+	}{
+		{"without Host", "GET /legacy HTTP/1.0\r\n\r\n", true, backendURL.Host},
+		{"keep-alive", "GET /legacy HTTP/1.0\r\nHost: legacy.example\r\nConnection: keep-alive\r\n\r\n", false, backendURL.Host},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", front.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			io.WriteString(conn, tt.request)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+				t.Errorf("Expected the proxied response; got %d %q", resp.StatusCode, body)
+			}
+			if resp.ProtoMajor != 1 || resp.ProtoMinor != 0 {
+				t.Errorf("Expected an HTTP/1.0 response; got %s", resp.Proto)
+			}
+			if resp.Close != tt.wantClose {
+				t.Errorf("Expected connection close %v; got %v", tt.wantClose, resp.Close)
+			}
+
+			up := <-received
+			if up.proto != "HTTP/1.1" {
+				t.Errorf("Expected the backend to be spoken to in HTTP/1.1; got %s", up.proto)
+			}
+			if up.host != tt.wantHost {
+				t.Errorf("Expected Host %q upstream; got %q", tt.wantHost, up.host)
+			}
+		})
+	}
+}
+
+func TestSimpleServer_PreserveHost(t *testing.T) {
+	hosts := make(chan string, 1)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		hosts <- req.Host
+	}))
+	defer backendServer.Close()
+	backendURL, _ := url.Parse(backendServer.URL)
+
+	tests := []struct {
+		name     string
+		opts     []ServerOption
+		wantHost string
+	}{
+		{"rewritten by default", nil, backendURL.Host},
+		{"preserved", []ServerOption{WithPreserveHost()}, "shop.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Host = "shop.example.com"
+			newSimpleServer(backendServer.URL, tt.opts...).Serve(httptest.NewRecorder(), req)
+			if got := <-hosts; got != tt.wantHost {
+				t.Errorf("Expected backend to receive Host %q; got %q", tt.wantHost, got)
+			}
+		})
+	}
+}
+
+func TestLoadBalancer_ExpectContinue(t *testing.T) {
+	const size = 4 << 20
+	var received atomic.Int64
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		n, _ := io.Copy(io.Discard, req.Body)
+		received.Store(n)
+		rw.WriteHeader(http.StatusCreated)
+	}))
+	defer backendServer.Close()
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"streamed", nil},
+		{"retries", []Option{WithRetries(1)}},
+		{"idempotency key", []Option{WithIdempotencyKeys(time.Minute)}},
+	}
+	for _, tt := range tests {
+		lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, tt.opts...)
+		front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+
+		// The client holds the body back until it sees 100 Continue, or for up to 5s.
+		const expectTimeout = 5 * time.Second
+		client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: expectTimeout}}
+		req, _ := http.NewRequest("POST", front.URL+"/upload", strings.NewReader(strings.Repeat("x", size)))
+		req.Header.Set("Expect", "100-continue")
+		req.Header.Set("Idempotency-Key", tt.name)
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		front.Close()
+
+		if resp.StatusCode != http.StatusCreated || received.Load() != size {
+			t.Errorf("%s: Expected the upload to complete; got %d with %d bytes", tt.name, resp.StatusCode, received.Load())
+		}
+		if elapsed := time.Since(start); elapsed >= expectTimeout {
+			t.Errorf("%s: Expected 100 Continue to reach the client promptly; upload took %v", tt.name, elapsed)
+		}
+	}
+}
+
+func TestLoadBalancer_NoServers(t *testing.T) {
+	for _, name := range []string{"round-robin", "weighted-round-robin", "weighted-p2c", "least-connections"} {
+		t.Run(name, func(t *testing.T) {
+			strategy, _ := newStrategy(name, nil)
+			lb := NewLoadBalancer("8000", []Server{}, WithStrategy(strategy), WithRetries(2))
+			if s := lb.getNextAvailableServer(); s != nil {
+				t.Errorf("Expected no backend to be selected; got %q", s.Address())
+			}
+
+			rw := httptest.NewRecorder()
+			lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+			if rw.Code != http.StatusServiceUnavailable {
+				t.Errorf("Expected 503 without backends; got %d", rw.Code)
+			}
+		})
+	}
+}
+
+// captureStdout redirects stdout, where the load balancer logs, until the returned function
+// is called, which returns what was printed.
+func captureStdout(t *testing.T) func() string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create a pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	var out strings.Builder
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(&out, r)
+		close(copied)
+	}()
+	return func() string {
+		os.Stdout = stdout
+		w.Close()
+		<-copied
+		r.Close()
+		return out.String()
+	}
+}
+
+func TestLoadBalancer_ClientDisconnect(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// midStream has the backend send part of the response before the client leaves.
+		midStream bool
+		want      string
+	}{
+		{name: "before headers", want: "Client closed the request to"},
+		{name: "mid-stream", midStream: true, want: "mid-response"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			cancelled := make(chan struct{}, 2)
+			backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if req.Method == http.MethodHead {
+					return
+				}
+				requests.Add(1)
+				if tt.midStream {
+					rw.Write([]byte("first chunk\n"))
+					rw.(http.Flusher).Flush()
+				}
+				// The upstream request is only cancelled once its connection is closed.
+				<-req.Context().Done()
+				cancelled <- struct{}{}
+			}))
+			defer backendServer.Close()
+
+			output := captureStdout(t)
+			lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL), newSimpleServer(backendServer.URL)}, WithRetries(1))
+			front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+
+			conn, err := net.Dial("tcp", front.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: lb\r\n\r\n")
+			if tt.midStream {
+				resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+				if err != nil {
+					t.Fatalf("Failed to read the response: %v", err)
+				}
+				if line, _ := bufio.NewReader(resp.Body).ReadString('\n'); line != "first chunk\n" {
+					t.Fatalf("Expected the first chunk; got %q", line)
+				}
+			} else {
+				time.Sleep(50 * time.Millisecond)
+			}
+			conn.Close()
+
+			select {
+			case <-cancelled:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Expected the upstream request to be cancelled when the client left")
+			}
+			front.Close()
+			logged := output()
+
+			if n := requests.Load(); n != 1 {
+				t.Errorf("Expected no retry for a client that left; the backends got %d requests", n)
+			}
+			if !strings.Contains(logged, tt.want) {
+				t.Errorf("Expected the disconnect to be logged with %q; got\n%s", tt.want, logged)
+			}
+			for _, errorLog := range []string{"Proxy error", "failed", "ReverseProxy"} {
+				if strings.Contains(logged, errorLog) {
+					t.Errorf("Expected no error to be logged for a client that left; got\n%s", logged)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultAllowedMethods is advertised in answers to OPTIONS requests for routes that don't
+// restrict their methods.
+var defaultAllowedMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// WithBlockedMethods answers requests using any of methods, such as "TRACE" and "TRACK",
+// with 405 Method Not Allowed instead of passing them to the backends.
+func WithBlockedMethods(methods ...string) Option {
+	return func(lb *LoadBalancer) {
+		lb.blockedMethods = make(map[string]bool, len(methods))
+		for _, m := range methods {
+			lb.blockedMethods[strings.ToUpper(m)] = true
+		}
+	}
+}
+
+// CORSConfig configures how the load balancer answers CORS preflight requests itself.
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed to make cross-origin requests, or "*" for any.
+	AllowedOrigins []string
+	// AllowedMethods defaults to the route's methods.
+	AllowedMethods []string
+	// AllowedHeaders defaults to echoing the headers the client asks for.
+	AllowedHeaders []string
+	// MaxAge lets clients cache the preflight result for that long.
+	MaxAge time.Duration
+}
+
+// WithLocalOptions answers OPTIONS requests at the load balancer instead of passing them to
+// the backends: CORS preflights get the headers allowed by cors, and other OPTIONS requests
+// get an Allow header.
+func WithLocalOptions(cors CORSConfig) Option {
+	return func(lb *LoadBalancer) {
+		lb.cors = &cors
+	}
+}
+
+func (lb *LoadBalancer) methodBlocked(method string) bool {
+	return lb.blockedMethods[method]
+}
+
+// serveOptions answers an OPTIONS request for route, which is nil for the default group.
+func (lb *LoadBalancer) serveOptions(rw http.ResponseWriter, req *http.Request, route *Route) {
+	methods := defaultAllowedMethods
+	if route != nil && len(route.Methods) > 0 {
+		methods = route.Methods
+	}
+	header := rw.Header()
+	header.Set("Allow", strings.Join(methods, ", "))
+
+	origin := req.Header.Get("Origin")
+	if origin != "" && req.Header.Get("Access-Control-Request-Method") != "" {
+		header.Add("Vary", "Origin")
+		if lb.cors.allowsOrigin(origin) {
+			if len(lb.cors.AllowedMethods) > 0 {
+				methods = lb.cors.AllowedMethods
+			}
+			header.Set("Access-Control-Allow-Origin", origin)
+			header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(lb.cors.AllowedHeaders) > 0 {
+				header.Set("Access-Control-Allow-Headers", strings.Join(lb.cors.AllowedHeaders, ", "))
+			} else if requested := req.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				header.Set("Access-Control-Allow-Headers", requested)
+			}
+			if lb.cors.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(int(lb.cors.MaxAge.Seconds())))
+			}
+		}
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CORSConfig) allowsOrigin(origin string) bool {
+	return slices.Contains(c.AllowedOrigins, "*") || slices.Contains(c.AllowedOrigins, origin)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newCountingBackend starts a backend counting the proxied (non-HEAD) requests it receives.
+func newCountingBackend(t *testing.T) (*simpleServer, *atomic.Int32) {
+	var requests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodHead {
+			requests.Add(1)
+		}
+	}))
+	t.Cleanup(backend.Close)
+	return newSimpleServer(backend.URL), &requests
+}
+
+func TestLoadBalancer_BlockedMethods(t *testing.T) {
+	backend, requests := newCountingBackend(t)
+	lb := NewLoadBalancer("8000", []Server{backend}, WithBlockedMethods("TRACE", "track"))
+
+	for _, method := range []string{"TRACE", "TRACK"} {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest(method, "/", nil))
+		if rw.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected %s to be rejected with 405; got %d", method, rw.Code)
+		}
+	}
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Code != http.StatusOK {
+		t.Errorf("Expected GET to pass through; got %d", rw.Code)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Expected only the GET to reach the backend; got %d requests", n)
+	}
+
+	// Without the option TRACE is proxied as before.
+	lb = NewLoadBalancer("8000", []Server{backend})
+	lb.serveProxy(httptest.NewRecorder(), httptest.NewRequest("TRACE", "/", nil))
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Expected TRACE to be proxied by default; got %d requests", n)
+	}
+}
+
+func TestLoadBalancer_LocalOptions(t *testing.T) {
+	backend, requests := newCountingBackend(t)
+	route := &Route{PathPrefix: "/api", Methods: []string{"GET", "POST"}, Servers: []Server{backend}}
+	lb := NewLoadBalancer("8000", []Server{backend}, WithRoutes(route),
+		WithLocalOptions(CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, MaxAge: 10 * time.Minute}))
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "/api/users", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "Content-Type")
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, req)
+		return rw
+	}
+
+	rw := preflight("https://app.example.com")
+	if rw.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 for a preflight; got %d", rw.Code)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "Content-Type",
+		"Access-Control-Max-Age":       "600",
+		"Allow":                        "GET, POST",
+	}
+	for key, value := range want {
+		if got := rw.Header().Get(key); got != value {
+			t.Errorf("Expected %s %q; got %q", key, value, got)
+		}
+	}
+
+	if rw := preflight("https://evil.example.com"); rw.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("Expected no CORS headers for a disallowed origin")
+	}
+
+	rw = httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("OPTIONS", "/", nil))
+	if rw.Code != http.StatusNoContent || rw.Header().Get("Allow") == "" {
+		t.Errorf("Expected a plain OPTIONS request to get 204 with Allow; got %d %q", rw.Code, rw.Header().Get("Allow"))
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("Expected OPTIONS to be handled locally; backend got %d requests", n)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics receives the load balancer's metrics as they are recorded, so they can be sent to
+// e.g. StatsD or OpenTelemetry. labels are name and value pairs, such as "backend" and the
+// backend's address.
+type Metrics interface {
+	// Count adds delta to a counter.
+	Count(name string, delta float64, labels ...string)
+	// Gauge sets a gauge to value.
+	Gauge(name string, value float64, labels ...string)
+	// Observe records value, such as a duration in seconds, in a histogram.
+	Observe(name string, value float64, labels ...string)
+}
+
+// WithMetrics sends the metrics of proxied requests to m:
+//   - lb_upstream_requests_total, a counter labeled with the backend and the status code of
+//     its response, "error" when it sent none,
+//   - lb_upstream_request_duration_seconds, a histogram labeled with the backend,
+//   - lb_upstream_in_flight_requests, a gauge labeled with the backend.
+//
+// Requests to WithUntrackedPaths are only counted as in flight.
+func WithMetrics(m Metrics) Option {
+	return func(lb *LoadBalancer) {
+		lb.metrics = m
+	}
+}
+
+// recordMetrics turns the request events of backend attempts into the WithMetrics metrics.
+func (lb *LoadBalancer) recordMetrics(ev Event) {
+	switch ev.Type {
+	case EventRequestStarted, EventRequestFinished:
+	default:
+		return
+	}
+	address := ev.Backend.Address()
+	if ev.Type == EventRequestFinished && !lb.untrackedPaths[ev.Request.URL.Path] {
+		code := "error"
+		if ev.Status != 0 {
+			code = strconv.Itoa(ev.Status)
+		}
+		lb.metrics.Count("lb_upstream_requests_total", 1, "backend", address, "code", code)
+		lb.metrics.Observe("lb_upstream_request_duration_seconds", ev.Duration.Seconds(), "backend", address)
+	}
+	lb.metrics.Gauge("lb_upstream_in_flight_requests", float64(lb.statsFor(ev.Backend).activeRequests.Load()), "backend", address)
+}
+
+// defaultBuckets are the upper bounds of the histogram buckets of PrometheusMetrics, suited
+// to request durations in seconds.
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics collects Metrics for the admin server's /metrics, which appends them in
+// the Prometheus text exposition format.
+type PrometheusMetrics struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	kind string
+	// series is keyed by the formatted labels, e.g. `backend="http://10.0.0.1:8080"`.
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	value float64
+	// counts holds the observations of a histogram per bucket, the last one being +Inf.
+	counts []uint64
+	count  uint64
+}
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{families: make(map[string]*metricFamily)}
+}
+
+func (m *PrometheusMetrics) Count(name string, delta float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(name, "counter", labels).value += delta
+}
+
+func (m *PrometheusMetrics) Gauge(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series(name, "gauge", labels).value = value
+}
+
+func (m *PrometheusMetrics) Observe(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.series(name, "histogram", labels)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(defaultBuckets)+1)
+	}
+	i, _ := slices.BinarySearch(defaultBuckets, value)
+	s.counts[i]++
+	s.count++
+	s.value += value
+}
+
+// series returns the series of name with labels, creating it on first use. A name keeps
+// the kind it was first recorded with.
+func (m *PrometheusMetrics) series(name, kind string, labels []string) *metricSeries {
+	f, ok := m.families[name]
+	if !ok {
+		f = &metricFamily{kind: kind, series: make(map[string]*metricSeries)}
+		m.families[name] = f
+	}
+	key := formatLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{}
+		f.series[key] = s
+	}
+	return s
+}
+
+func formatLabels(labels []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", labels[i], labels[i+1])
+	}
+	return b.String()
+}
+
+// WriteTo writes the collected metrics in the Prometheus text exposition format, sorted by
+// name and labels.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(m.families)) {
+		f := m.families[name]
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.kind)
+		for _, key := range slices.Sorted(maps.Keys(f.series)) {
+			s := f.series[key]
+			if f.kind != "histogram" {
+				fmt.Fprintf(&b, "%s%s %g\n", name, braces(key), s.value)
+				continue
+			}
+			var cumulative uint64
+			for i, count := range s.counts {
+				cumulative += count
+				le := "+Inf"
+				if i < len(defaultBuckets) {
+					le = strconv.FormatFloat(defaultBuckets[i], 'g', -1, 64)
+				}
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, braces(joinLabels(key, `le="`+le+`"`)), cumulative)
+			}
+			fmt.Fprintf(&b, "%s_sum%s %g\n", name, braces(key), s.value)
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, braces(key), s.count)
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func joinLabels(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
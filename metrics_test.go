@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeMetrics records the calls made to it as "kind name labels value".
+type fakeMetrics struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (m *fakeMetrics) record(kind, name string, value float64, labels []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, fmt.Sprintf("%s %s %v %g", kind, name, labels, value))
+}
+
+func (m *fakeMetrics) Count(name string, delta float64, labels ...string) {
+	m.record("count", name, delta, labels)
+}
+
+func (m *fakeMetrics) Gauge(name string, value float64, labels ...string) {
+	m.record("gauge", name, value, labels)
+}
+
+func (m *fakeMetrics) Observe(name string, value float64, labels ...string) {
+	// Durations vary, so only the call is recorded.
+	m.record("observe", name, 0, labels)
+}
+
+func TestLoadBalancer_Metrics(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+	defer backendServer.Close()
+
+	metrics := &fakeMetrics{}
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithMetrics(metrics))
+	lb.serveProxy(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	want := []string{
+		fmt.Sprintf("gauge lb_upstream_in_flight_requests [backend %s] 1", backendServer.URL),
+		fmt.Sprintf("count lb_upstream_requests_total [backend %s code 418] 1", backendServer.URL),
+		fmt.Sprintf("observe lb_upstream_request_duration_seconds [backend %s] 0", backendServer.URL),
+		fmt.Sprintf("gauge lb_upstream_in_flight_requests [backend %s] 0", backendServer.URL),
+	}
+	if strings.Join(metrics.calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected the metric calls\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(metrics.calls, "\n"))
+	}
+}
+
+func TestAdmin_PrometheusMetrics(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	metrics.Count("lb_upstream_requests_total", 1, "backend", "http://a", "code", "200")
+	metrics.Count("lb_upstream_requests_total", 2, "backend", "http://a", "code", "200")
+	metrics.Gauge("lb_upstream_in_flight_requests", 3, "backend", "http://a")
+	metrics.Observe("lb_upstream_request_duration_seconds", 0.02, "backend", "http://a")
+	metrics.Observe("lb_upstream_request_duration_seconds", 20, "backend", "http://a")
+
+	lb := NewLoadBalancer("8000", nil, WithMetrics(metrics))
+	rw := httptest.NewRecorder()
+	lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rw.Body)
+
+	for _, want := range []string{
+		"# TYPE lb_upstream_requests_total counter\nlb_upstream_requests_total{backend=\"http://a\",code=\"200\"} 3\n",
+		"# TYPE lb_upstream_in_flight_requests gauge\nlb_upstream_in_flight_requests{backend=\"http://a\"} 3\n",
+		"lb_upstream_request_duration_seconds_bucket{backend=\"http://a\",le=\"0.01\"} 0\n",
+		"lb_upstream_request_duration_seconds_bucket{backend=\"http://a\",le=\"0.025\"} 1\n",
+		"lb_upstream_request_duration_seconds_bucket{backend=\"http://a\",le=\"10\"} 1\n",
+		"lb_upstream_request_duration_seconds_bucket{backend=\"http://a\",le=\"+Inf\"} 2\n",
+		"lb_upstream_request_duration_seconds_sum{backend=\"http://a\"} 20.02\n",
+		"lb_upstream_request_duration_seconds_count{backend=\"http://a\"} 2\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Expected /metrics to contain %q; got\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"slices"
+)
+
+// Middleware wraps the handler of the load balancer's listener.
+type Middleware func(next http.Handler) http.Handler
+
+// middlewareNames are the middleware that can be ordered with the "middleware" of a config
+// file. recovery must come first so it also catches panics in the others.
+var middlewareNames = []string{"recovery", "real-ip", "access-log", "logging", "request-id", "grpc-web"}
+
+// defaultMiddlewareOrder applies every middleware, outermost first.
+var defaultMiddlewareOrder = middlewareNames
+
+// validateMiddlewareOrder checks a middleware order from a config file: every name must be
+// known and listed once, and recovery must be the outermost.
+func validateMiddlewareOrder(order []string) error {
+	if len(order) == 0 || order[0] != "recovery" {
+		return errors.New(`middleware: "recovery" must be listed first`)
+	}
+	for i, name := range order {
+		if !slices.Contains(middlewareNames, name) {
+			return fmt.Errorf("middleware: unknown middleware %q", name)
+		}
+		if slices.Contains(order[:i], name) {
+			return fmt.Errorf("middleware: %q listed twice", name)
+		}
+	}
+	return nil
+}
+
+// chainMiddleware wraps handler in the middleware named by order, the first one outermost.
+// Names missing from available, because they aren't enabled, are skipped.
+func chainMiddleware(handler http.Handler, order []string, available map[string]Middleware) http.Handler {
+	for _, name := range slices.Backward(order) {
+		if mw, ok := available[name]; ok {
+			handler = mw(handler)
+		}
+	}
+	return handler
+}
+
+// Middleware to answer requests whose handler panicked with 500, or its WithErrorPages page,
+// instead of dropping the connection
+func (lb *LoadBalancer) recoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				// ReverseProxy aborts responses it can't complete this way.
+				panic(err)
+			}
+			fmt.Printf("Panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			if lb.writeErrorPage(rw, http.StatusInternalServerError) {
+				return
+			}
+			http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
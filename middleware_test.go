@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestChainMiddleware_ConfiguredOrder(t *testing.T) {
+	cfg, err := parseConfig(strings.NewReader(`{
+		"backends": [{"address": "http://127.0.0.1:8081"}],
+		"middleware": ["recovery", "request-id", "access-log", "logging"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls []string
+	record := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(rw, r)
+			})
+		}
+	}
+	available := make(map[string]Middleware)
+	for _, name := range middlewareNames {
+		available[name] = record(name)
+	}
+	// Middleware that isn't enabled is skipped.
+	delete(available, "access-log")
+
+	handler := chainMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "handler")
+	}), cfg.Middleware, available)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if want := []string{"recovery", "request-id", "logging", "handler"}; !slices.Equal(calls, want) {
+		t.Errorf("Expected middleware to run in the order %v; got %v", want, calls)
+	}
+}
+
+func TestParseConfig_InvalidMiddleware(t *testing.T) {
+	for order, want := range map[string]string{
+		`["logging", "recovery"]`:            `"recovery" must be listed first`,
+		`[]`:                                 `"recovery" must be listed first`,
+		`["recovery", "auth"]`:               `unknown middleware "auth"`,
+		`["recovery", "logging", "logging"]`: `"logging" listed twice`,
+	} {
+		_, err := parseConfig(strings.NewReader(`{"backends": [{"address": "http://127.0.0.1:8081"}], "middleware": ` + order + `}`))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected middleware %s to be rejected with %q; got %v", order, want, err)
+		}
+	}
+}
+
+func TestRecoveryMiddleware(t *testing.T) {
+	lb := NewLoadBalancer("8000", nil)
+	handler := lb.recoveryMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/abort" {
+			panic(http.ErrAbortHandler)
+		}
+		panic("boom")
+	}))
+
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Code != http.StatusInternalServerError {
+		t.Errorf("Expected a panic to be answered with 500; got %d", rw.Code)
+	}
+
+	defer func() {
+		if err := recover(); err != http.ErrAbortHandler {
+			t.Errorf("Expected http.ErrAbortHandler to be re-raised; got %v", err)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+}
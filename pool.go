@@ -0,0 +1,125 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Pool is a set of backends that can change while the load balancer is serving. Whether a
+// backend is healthy is decided by the load balancer's health checks and draining state.
+type Pool struct {
+	healthy func(Server) bool
+	// cacheable reports whether the result of healthy only changes when invalidate is
+	// called, so Healthy can be answered from a cached list instead of asking every backend.
+	// nil never caches.
+	cacheable func() bool
+	// removed is called with each backend removed from the pool.
+	removed func(Server)
+
+	mu      sync.RWMutex
+	servers []Server
+
+	// version counts the changes to the backends or their health; cached holds the healthy
+	// backends of one version.
+	version atomic.Uint64
+	cached  atomic.Pointer[healthySnapshot]
+}
+
+type healthySnapshot struct {
+	version uint64
+	servers []Server
+}
+
+func newPool(servers []Server, healthy func(Server) bool) *Pool {
+	return &Pool{
+		healthy: healthy,
+		servers: slices.Clone(servers),
+	}
+}
+
+// Add adds s to the pool and reports whether it was not already in it.
+func (p *Pool) Add(s Server) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if slices.Contains(p.servers, s) {
+		return false
+	}
+	p.servers = append(p.servers, s)
+	p.invalidate()
+	return true
+}
+
+// Remove removes s from the pool and reports whether it was in it. s gets no new requests,
+// but the requests already sent to it complete normally; its idle connections are closed
+// once they have.
+func (p *Pool) Remove(s Server) bool {
+	p.mu.Lock()
+	i := slices.Index(p.servers, s)
+	if i < 0 {
+		p.mu.Unlock()
+		return false
+	}
+	// Copy rather than shift in place: snapshots returned by All may still be in use.
+	p.servers = slices.Delete(slices.Clone(p.servers), i, i+1)
+	p.invalidate()
+	p.mu.Unlock()
+
+	if p.removed != nil {
+		p.removed(s)
+	}
+	return true
+}
+
+// swap replaces all of the pool's backends with servers and returns the previous ones. Unlike
+// Remove, the previous backends are not released, since they may be swapped back in.
+func (p *Pool) swap(servers []Server) []Server {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := p.servers
+	p.servers = slices.Clone(servers)
+	p.invalidate()
+	return previous
+}
+
+// All returns the backends in the pool, in the order they were added. The returned slice
+// must not be modified.
+func (p *Pool) All() []Server {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.servers
+}
+
+// Healthy returns the backends that are alive and not draining. The returned slice must not
+// be modified.
+func (p *Pool) Healthy() []Server {
+	if p.cacheable == nil || !p.cacheable() {
+		return p.scan()
+	}
+	// A change while scanning bumps the version past the one the result is stored with.
+	version := p.version.Load()
+	if c := p.cached.Load(); c != nil && c.version == version {
+		return c.servers
+	}
+	healthy := p.scan()
+	p.cached.Store(&healthySnapshot{version: version, servers: healthy})
+	return healthy
+}
+
+func (p *Pool) scan() []Server {
+	var healthy []Server
+	for _, s := range p.All() {
+		if p.healthy(s) {
+			healthy = append(healthy, s)
+		}
+	}
+	return healthy
+}
+
+// invalidate drops the cached healthy backends after a backend was added or removed or its
+// health or draining state changed.
+func (p *Pool) invalidate() {
+	p.version.Add(1)
+}
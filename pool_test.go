@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool_ConcurrentAddRemove(t *testing.T) {
+	pool := newPool(nil, func(Server) bool { return true })
+
+	const workers, perWorker = 8, 50
+	servers := make([][]Server, workers)
+	for w := range servers {
+		for i := 0; i < perWorker; i++ {
+			servers[w] = append(servers[w], newSimpleServer(fmt.Sprintf("http://backend-%d-%d.internal", w, i)))
+		}
+	}
+
+	// Each worker adds its backends, removes every other one, and reads snapshots meanwhile.
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, s := range servers[w] {
+				if !pool.Add(s) {
+					t.Errorf("Expected %s to be added", s.Address())
+				}
+				pool.All()
+			}
+			for i := 0; i < perWorker; i += 2 {
+				if !pool.Remove(servers[w][i]) {
+					t.Errorf("Expected %s to be removed", servers[w][i].Address())
+				}
+				pool.Healthy()
+			}
+		}()
+	}
+	wg.Wait()
+
+	all := pool.All()
+	if len(all) != workers*perWorker/2 {
+		t.Fatalf("Expected %d backends; got %d", workers*perWorker/2, len(all))
+	}
+	seen := make(map[Server]bool)
+	for _, s := range all {
+		if seen[s] {
+			t.Errorf("Expected %s only once", s.Address())
+		}
+		seen[s] = true
+	}
+	for w := range servers {
+		for i, s := range servers[w] {
+			if seen[s] != (i%2 == 1) {
+				t.Errorf("Expected %s in pool: %v; got %v", s.Address(), i%2 == 1, seen[s])
+			}
+		}
+	}
+}
+
+func TestPool_AddRemoveHealthy(t *testing.T) {
+	a, b := newSimpleServer("http://a.internal"), newSimpleServer("http://b.internal")
+	down := map[Server]bool{b: true}
+	pool := newPool([]Server{a}, func(s Server) bool { return !down[s] })
+
+	if pool.Add(a) {
+		t.Errorf("Expected adding a backend twice to be a no-op")
+	}
+	if !pool.Add(b) || len(pool.All()) != 2 {
+		t.Errorf("Expected b to be added; got %d backends", len(pool.All()))
+	}
+
+	before := pool.All()
+	if healthy := pool.Healthy(); len(healthy) != 1 || healthy[0] != a {
+		t.Errorf("Expected only a to be healthy; got %d backends", len(healthy))
+	}
+	if !pool.Remove(a) || pool.Remove(a) {
+		t.Errorf("Expected a to be removed exactly once")
+	}
+	if len(before) != 2 || before[0] != a {
+		t.Errorf("Expected earlier snapshots to be unaffected by Remove")
+	}
+}
+
+func TestLoadBalancer_PoolChanges(t *testing.T) {
+	a, b := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	lb := NewLoadBalancer("8000", []Server{a})
+
+	lb.Pool().Add(b)
+	lb.Pool().Remove(a)
+	for i := 0; i < 3; i++ {
+		if s := lb.getNextAvailableServer(); s != b {
+			t.Fatalf("Expected only the added backend to be selected; got %v", s)
+		}
+	}
+}
+
+func TestPool_RemoveDuringRequest(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var closed atomic.Int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		close(started)
+		<-release
+		rw.Write([]byte("done"))
+	}))
+	backend.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed.Add(1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	server := newSimpleServer(backend.URL)
+	other := newNamedBackend(t, "other")
+	lb := NewLoadBalancer("8000", []Server{server, other})
+
+	rw := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/slow", nil))
+	}()
+	<-started
+	lb.Pool().Remove(server)
+
+	// New requests avoid the removed backend while the in-flight one keeps going.
+	check := httptest.NewRecorder()
+	lb.serveProxy(check, httptest.NewRequest("GET", "/", nil))
+	if check.Body.String() != "other" {
+		t.Errorf("Expected new requests to skip the removed backend; got %q", check.Body.String())
+	}
+	if closed.Load() != 0 {
+		t.Errorf("Expected the removed backend's connection to stay open while in use")
+	}
+
+	close(release)
+	<-done
+	if rw.Code != http.StatusOK || rw.Body.String() != "done" {
+		t.Errorf("Expected the in-flight request to complete; got %d %q", rw.Code, rw.Body.String())
+	}
+
+	// Once drained its pooled connections are closed.
+	deadline := time.Now().Add(2 * time.Second)
+	for closed.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if closed.Load() == 0 {
+		t.Errorf("Expected the removed backend's idle connections to be closed after it drained")
+	}
+}
+
+func TestLoadBalancer_HealthyCacheInvalidation(t *testing.T) {
+	var down atomic.Bool
+	flaky := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if down.Load() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+	a, b := newSimpleServer(flaky.URL), newNamedBackend(t, "b")
+	lb := NewLoadBalancer("8000", []Server{a, b}, WithHealthCheck(time.Minute, time.Minute), WithHealthThresholds(1, 1))
+
+	picks := func() map[Server]bool {
+		picked := make(map[Server]bool)
+		for range 4 {
+			picked[lb.getNextAvailableServer()] = true
+		}
+		return picked
+	}
+	if picked := picks(); !picked[a] || !picked[b] {
+		t.Fatalf("Expected both backends to be picked; got %v", picked)
+	}
+
+	lb.Drain(a)
+	if picked := picks(); picked[a] {
+		t.Errorf("Expected a draining backend to leave the cached healthy backends")
+	}
+	lb.Undrain(a)
+	if picked := picks(); !picked[a] {
+		t.Errorf("Expected an undrained backend to be picked again")
+	}
+
+	down.Store(true)
+	lb.health.probeDue(lb.pool.All())
+	lb.health.probeOne(a, time.Now())
+	if picked := picks(); picked[a] {
+		t.Errorf("Expected an unhealthy backend to leave the cached healthy backends")
+	}
+}
+
+// BenchmarkLoadBalancer_NextServer picks among 2000 backends, from the pool's cached healthy
+// backends and, for comparison, by asking every backend whether it is available.
+func BenchmarkLoadBalancer_NextServer(b *testing.B) {
+	servers := make([]Server, 2000)
+	for i := range servers {
+		servers[i] = newSimpleServer(fmt.Sprintf("http://backend-%d.internal", i))
+	}
+	for _, name := range []string{"round-robin", "least-connections"} {
+		for _, cached := range []bool{true, false} {
+			b.Run(fmt.Sprintf("%s/cached=%v", name, cached), func(b *testing.B) {
+				strategy, _ := newStrategy(name, nil)
+				lb := NewLoadBalancer("8000", servers, WithStrategy(strategy), WithHealthCheck(time.Minute, time.Minute))
+				if !cached {
+					lb.pool.cacheable = nil
+				}
+				b.ReportAllocs()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if lb.getNextAvailableServer() == nil {
+							b.Error("Expected a backend to be picked")
+							return
+						}
+					}
+				})
+			})
+		}
+	}
+}
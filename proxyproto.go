@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long a connection may take to send its PROXY header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxyV2Signature starts every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1Length is the longest PROXY protocol v1 header, including its CRLF.
+const maxProxyV1Length = 107
+
+var errNoProxyHeader = errors.New("missing PROXY protocol header")
+
+// proxyProtocolListener accepts connections that start with a PROXY protocol v1 or v2
+// header, as sent by HAProxy or an AWS NLB, and reports the client address from the header
+// as their RemoteAddr, so that client IPs, access logs and hashing see the real client.
+// Connections without a valid header are closed, so it must only be used behind a proxy
+// that always sends one: otherwise clients could claim any address.
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+func newProxyProtocolListener(l net.Listener) net.Listener {
+	return &proxyProtocolListener{Listener: l}
+}
+
+// Accept returns the next connection without waiting for its header, which is read on the
+// connection's first use so a slow client can't hold up the others.
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once sync.Once
+	// remote is the client address from the header, nil when the header carries none,
+	// e.g. for the proxy's own health checks.
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			fmt.Printf("Rejecting connection from %s: %v\n", c.Conn.RemoteAddr(), c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from r and returns the client
+// address it carries.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, errNoProxyHeader
+	}
+	switch {
+	case bytes.Equal(start, proxyV2Signature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(start, []byte("PROXY ")):
+		return readProxyV1(r)
+	}
+	return nil, errNoProxyHeader
+}
+
+// readProxyV1 parses the text header, e.g. "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxProxyV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("reading PROXY header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	header, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("PROXY header too long or not terminated by CRLF")
+	}
+	fields := strings.Split(header, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY header %q", header)
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil || ip.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("invalid source address in PROXY header %q", header)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port in PROXY header %q", header)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2 parses the binary header: the signature, a version and command byte, an
+// address family byte, the length of the rest and the addresses, followed by optional TLVs.
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading PROXY header: %w", err)
+	}
+	verCmd, family := header[12], header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("reading PROXY header: %w", err)
+	}
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	switch verCmd & 0xf {
+	case 0x0:
+		// LOCAL: a connection of the proxy itself, such as a health check.
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY command %d", verCmd&0xf)
+	}
+
+	var ip netip.Addr
+	var port []byte
+	switch family >> 4 {
+	case 0x1:
+		if len(payload) < 12 {
+			return nil, errors.New("short IPv4 PROXY header")
+		}
+		ip, port = netip.AddrFrom4([4]byte(payload[:4])), payload[8:10]
+	case 0x2:
+		if len(payload) < 36 {
+			return nil, errors.New("short IPv6 PROXY header")
+		}
+		ip, port = netip.AddrFrom16([16]byte(payload[:16])), payload[32:34]
+	default:
+		// Unix sockets and unspecified families carry no IP address.
+		return nil, nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(port))), nil
+}
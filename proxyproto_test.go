@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// proxyV2Header builds a PROXY protocol v2 header for a TCP connection from src to dst.
+func proxyV2Header(cmd byte, src, dst *net.TCPAddr) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	family, addrs := byte(0x11), append(src.IP.To4(), dst.IP.To4()...)
+	if src.IP.To4() == nil {
+		family, addrs = 0x21, append(src.IP.To16(), dst.IP.To16()...)
+	}
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(src.Port))
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(dst.Port))
+	// A TLV, such as AWS's VPC endpoint ID, which is skipped.
+	addrs = append(addrs, 0xea, 0, 3, 'v', 'p', 'c')
+	header = append(header, 0x20|cmd, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		io.WriteString(rw, clientIP(req))
+	})}
+	go srv.Serve(newProxyProtocolListener(ln))
+	defer srv.Close()
+
+	lbAddr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}
+	tests := []struct {
+		name   string
+		header []byte
+		// want is the client IP seen by the handler, empty if the connection must be refused.
+		want string
+	}{
+		{name: "v1 IPv4", header: []byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n"), want: "203.0.113.7"},
+		{name: "v1 IPv6", header: []byte("PROXY TCP6 2001:db8::7 2001:db8::1 56324 443\r\n"), want: "2001:db8::7"},
+		{name: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n"), want: "127.0.0.1"},
+		{name: "v2 IPv4", header: proxyV2Header(1, &net.TCPAddr{IP: net.ParseIP("203.0.113.8"), Port: 56324}, lbAddr), want: "203.0.113.8"},
+		{name: "v2 IPv6", header: proxyV2Header(1, &net.TCPAddr{IP: net.ParseIP("2001:db8::8"), Port: 56324}, &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}), want: "2001:db8::8"},
+		{name: "v2 local", header: proxyV2Header(0, &net.TCPAddr{IP: net.ParseIP("203.0.113.9"), Port: 56324}, lbAddr), want: "127.0.0.1"},
+		{name: "missing header"},
+		{name: "v1 mismatched family", header: []byte("PROXY TCP4 2001:db8::7 10.0.0.1 56324 443\r\n")},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write(append(tt.header, "GET / HTTP/1.1\r\nHost: lb\r\nConnection: close\r\n\r\n"...))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: Expected the connection to be refused; got %s", tt.name, resp.Status)
+			}
+			conn.Close()
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Failed to read the response: %v", tt.name, err)
+			conn.Close()
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != tt.want {
+			t.Errorf("%s: Expected client IP %q; got %q", tt.name, tt.want, body)
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket allowing rate requests per second on average with bursts of
+// up to burst requests.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	clock Clock
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int, clock Clock) *rateLimiter {
+	b := max(float64(burst), 1)
+	return &rateLimiter{
+		rate:   rate,
+		burst:  b,
+		clock:  clock,
+		tokens: b,
+		last:   clock.Now(),
+	}
+}
+
+// allow takes a token if one is available. Otherwise it returns how long until one is.
+func (l *rateLimiter) allow() (ok bool, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// limitRoute enforces the rate limit of route, answering 429 Too Many Requests with a
+// Retry-After header when it is exceeded. It reports whether the request may proceed.
+func (lb *LoadBalancer) limitRoute(rw http.ResponseWriter, req *http.Request, route *Route) bool {
+	limiter := lb.limiters[route]
+	if limiter == nil {
+		return true
+	}
+	ok, retryAfter := limiter.allow()
+	if ok {
+		return true
+	}
+	rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	lb.writeError(rw, req, http.StatusTooManyRequests, "rate limit exceeded")
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_RouteRateLimits(t *testing.T) {
+	backend := newNamedBackend(t, "ok")
+	expensive := &Route{PathPrefix: "/api/expensive", RateLimit: 1, Burst: 2}
+	cheap := &Route{PathPrefix: "/api/cheap", RateLimit: 10, Burst: 5}
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", []Server{backend}, WithClock(clock), WithRoutes(expensive, cheap))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", path, nil))
+		return rw
+	}
+	allowed := func(path string, n int) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if serve(path).Code == http.StatusOK {
+				count++
+			}
+		}
+		return count
+	}
+
+	if got := allowed("/api/expensive", 4); got != 2 {
+		t.Errorf("Expected the expensive route to allow its burst of 2; got %d", got)
+	}
+	// The exhausted expensive route doesn't affect the cheap one.
+	if got := allowed("/api/cheap", 8); got != 5 {
+		t.Errorf("Expected the cheap route to allow its burst of 5; got %d", got)
+	}
+	if got := allowed("/other", 10); got != 10 {
+		t.Errorf("Expected requests outside limited routes to be unlimited; got %d", got)
+	}
+
+	rw := serve("/api/expensive")
+	if rw.Code != http.StatusTooManyRequests || rw.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 429 with Retry-After 1; got %d %q", rw.Code, rw.Header().Get("Retry-After"))
+	}
+
+	// Tokens refill at each route's own rate.
+	clock.Advance(time.Second)
+	if got := allowed("/api/expensive", 3); got != 1 {
+		t.Errorf("Expected 1 expensive request after a second; got %d", got)
+	}
+	if got := allowed("/api/cheap", 8); got != 5 {
+		t.Errorf("Expected the cheap route to refill its burst after a second; got %d", got)
+	}
+}
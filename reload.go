@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// WithConfigReload lets Reload, SIGHUP and the admin server's POST /reload re-read the
+// config file at path and apply its backends to the default pool. opts are applied to the
+// backends it creates, like those of the initial config.
+func WithConfigReload(path string, opts ...ServerOption) Option {
+	return func(lb *LoadBalancer) {
+		lb.reloader = &configReloader{path: path, serverOpts: opts}
+	}
+}
+
+// withBackendConfig records the config a backend was created from, so a reload can tell
+// whether it changed.
+func withBackendConfig(b BackendConfig) ServerOption {
+	return func(s *simpleServer) {
+		s.config = &b
+	}
+}
+
+// configReloader serializes reloads. A reload requested while another runs waits for it and
+// then reads the file again, so the file's latest contents win; requests waiting together
+// share that one reload.
+type configReloader struct {
+	path       string
+	serverOpts []ServerOption
+
+	// running is held while a reload is applied.
+	running sync.Mutex
+
+	mu sync.Mutex
+	// next is the reload new requests join, until it starts.
+	next *reloadCall
+}
+
+type reloadCall struct {
+	done chan struct{}
+	err  error
+}
+
+func (r *configReloader) do(reload func() error) error {
+	r.mu.Lock()
+	call := r.next
+	leader := call == nil
+	if leader {
+		call = &reloadCall{done: make(chan struct{})}
+		r.next = call
+	}
+	r.mu.Unlock()
+	if !leader {
+		<-call.done
+		return call.err
+	}
+
+	r.running.Lock()
+	// Requests from now on may come after the file changed again, so they need a new reload.
+	r.mu.Lock()
+	r.next = nil
+	r.mu.Unlock()
+	call.err = reload()
+	r.running.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// Reload re-reads the WithConfigReload config file and applies its backends to the default
+// pool: new backends are added, missing ones removed once their requests finish, and those
+// whose settings changed are replaced. Unchanged backends keep their health and traffic
+// state. An invalid file leaves the pool as it is. Only the backends are reloaded, and not
+// with blue-green pools.
+func (lb *LoadBalancer) Reload() error {
+	if lb.reloader == nil {
+		return errors.New("config reloading is not enabled")
+	}
+	if lb.blueGreen != nil {
+		// The active pool may be either one; switch pools instead.
+		return errors.New("config reloading is not supported with blue-green pools")
+	}
+	return lb.reloader.do(lb.reloadConfig)
+}
+
+func (lb *LoadBalancer) reloadConfig() error {
+	cfg, err := LoadConfig(lb.reloader.path)
+	if err != nil {
+		return err
+	}
+
+	current := lb.pool.All()
+	var servers []Server
+	for _, b := range cfg.Backends {
+		i := slices.IndexFunc(current, func(s Server) bool {
+			ss, ok := s.(*simpleServer)
+			return ok && ss.config != nil && reflect.DeepEqual(*ss.config, b)
+		})
+		if i >= 0 {
+			servers = append(servers, current[i])
+			continue
+		}
+		servers = append(servers, newServers([]BackendConfig{b}, lb.reloader.serverOpts)...)
+	}
+
+	var added, removed int
+	for _, s := range servers {
+		if lb.pool.Add(s) {
+			added++
+		}
+	}
+	for _, s := range current {
+		// Backends of expanded hostnames are kept up to date by WatchHosts.
+		if !slices.Contains(servers, s) && !lb.expanded(s) && lb.pool.Remove(s) {
+			removed++
+		}
+	}
+	fmt.Printf("Reloaded %q: %d backends, %d added, %d removed\n", lb.reloader.path, len(servers), added, removed)
+	return nil
+}
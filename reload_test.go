@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeConfig atomically replaces the config file at path with one listing backends. It may
+// be called from any goroutine.
+func writeConfig(t *testing.T, path string, backends ...string) {
+	t.Helper()
+	tmp, err := os.CreateTemp(filepath.Dir(path), "config")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer tmp.Close()
+	if _, err := tmp.WriteString(`{"backends": [` + strings.Join(backends, ", ") + `]}`); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		t.Error(err)
+	}
+}
+
+func poolAddresses(lb *LoadBalancer) []string {
+	var addresses []string
+	for _, s := range lb.pool.All() {
+		addresses = append(addresses, s.Address())
+	}
+	slices.Sort(addresses)
+	return addresses
+}
+
+func TestLoadBalancer_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, `{"address": "http://a.internal"}`, `{"address": "http://b.internal"}`, `{"address": "http://c.internal"}`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb, err := NewLoadBalancerFromConfig(cfg, WithConfigReload(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := lb.pool.All()
+
+	// b is dropped, c gets a weight and d is new.
+	writeConfig(t, path, `{"address": "http://a.internal"}`, `{"address": "http://c.internal", "weight": 5}`, `{"address": "http://d.internal"}`)
+	rw := httptest.NewRecorder()
+	lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("POST", "/reload", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected the reload to succeed; got %d %q", rw.Code, rw.Body.String())
+	}
+	if got, want := poolAddresses(lb), []string{"http://a.internal", "http://c.internal", "http://d.internal"}; !slices.Equal(got, want) {
+		t.Errorf("Expected the backends %v; got %v", want, got)
+	}
+	after := lb.pool.All()
+	if !slices.Contains(after, before[0]) {
+		t.Errorf("Expected the unchanged backend to be kept")
+	}
+	if slices.Contains(after, before[2]) {
+		t.Errorf("Expected the backend whose weight changed to be replaced")
+	}
+
+	// An invalid file leaves the pool alone.
+	writeConfig(t, path)
+	if err := lb.Reload(); err == nil {
+		t.Errorf("Expected a config without backends to be rejected")
+	}
+	if got := poolAddresses(lb); len(got) != 3 {
+		t.Errorf("Expected a failed reload to keep the backends; got %v", got)
+	}
+}
+
+func TestLoadBalancer_ConcurrentReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig(t, path, `{"address": "http://10.0.0.1:8080"}`)
+	lb := NewLoadBalancer("8000", nil, WithConfigReload(path))
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var backends []string
+			for j := 0; j <= i%5; j++ {
+				backends = append(backends, fmt.Sprintf(`{"address": "http://10.0.0.%d:8080", "weight": %d}`, j+1, i))
+			}
+			writeConfig(t, path, backends...)
+			if i%2 == 0 {
+				lb.adminHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/reload", nil))
+			} else if err := lb.Reload(); err != nil {
+				t.Errorf("Reload %d failed: %v", i, err)
+			}
+			// Proxying keeps reading the pool meanwhile.
+			lb.getNextAvailableServer()
+		}()
+	}
+	wg.Wait()
+
+	// The last reload read the file after the last write, so the pool matches the file.
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, b := range cfg.Backends {
+		want = append(want, b.Address)
+	}
+	slices.Sort(want)
+	if got := poolAddresses(lb); !slices.Equal(got, want) {
+		t.Errorf("Expected the backends of the latest config %v; got %v", want, got)
+	}
+	for _, s := range lb.pool.All() {
+		if ss := s.(*simpleServer); ss.config.Weight != cfg.Backends[0].Weight {
+			t.Errorf("Expected %q to have the latest weight %d; got %d", s.Address(), cfg.Backends[0].Weight, ss.config.Weight)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// defaultReplacePollInterval is how often Replace probes the new backend when no health-check
+// interval is configured.
+const defaultReplacePollInterval = time.Second
+
+// Replace swaps the default-pool backend at oldAddr for a new one at newAddr without dropping
+// requests: the new backend is probed until it passes rise health checks in a row, added to
+// the pool, and only then is the old one drained and, once its in-flight requests have
+// finished, removed. If ctx is cancelled before the new backend becomes healthy the pool is
+// left unchanged; if it is cancelled while the old one drains, the old backend stays in the
+// pool drained.
+func (lb *LoadBalancer) Replace(ctx context.Context, oldAddr, newAddr string, opts ...ServerOption) error {
+	var old Server
+	for _, s := range lb.pool.All() {
+		if s.Address() == oldAddr {
+			old = s
+			break
+		}
+	}
+	if old == nil {
+		return fmt.Errorf("backend %q is not in the pool", oldAddr)
+	}
+
+	poll := lb.healthConfig.interval
+	if poll <= 0 {
+		poll = defaultReplacePollInterval
+	}
+	wait := func() error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-lb.clock.After(poll):
+			return nil
+		}
+	}
+
+	replacement := Server(newSimpleServer(newAddr, opts...))
+	for successes := 0; ; {
+		if probe(replacement).alive {
+			successes++
+		} else {
+			successes = 0
+		}
+		if successes >= max(lb.healthConfig.rise, 1) {
+			break
+		}
+		if err := wait(); err != nil {
+			return fmt.Errorf("waiting for %q to become healthy: %w", newAddr, err)
+		}
+	}
+	lb.pool.Add(replacement)
+	fmt.Printf("Backend %q is healthy, replacing %q\n", newAddr, oldAddr)
+
+	if err := lb.DrainAndWait(ctx, old); err != nil {
+		return fmt.Errorf("waiting for %q to drain: %w", oldAddr, err)
+	}
+	lb.pool.Remove(old)
+	lb.setDraining(old, false)
+	return nil
+}
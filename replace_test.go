@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_Replace(t *testing.T) {
+	release := make(chan struct{})
+	oldBackend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			<-release
+		}
+		rw.Write([]byte("old"))
+	}))
+	defer oldBackend.Close()
+
+	var healthy atomic.Bool
+	newBackend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !healthy.Load() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Write([]byte("new"))
+	}))
+	defer newBackend.Close()
+
+	old := newSimpleServer(oldBackend.URL)
+	lb := NewLoadBalancer("8000", []Server{old}, WithHealthCheck(10*time.Millisecond, 10*time.Millisecond))
+	get := func(path string) string {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", path, nil))
+		return rw.Body.String()
+	}
+
+	// Keep a request in flight on the old backend.
+	slowDone := make(chan string)
+	go func() { slowDone <- get("/slow") }()
+	for lb.statsFor(old).activeRequests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	replaced := make(chan error)
+	go func() { replaced <- lb.Replace(context.Background(), oldBackend.URL, newBackend.URL) }()
+
+	// Until the new backend is healthy the old one keeps all of the traffic.
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if got := get("/"); got != "old" {
+			t.Fatalf("Expected traffic to stay on the old backend until the new one is healthy; got %q", got)
+		}
+	}
+
+	healthy.Store(true)
+	for !lb.isDraining(old) {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		if got := get("/"); got != "new" {
+			t.Errorf("Expected traffic to move to the new backend; got %q", got)
+		}
+	}
+
+	// The old backend stays in the pool until its in-flight request is done.
+	select {
+	case err := <-replaced:
+		t.Fatalf("Expected Replace to wait for the old backend to drain; returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if got := <-slowDone; got != "old" {
+		t.Errorf("Expected the in-flight request to complete on the old backend; got %q", got)
+	}
+	if err := <-replaced; err != nil {
+		t.Fatal(err)
+	}
+	if servers := lb.pool.All(); len(servers) != 1 || servers[0].Address() != newBackend.URL {
+		t.Errorf("Expected only the new backend to remain; got %d backends", len(servers))
+	}
+	if lb.isDraining(old) {
+		t.Errorf("Expected the removed backend's draining state to be cleared")
+	}
+}
+
+func TestLoadBalancer_ReplaceUnknownBackend(t *testing.T) {
+	lb := NewLoadBalancer("8000", []Server{newNamedBackend(t, "a")})
+	if err := lb.Replace(context.Background(), "http://unknown", "http://new"); err == nil {
+		t.Errorf("Expected an error replacing a backend that isn't in the pool")
+	}
+}
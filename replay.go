@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultRetryBufferSize caps how much of a request body is kept for replaying it on failover.
+const defaultRetryBufferSize = 1 << 20
+
+var errAttemptOver = errors.New("request body read after its attempt ended")
+
+// WithRetryBufferSize sets how many bytes of each request body are kept in memory so it can be
+// replayed to another backend. Bodies are streamed to the backend either way; a request whose
+// body outgrows the buffer is not retried.
+func WithRetryBufferSize(n int) Option {
+	return func(lb *LoadBalancer) {
+		lb.retryBufferSize = n
+	}
+}
+
+// replayBody streams a request body to successive attempts. The bytes read so far are kept,
+// up to limit, so that a new attempt first replays them and then continues with the rest of the
+// client's body.
+type replayBody struct {
+	// overflowed is set once the body outgrew the buffer. It is atomic so it can be checked
+	// while an attempt is blocked reading from the client.
+	overflowed atomic.Bool
+
+	mu    sync.Mutex
+	src   io.ReadCloser
+	limit int
+	buf   []byte
+	// gen identifies the current attempt; readers of earlier attempts get errAttemptOver, since
+	// transports may still read a body after the attempt failed.
+	gen int
+	pos int
+}
+
+func newReplayBody(src io.ReadCloser, limit int) *replayBody {
+	return &replayBody{src: src, limit: limit}
+}
+
+// attempt returns the body to send with a new attempt, or false if it can't be replayed.
+func (b *replayBody) attempt() (io.ReadCloser, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.overflowed.Load() {
+		return nil, false
+	}
+	b.gen++
+	b.pos = 0
+	return &attemptBody{body: b, gen: b.gen}, true
+}
+
+// replayable reports whether the body read so far still fits the buffer.
+func (b *replayBody) replayable() bool {
+	return !b.overflowed.Load()
+}
+
+func (b *replayBody) Close() error {
+	return b.src.Close()
+}
+
+// attemptBody is the view of a replayBody given to one attempt. Closing it leaves the
+// client's body open for later attempts.
+type attemptBody struct {
+	body *replayBody
+	gen  int
+}
+
+func (a *attemptBody) Read(p []byte) (int, error) {
+	b := a.body
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if a.gen != b.gen {
+		return 0, errAttemptOver
+	}
+	if b.pos < len(b.buf) {
+		n := copy(p, b.buf[b.pos:])
+		b.pos += n
+		return n, nil
+	}
+
+	n, err := b.src.Read(p)
+	if !b.overflowed.Load() {
+		if len(b.buf)+n > b.limit {
+			b.overflowed.Store(true)
+			b.buf = nil
+		} else {
+			b.buf = append(b.buf, p[:n]...)
+		}
+	}
+	b.pos = len(b.buf)
+	return n, err
+}
+
+func (a *attemptBody) Close() error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// patternReader generates n bytes without holding them in memory.
+type patternReader struct{ n int64 }
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), r.n)]
+	for i := range p {
+		p[i] = byte('a' + i%26)
+	}
+	r.n -= int64(len(p))
+	return len(p), nil
+}
+
+func TestLoadBalancer_StreamsLargeUploads(t *testing.T) {
+	const size = 64 << 20
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		n, _ := io.Copy(io.Discard, req.Body)
+		rw.Header().Set("X-Received", strconv.FormatInt(n, 10))
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithRetries(1), WithRetryBufferSize(1<<20))
+	front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+	defer front.Close()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	// An unknown length makes the client send the body chunked.
+	resp, err := http.Post(front.URL+"/upload", "application/octet-stream", io.NopCloser(&patternReader{n: size}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	runtime.ReadMemStats(&after)
+	if got := resp.Header.Get("X-Received"); got != strconv.Itoa(size) {
+		t.Errorf("Expected the backend to receive %d bytes; got %s", size, got)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/4 {
+		t.Errorf("Expected the upload to be streamed; %d bytes were allocated", allocated)
+	}
+}
+
+func TestLoadBalancer_ReplaysPartiallySentBody(t *testing.T) {
+	// The first backend gives up after reading part of the body.
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		io.CopyN(io.Discard, req.Body, 1000)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	var received []byte
+	echo := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received, _ = io.ReadAll(req.Body)
+	}))
+	defer echo.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(failing.URL), newSimpleServer(echo.URL)}, WithRetries(1))
+	body := strings.Repeat("0123456789", 10000)
+
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader(body))))
+
+	if rw.Code != http.StatusOK || !bytes.Equal(received, []byte(body)) {
+		t.Errorf("Expected the whole body to be replayed to the second backend; got %d with %d bytes", rw.Code, len(received))
+	}
+}
+
+func TestLoadBalancer_OversizedBodyIsNotRetried(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		io.Copy(io.Discard, req.Body)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		rw.Write([]byte("overloaded"))
+	}))
+	defer failing.Close()
+	other := newSimpleServer(newStatusBackend(t, http.StatusOK, "ok").URL)
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(failing.URL), other}, WithRetries(1), WithRetryBufferSize(1024))
+
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("POST", "/", io.NopCloser(&patternReader{n: 4096})))
+
+	if rw.Code != http.StatusServiceUnavailable || rw.Body.String() != "overloaded" {
+		t.Errorf("Expected the backend's response to be passed through; got %d %q", rw.Code, rw.Body.String())
+	}
+	if n := lb.statsFor(other).requests.Load(); n != 0 {
+		t.Errorf("Expected no retry of a body larger than the buffer; got %d", n)
+	}
+}
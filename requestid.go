@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDContextKey struct{}
+
+// requestIDFromContext returns the ID assigned by requestIDMiddleware, if any.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Middleware to tag each request with an ID, reusing the client's X-Request-ID when present.
+// The ID is forwarded to the backend and echoed back to the client.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newRequestID()
+			r.Header.Set(requestIDHeader, id)
+		}
+		rw.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey{}, id)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var errResponseTooLarge = errors.New("upstream response body exceeds the size limit")
+
+// WithMaxResponseBody limits the backend's response bodies to limit bytes. Responses whose
+// Content-Length exceeds it are answered with 502, or cut off at the limit when truncate is
+// set. Bodies of unknown length are cut off at the limit in both modes; without truncate the
+// client connection is then aborted, so the client can tell the response is incomplete.
+func WithMaxResponseBody(limit int64, truncate bool) ServerOption {
+	return func(s *simpleServer) {
+		s.maxBodyBytes = limit
+		s.truncateBody = truncate
+	}
+}
+
+func (s *simpleServer) limitResponseBody(resp *http.Response) error {
+	if s.maxBodyBytes <= 0 || resp.Request.Method == http.MethodHead {
+		return nil
+	}
+	if resp.ContentLength > s.maxBodyBytes {
+		if !s.truncateBody {
+			return fmt.Errorf("upstream response of %d bytes exceeds the %d byte limit", resp.ContentLength, s.maxBodyBytes)
+		}
+		// The truncated body is sent chunked.
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: s.maxBodyBytes, truncate: s.truncateBody}
+	return nil
+}
+
+// limitedBody ends a response body after a number of bytes, either cleanly or with
+// errResponseTooLarge when the body goes on past them.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	truncate  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		if b.truncate {
+			return 0, io.EOF
+		}
+		// Only fail if there really is more than the limit.
+		var probe [1]byte
+		if n, err := b.ReadCloser.Read(probe[:]); n == 0 {
+			return 0, err
+		}
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSimpleServer_MaxResponseBody(t *testing.T) {
+	const limit, size = 1000, 2000
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Get("chunked") == "" {
+			rw.Header().Set("Content-Length", "2000")
+			rw.Write([]byte(strings.Repeat("x", size)))
+			return
+		}
+		// Flushing before the end makes the response chunked.
+		for i := 0; i < 4; i++ {
+			rw.Write([]byte(strings.Repeat("x", size/4)))
+			rw.(http.Flusher).Flush()
+		}
+	}))
+	defer backendServer.Close()
+
+	serve := func(truncate bool, target string) *httptest.ResponseRecorder {
+		lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL, WithMaxResponseBody(limit, truncate))})
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", target, nil))
+		return rw
+	}
+
+	if rw := serve(false, "/"); rw.Code != http.StatusBadGateway {
+		t.Errorf("Expected an oversized response to be rejected with 502; got %d", rw.Code)
+	}
+	for _, target := range []string{"/", "/?chunked=1"} {
+		rw := serve(true, target)
+		if rw.Code != http.StatusOK || rw.Body.Len() != limit {
+			t.Errorf("Expected %s to be truncated to %d bytes; got %d with %d bytes", target, limit, rw.Code, rw.Body.Len())
+		}
+		if rw.Header().Get("Content-Length") != "" {
+			t.Errorf("Expected the original Content-Length to be dropped from the truncated %s", target)
+		}
+	}
+
+	// A body of unknown length growing past the limit can only be cut off; the client sees
+	// an incomplete response.
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL, WithMaxResponseBody(limit, false))})
+	front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+	defer front.Close()
+	resp, err := http.Get(front.URL + "/?chunked=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, err := io.ReadAll(resp.Body); err == nil || len(body) > limit {
+		t.Errorf("Expected the oversized stream to be aborted; got %d bytes, error %v", len(body), err)
+	}
+
+	// Responses within the limit are untouched.
+	lb = NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL, WithMaxResponseBody(size, false))})
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/?chunked=1", nil))
+	if rw.Code != http.StatusOK || rw.Body.Len() != size {
+		t.Errorf("Expected a response at the limit to pass through; got %d with %d bytes", rw.Code, rw.Body.Len())
+	}
+}
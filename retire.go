@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// retire releases the resources of a backend removed from the pool once its in-flight
+// requests have finished: its idle connections are closed and its health, weight and
+// traffic state is forgotten. A backend added back, or still used by a route, is kept.
+func (lb *LoadBalancer) retire(s Server) {
+	go func() {
+		lb.waitIdle(context.Background(), s)
+		if slices.Contains(lb.allServers(), s) {
+			return
+		}
+
+		if c, ok := s.(interface{ CloseIdleConnections() }); ok {
+			c.CloseIdleConnections()
+		}
+		if lb.health != nil {
+			lb.health.forget(s)
+		}
+		lb.breakers.forget(s)
+		if f, ok := lb.strategy.(forgetter); ok {
+			f.forget(s)
+		}
+		lb.stats.Delete(s)
+		lb.mu.Lock()
+		delete(lb.advertised, s)
+		delete(lb.draining, s)
+		lb.mu.Unlock()
+		fmt.Printf("Backend %q removed\n", s.Address())
+	}()
+}
+
+// CloseIdleConnections closes the backend's pooled connections that are not in use.
+func (s *simpleServer) CloseIdleConnections() {
+	s.transport.CloseIdleConnections()
+}
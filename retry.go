@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultRetryStatuses are the upstream status codes that trigger failover when retries are enabled.
+var defaultRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// proxyAttempt carries the state of one attempt to serve a request from a backend. When an
+// attempt fails before anything was written to the client, the backend records the error
+// here and leaves the response to the load balancer, which may fail over to another backend.
+type proxyAttempt struct {
+	// retryStatuses are the upstream status codes treated as failures. It is empty on the
+	// last attempt so the backend's response is passed through.
+	retryStatuses map[int]bool
+	// captureBody keeps the start of a failed response's body for debugging.
+	captureBody bool
+	// firstByte fires when the backend takes too long to send its response headers.
+	firstByte *time.Timer
+	// idle fires when the response body sends no data for idleTimeout, cancelling the
+	// attempt with cancel.
+	idle        *time.Timer
+	idleTimeout time.Duration
+	cancel      context.CancelCauseFunc
+	// weightHeader names the response header in which the backend advertises its weight,
+	// which is recorded in weight.
+	weightHeader string
+	weight       float64
+	hasWeight    bool
+	// body is the replayable request body. Once it can't be replayed the attempt is the
+	// last one, so its response is passed through.
+	body *replayBody
+	// flushPolicy picks the flush interval of the response, recorded in flushInterval.
+	flushPolicy      FlushPolicy
+	flushInterval    time.Duration
+	hasFlushInterval bool
+	// stripHeaders are removed from the response.
+	stripHeaders []string
+	// status is the backend's response status, 0 when it sent none.
+	status int
+	err    error
+}
+
+// retriable reports whether a response with the given status fails the attempt. 429 Too Many
+// Requests never does: the backend's Retry-After must reach the client rather than its load
+// being shifted onto another backend.
+func (a *proxyAttempt) retriable(status int) bool {
+	if a.body != nil && !a.body.replayable() {
+		return false
+	}
+	return status != http.StatusTooManyRequests && a.retryStatuses[status]
+}
+
+type attemptContextKey struct{}
+
+func withAttempt(r *http.Request, a *proxyAttempt) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), attemptContextKey{}, a))
+}
+
+func attemptFromContext(ctx context.Context) *proxyAttempt {
+	a, _ := ctx.Value(attemptContextKey{}).(*proxyAttempt)
+	return a
+}
+
+// upstreamStatusError reports a response whose status code is configured as a failure.
+type upstreamStatusError struct {
+	status int
+	body   []byte
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("upstream responded with status %d", e.status)
+}
+
+// WithRetries fails over to up to n other backends when an attempt fails with a transport
+// error or a retriable status code. Request bodies are streamed to the backend and only their
+// first WithRetryBufferSize bytes are kept for replaying; larger bodies are not retried.
+func WithRetries(n int) Option {
+	return func(lb *LoadBalancer) {
+		lb.retries = n
+	}
+}
+
+// WithRetryStatuses sets the upstream status codes that trigger failover, replacing the
+// default of 502, 503 and 504.
+func WithRetryStatuses(codes ...int) Option {
+	return func(lb *LoadBalancer) {
+		lb.retryStatuses = statusSet(codes)
+	}
+}
+
+func statusSet(codes []int) map[int]bool {
+	set := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		set[code] = true
+	}
+	return set
+}
+
+// retryPolicy returns how many times the requests of route are retried and which statuses
+// trigger a retry: the route's overrides, falling back to the load balancer's.
+func (lb *LoadBalancer) retryPolicy(route *Route) (retries int, statuses map[int]bool) {
+	retries, statuses = lb.retries, lb.retryStatuses
+	if route == nil {
+		return retries, statuses
+	}
+	if route.Retries != nil {
+		retries = *route.Retries
+	}
+	if s, ok := lb.retryOverrides[route]; ok {
+		statuses = s
+	}
+	return retries, statuses
+}
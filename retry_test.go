@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newStatusBackend(t *testing.T, status int, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		rw.WriteHeader(status)
+		rw.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLoadBalancer_FailoverStatusCodes(t *testing.T) {
+	tests := []struct {
+		name         string
+		firstStatus  int
+		wantStatus   int
+		wantBody     string
+		wantAttempts int
+	}{
+		{"configured status fails over", http.StatusInternalServerError, http.StatusOK, "ok", 2},
+		{"unconfigured status is passed through", http.StatusNotFound, http.StatusNotFound, "first", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := newSimpleServer(newStatusBackend(t, tt.firstStatus, "first").URL)
+			second := newSimpleServer(newStatusBackend(t, http.StatusOK, "ok").URL)
+			lb := NewLoadBalancer("8000", []Server{first, second}, WithRetries(1), WithRetryStatuses(500, 503))
+
+			rw := httptest.NewRecorder()
+			lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+
+			if rw.Code != tt.wantStatus || rw.Body.String() != tt.wantBody {
+				t.Errorf("Expected %d %q; got %d %q", tt.wantStatus, tt.wantBody, rw.Code, rw.Body.String())
+			}
+			attempts := lb.statsFor(first).requests.Load() + lb.statsFor(second).requests.Load()
+			if attempts != int64(tt.wantAttempts) {
+				t.Errorf("Expected %d attempts; got %d", tt.wantAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestLoadBalancer_FailoverReplaysBody(t *testing.T) {
+	var received string
+	failing := newSimpleServer(newStatusBackend(t, http.StatusBadGateway, "").URL)
+	echo := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		received = string(body)
+	}))
+	defer echo.Close()
+
+	lb := NewLoadBalancer("8000", []Server{failing, newSimpleServer(echo.URL)}, WithRetries(1))
+
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("POST", "/", strings.NewReader("payload")))
+
+	if rw.Code != http.StatusOK || received != "payload" {
+		t.Errorf("Expected body to be replayed to the second backend; got %d with %q", rw.Code, received)
+	}
+}
+
+func TestLoadBalancer_LastAttemptPassesThrough(t *testing.T) {
+	first := newSimpleServer(newStatusBackend(t, http.StatusServiceUnavailable, "first").URL)
+	second := newSimpleServer(newStatusBackend(t, http.StatusServiceUnavailable, "second").URL)
+	lb := NewLoadBalancer("8000", []Server{first, second}, WithRetries(1))
+
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+
+	if rw.Code != http.StatusServiceUnavailable || rw.Body.String() != "second" {
+		t.Errorf("Expected the last backend's response; got %d %q", rw.Code, rw.Body.String())
+	}
+}
+
+func TestLoadBalancer_TooManyRequestsPassesThrough(t *testing.T) {
+	limited := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		rw.Header().Set("Retry-After", "120")
+		rw.WriteHeader(http.StatusTooManyRequests)
+		rw.Write([]byte("slow down"))
+	}))
+	defer limited.Close()
+	first := newSimpleServer(limited.URL)
+	second := newSimpleServer(newStatusBackend(t, http.StatusOK, "ok").URL)
+
+	// Even when listed as a retry status, 429 is never retried.
+	lb := NewLoadBalancer("8000", []Server{first, second}, WithRetries(1), WithRetryStatuses(429, 503))
+
+	rw := httptest.NewRecorder()
+	lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+
+	if rw.Code != http.StatusTooManyRequests || rw.Body.String() != "slow down" {
+		t.Errorf("Expected the backend's 429 response; got %d %q", rw.Code, rw.Body.String())
+	}
+	if got := rw.Header().Get("Retry-After"); got != "120" {
+		t.Errorf("Expected Retry-After 120; got %q", got)
+	}
+	if n := lb.statsFor(second).requests.Load(); n != 0 {
+		t.Errorf("Expected no failover after a 429; got %d requests to the other backend", n)
+	}
+}
+
+// newClosingBackend serves one request per connection and closes it shortly after the
+// response, announcing it with a Connection: close header if announce is set.
+func newClosingBackend(t *testing.T, announce bool) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	header := ""
+	if announce {
+		header = "Connection: close\r\n"
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				body, _ := io.ReadAll(req.Body)
+				if req.Method == http.MethodHead {
+					body = nil
+				}
+				fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\n%sContent-Length: %d\r\n\r\n%s", header, len(body), body)
+				// Long enough for the client to pick the connection for its next request.
+				time.Sleep(5 * time.Millisecond)
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String()
+}
+
+func TestLoadBalancer_BackendClosesConnections(t *testing.T) {
+	tests := []struct {
+		name     string
+		announce bool
+		opts     []ServerOption
+	}{
+		{"announced", true, nil},
+		// Without WithoutKeepAlive the next request may go out on the closed connection.
+		{"unannounced without keep-alive", false, []ServerOption{WithoutKeepAlive()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSimpleServer(newClosingBackend(t, tt.announce), tt.opts...)
+			lb := NewLoadBalancer("8000", []Server{server})
+			for i := range 200 {
+				payload := fmt.Sprintf("request %d", i)
+				method := http.MethodPost
+				if i%2 == 0 {
+					method = http.MethodGet
+				}
+				rw := httptest.NewRecorder()
+				lb.serveProxy(rw, httptest.NewRequest(method, "/", strings.NewReader(payload)))
+				if rw.Code != http.StatusOK || rw.Body.String() != payload {
+					t.Fatalf("%s %d failed: %d %q", method, i, rw.Code, rw.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestLoadBalancer_RouteRetryPolicy(t *testing.T) {
+	var attempts atomic.Int32
+	// Both backends take 100ms to respond; only one of them succeeds.
+	newSlowBackend := func(status int, body string) Server {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodHead {
+				return
+			}
+			attempts.Add(1)
+			select {
+			case <-time.After(100 * time.Millisecond):
+			case <-req.Context().Done():
+				return
+			}
+			rw.WriteHeader(status)
+			rw.Write([]byte(body))
+		}))
+		t.Cleanup(server.Close)
+		return newSimpleServer(server.URL)
+	}
+	servers := []Server{newSlowBackend(http.StatusServiceUnavailable, "unavailable"), newSlowBackend(http.StatusOK, "ok")}
+	noRetries, oneRetry := 0, 1
+	short := &Route{PathPrefix: "/short", Servers: servers, Timeout: 20 * time.Millisecond, Retries: &noRetries}
+	long := &Route{PathPrefix: "/long", Servers: servers, Timeout: time.Second, Retries: &oneRetry}
+	strict := &Route{PathPrefix: "/strict", Servers: servers, Timeout: time.Second, Retries: &oneRetry, RetryStatuses: []int{http.StatusBadGateway}}
+	lb := NewLoadBalancer("8000", servers, WithRoutes(short, long, strict), WithFirstByteTimeout(50*time.Millisecond), WithRetries(1))
+
+	tests := []struct {
+		path string
+		want []int
+		// wantAttempts is the number of backend attempts of both requests, unchecked when 0.
+		wantAttempts int32
+	}{
+		// The global 50ms timeout and retry.
+		{path: "/", want: []int{http.StatusGatewayTimeout, http.StatusGatewayTimeout}, wantAttempts: 4},
+		{path: "/short", want: []int{http.StatusGatewayTimeout, http.StatusGatewayTimeout}, wantAttempts: 2},
+		// Round-robin sends at least one of the requests to the failing backend first.
+		{path: "/long", want: []int{http.StatusOK, http.StatusOK}},
+		// The 503 isn't retriable on /strict, so it reaches the client.
+		{path: "/strict", want: []int{http.StatusServiceUnavailable, http.StatusOK}, wantAttempts: 2},
+	}
+	for _, tt := range tests {
+		attempts.Store(0)
+		var got []int
+		for range 2 {
+			rw := httptest.NewRecorder()
+			lb.serveProxy(rw, httptest.NewRequest("GET", tt.path, nil))
+			got = append(got, rw.Code)
+		}
+		slices.Sort(got)
+		slices.Sort(tt.want)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Expected statuses %v; got %v", tt.path, tt.want, got)
+		}
+		if n := attempts.Load(); tt.wantAttempts != 0 && n != tt.wantAttempts {
+			t.Errorf("%s: Expected %d backend attempts; got %d", tt.path, tt.wantAttempts, n)
+		}
+	}
+}
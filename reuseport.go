@@ -0,0 +1,30 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// newListener opens a TCP listener on addr. With reusePort it sets SO_REUSEPORT so several
+// listeners can share the port and the kernel spreads incoming connections across them.
+func newListener(addr string, reusePort bool) (net.Listener, error) {
+	var lc net.ListenConfig
+	if reusePort {
+		lc.Control = setReusePort
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+func setReusePort(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func newListener(addr string, reusePort bool) (net.Listener, error) {
+	if reusePort {
+		return nil, errors.New("SO_REUSEPORT is not supported on this platform")
+	}
+	return net.Listen("tcp", addr)
+}
@@ -0,0 +1,36 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNewListener_ReusePort(t *testing.T) {
+	first, err := newListener("127.0.0.1:0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	addr := first.Addr().String()
+
+	for i := 0; i < 3; i++ {
+		ln, err := newListener(addr, true)
+		if err != nil {
+			t.Fatalf("Expected another SO_REUSEPORT listener on %s: %v", addr, err)
+		}
+		defer ln.Close()
+	}
+
+	if ln, err := newListener(addr, false); err == nil {
+		ln.Close()
+		t.Errorf("Expected a listener without SO_REUSEPORT to fail to bind %s", addr)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Expected to connect to the shared port: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ResponseTransformer returns a reader producing the transformed response body read from
+// body, or nil to pass the response through unchanged. It may inspect resp, e.g. its
+// Content-Type, but must not read resp.Body itself.
+type ResponseTransformer func(resp *http.Response, body io.Reader) io.Reader
+
+// WithResponseTransformer rewrites the bodies of the backend's responses as they stream to the
+// client. Transformed responses are sent without a Content-Length and with a weak ETag. Gzipped
+// bodies are decompressed for the transformers and compressed again; bodies with other
+// Content-Encodings are passed through.
+func WithResponseTransformer(transform ResponseTransformer) ServerOption {
+	return func(s *simpleServer) {
+		s.transformers = append(s.transformers, transform)
+	}
+}
+
+// WithBodyReplacements replaces strings in the bodies of the backend's responses of the given
+// media types, such as "text/html", e.g. to rewrite absolute URLs. oldnew holds old and new
+// string pairs that are replaced in order of appearance in the body, the first pair wins
+// when several match at the same position, like strings.NewReplacer.
+func WithBodyReplacements(mediaTypes []string, oldnew ...string) ServerOption {
+	if len(oldnew)%2 == 1 {
+		panic("WithBodyReplacements: odd argument count")
+	}
+	return WithResponseTransformer(func(resp *http.Response, body io.Reader) io.Reader {
+		mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		if !slices.Contains(mediaTypes, mediaType) {
+			return nil
+		}
+		return newReplacingReader(body, oldnew)
+	})
+}
+
+// transformResponseBody applies the backend's transformers to resp.
+func (s *simpleServer) transformResponseBody(resp *http.Response) {
+	if len(s.transformers) == 0 || !hasBody(resp) {
+		return
+	}
+	var body io.Reader = resp.Body
+	gzipped := false
+	switch encoding := resp.Header.Get("Content-Encoding"); {
+	case encoding == "", encoding == "identity":
+	case strings.EqualFold(encoding, "gzip"):
+		// Decompressing is left to the first read, so responses that no transformer wants
+		// are passed through compressed.
+		body, gzipped = &gunzipReader{src: resp.Body}, true
+	default:
+		return
+	}
+
+	transformed := false
+	for _, transform := range s.transformers {
+		if r := transform(resp, body); r != nil {
+			body, transformed = r, true
+		}
+	}
+	if !transformed {
+		return
+	}
+	if gzipped {
+		body = newGzipReader(body)
+	}
+	resp.Body = &transformedBody{Reader: body, body: resp.Body}
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		resp.Header.Set("ETag", "W/"+etag)
+	}
+}
+
+// hasBody reports whether resp can carry a body.
+func hasBody(resp *http.Response) bool {
+	switch {
+	case resp.Request.Method == http.MethodHead:
+		return false
+	case resp.StatusCode < 200, resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// transformedBody reads the transformed body and closes the original one.
+type transformedBody struct {
+	io.Reader
+	body io.Closer
+}
+
+func (b *transformedBody) Close() error {
+	return b.body.Close()
+}
+
+// gunzipReader decompresses a gzipped body, starting on the first read.
+type gunzipReader struct {
+	src io.Reader
+	zr  *gzip.Reader
+}
+
+func (r *gunzipReader) Read(p []byte) (int, error) {
+	if r.zr == nil {
+		zr, err := gzip.NewReader(r.src)
+		if err != nil {
+			return 0, err
+		}
+		r.zr = zr
+	}
+	return r.zr.Read(p)
+}
+
+// gzipReader compresses the stream read from src. What was read so far is flushed after each
+// read, so streamed responses keep streaming.
+type gzipReader struct {
+	src io.Reader
+	zw  *gzip.Writer
+	buf []byte
+	out bytes.Buffer
+	err error
+}
+
+func newGzipReader(src io.Reader) *gzipReader {
+	r := &gzipReader{src: src}
+	r.zw = gzip.NewWriter(&r.out)
+	return r
+}
+
+func (r *gzipReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.buf == nil {
+			r.buf = make([]byte, 32<<10)
+		}
+		n, err := r.src.Read(r.buf)
+		r.zw.Write(r.buf[:n])
+		switch {
+		case err == io.EOF:
+			r.zw.Close()
+			r.err = io.EOF
+		case err != nil:
+			r.err = err
+		case n > 0:
+			r.zw.Flush()
+		}
+	}
+	return r.out.Read(p)
+}
+
+// replacingReader replaces strings in a stream. Bytes that may be the start of a match
+// continuing in the next read are held back until it is known.
+type replacingReader struct {
+	src    io.Reader
+	oldnew []string
+	buf    []byte
+	// in holds bytes read from src but not yet replaced, out replaced bytes not yet returned.
+	in, out []byte
+	err     error
+}
+
+func newReplacingReader(src io.Reader, oldnew []string) *replacingReader {
+	return &replacingReader{src: src, oldnew: oldnew}
+}
+
+func (r *replacingReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.buf == nil {
+			r.buf = make([]byte, 32<<10)
+		}
+		n, err := r.src.Read(r.buf)
+		r.in = append(r.in, r.buf[:n]...)
+		r.err = err
+		r.replace(err != nil)
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// replace moves the bytes of r.in that can be decided into r.out, replacing matches. At eof
+// all of them can. At each position the first old string in order that matches wins, so the
+// scan stops at a position where an earlier one might still match once more bytes arrive.
+func (r *replacingReader) replace(eof bool) {
+	in := r.in
+	i := 0
+scan:
+	for i < len(in) {
+		rest := in[i:]
+		for j := 0; j < len(r.oldnew); j += 2 {
+			old := r.oldnew[j]
+			switch {
+			case old == "":
+			case len(rest) >= len(old):
+				if string(rest[:len(old)]) == old {
+					r.out = append(r.out, r.oldnew[j+1]...)
+					i += len(old)
+					continue scan
+				}
+			case !eof && old[:len(rest)] == string(rest):
+				break scan
+			}
+		}
+		r.out = append(r.out, in[i])
+		i++
+	}
+	r.in = append(r.in[:0], in[i:]...)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReplacingReader(t *testing.T) {
+	tests := []struct {
+		in, want string
+		oldnew   []string
+	}{
+		{"<a href=\"http://backend.internal/x\">", "<a href=\"/x\">", []string{"http://backend.internal/", "/"}},
+		{"aaa", "bbb", []string{"a", "b"}},
+		{"ababab", "xy", []string{"abab", "x", "ab", "y"}},
+		{"abc", "y", []string{"abc", "y", "ab", "x"}},
+		{"ab", "x", []string{"abc", "y", "ab", "x"}},
+		{"head</body>", "head<script></script></body>", []string{"</body>", "<script></script></body>"}},
+		{"partial http://back", "partial http://back", []string{"http://backend.internal/", "/"}},
+	}
+	for _, tt := range tests {
+		// Reading one byte at a time splits every match across reads.
+		got, err := io.ReadAll(newReplacingReader(iotest.OneByteReader(strings.NewReader(tt.in)), tt.oldnew))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Replacing %q in %q: got %q; expected %q", tt.oldnew, tt.in, got, tt.want)
+		}
+		if want := strings.NewReplacer(tt.oldnew...).Replace(tt.in); tt.want != want {
+			t.Errorf("Expected %q to match strings.Replacer's %q", tt.want, want)
+		}
+	}
+}
+
+func TestLoadBalancer_BodyReplacements(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		body := `<html><a href="http://backend.internal/docs">docs</a></html>`
+		if req.URL.Path == "/data.json" {
+			rw.Header().Set("Content-Type", "application/json")
+			body = `{"url":"http://backend.internal/docs"}`
+		} else {
+			rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		rw.Header().Set("ETag", `"v1"`)
+		io.WriteString(rw, body)
+	}))
+	defer backendServer.Close()
+
+	server := newSimpleServer(backendServer.URL, WithBodyReplacements([]string{"text/html"}, "http://backend.internal/", "https://example.com/"))
+	front := httptest.NewServer(http.HandlerFunc(NewLoadBalancer("8000", []Server{server}).serveProxy))
+	defer front.Close()
+
+	resp, err := http.Get(front.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := `<html><a href="https://example.com/docs">docs</a></html>`; string(body) != want {
+		t.Errorf("Expected the rewritten body %q; got %q", want, body)
+	}
+	if resp.ContentLength != -1 {
+		t.Errorf("Expected the rewritten body's length to be unknown; got %d", resp.ContentLength)
+	}
+	if etag := resp.Header.Get("ETag"); etag != `W/"v1"` {
+		t.Errorf("Expected the ETag of a rewritten body to be weak; got %q", etag)
+	}
+
+	// Other media types are passed through untouched.
+	resp, err = http.Get(front.URL + "/data.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := `{"url":"http://backend.internal/docs"}`; string(body) != want || resp.ContentLength != int64(len(want)) {
+		t.Errorf("Expected the JSON body unchanged; got %q (length %d)", body, resp.ContentLength)
+	}
+}
+
+func TestLoadBalancer_GzipBodyReplacements(t *testing.T) {
+	gzipped := func(s string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		io.WriteString(zw, s)
+		zw.Close()
+		return buf.Bytes()
+	}
+	html := gzipped(`<html><a href="http://backend.internal/docs">docs</a></html>`)
+	jsonBody := gzipped(`{"url":"http://backend.internal/docs"}`)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		if !strings.Contains(req.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("Expected the client's Accept-Encoding to reach the backend; got %q", req.Header.Get("Accept-Encoding"))
+		}
+		body := html
+		if req.URL.Path == "/data.json" {
+			rw.Header().Set("Content-Type", "application/json")
+			body = jsonBody
+		} else {
+			rw.Header().Set("Content-Type", "text/html")
+		}
+		rw.Header().Set("Content-Encoding", "gzip")
+		rw.Write(body)
+	}))
+	defer backendServer.Close()
+
+	server := newSimpleServer(backendServer.URL, WithBodyReplacements([]string{"text/html"}, "http://backend.internal/", "https://example.com/"))
+	front := httptest.NewServer(http.HandlerFunc(NewLoadBalancer("8000", []Server{server}).serveProxy))
+	defer front.Close()
+
+	get := func(path string) (*http.Response, []byte) {
+		// Setting Accept-Encoding keeps the client from decompressing the body itself.
+		req, _ := http.NewRequest("GET", front.URL+path, nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, body
+	}
+
+	resp, body := get("/")
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "gzip" {
+		t.Fatalf("Expected the rewritten body to be gzipped again; got Content-Encoding %q", encoding)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Expected a valid gzip body; got %v", err)
+	}
+	if want := `<html><a href="https://example.com/docs">docs</a></html>`; string(decompressed) != want {
+		t.Errorf("Expected the rewritten body %q; got %q", want, decompressed)
+	}
+
+	// Bodies no transformer wants are passed through still compressed.
+	resp, body = get("/data.json")
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "gzip" || !bytes.Equal(body, jsonBody) {
+		t.Errorf("Expected the gzipped JSON body unchanged; got Content-Encoding %q and %q", encoding, body)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"maps"
+	"net"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Route sends the requests it matches to its own set of backends. Every non-empty criterion
+// must match; requests matching no route go to the load balancer's default servers, or get
+// 404 Not Found when it has none (see WithUnmatchedStatus). When several routes match, the
+// most specific one wins; see WithRoutes.
+type Route struct {
+	// Priority ranks the route above those with a lower one, however specific they are.
+	// Routes default to 0, so a negative Priority makes a catch-all.
+	Priority int
+
+	// Host matches the request host, ignoring case and port.
+	Host string
+	// PathPrefix matches the beginning of the request path, after "." and ".." segments and
+	// duplicate slashes are resolved so that e.g. "/static/../admin" can't bypass an "/admin"
+	// route.
+	PathPrefix string
+	// CaseInsensitive matches PathPrefix ignoring case, so "/api" also matches "/API/users".
+	CaseInsensitive bool
+	// Headers lists headers that must be present with exactly these values.
+	Headers map[string]string
+	// Query lists query parameters, such as "tenant", that must have exactly these values.
+	Query map[string]string
+	// Methods restricts the allowed methods. Other methods get 405 Method Not Allowed.
+	Methods []string
+	// Protocols matches the protocol negotiated with ALPN, "h2" or "http/1.1", so that e.g.
+	// HTTP/2 clients can be sent to HTTP/2 capable backends. Requests without TLS are matched
+	// by their HTTP version.
+	Protocols []string
+
+	// Servers defaults to the load balancer's default servers, which is useful with tags.
+	Servers []Server
+	// Tags restricts the route to backends carrying all of these tags.
+	Tags map[string]string
+	// TagHeaders derives required tags from the request: each tag must equal the value of
+	// the named header, e.g. {"region": "X-Region"}. Absent headers are ignored.
+	TagHeaders map[string]string
+	// RateLimit caps the route at this many requests per second, allowing bursts of up to
+	// Burst requests. Requests over the limit get 429 Too Many Requests. Zero means no limit.
+	RateLimit float64
+	Burst     int
+	// Fallback receives the route's requests while all of its Servers are draining. Without
+	// one, those requests get 503 Service Unavailable.
+	Fallback []Server
+	// MinHealthy also fails over to Fallback while fewer than this many of Servers are
+	// healthy and not draining, as long as a Fallback backend is. Zero only fails over once
+	// every backend is draining.
+	MinHealthy int
+	// Replicas splits reads from writes: requests using one of ReadMethods go to these read
+	// replicas, and other methods to Servers, the primaries. Reads fall back to the primaries
+	// while no replica is available.
+	Replicas []Server
+	// ReadMethods defaults to GET and HEAD.
+	ReadMethods []string
+
+	// Timeout overrides the first-byte timeout of the route's requests, both the load
+	// balancer's and the backends' own WithTimeout. Zero keeps them.
+	Timeout time.Duration
+	// Retries overrides WithRetries for the route's requests, e.g. a pointer to 0 for requests
+	// that must never be sent twice. Nil keeps the load balancer's.
+	Retries *int
+	// RetryStatuses overrides WithRetryStatuses for the route's requests. Nil keeps the load
+	// balancer's.
+	RetryStatuses []int
+}
+
+// defaultReadMethods are the methods sent to a route's Replicas unless it sets ReadMethods.
+var defaultReadMethods = []string{http.MethodGet, http.MethodHead}
+
+func (rt *Route) matches(r *http.Request) bool {
+	if rt.Host != "" && !strings.EqualFold(requestHost(r), rt.Host) {
+		return false
+	}
+	if rt.PathPrefix != "" && !rt.matchesPath(normalizePath(r.URL.Path)) {
+		return false
+	}
+	for key, value := range rt.Headers {
+		if r.Header.Get(key) != value {
+			return false
+		}
+	}
+	if len(rt.Protocols) > 0 && !slices.Contains(rt.Protocols, negotiatedProtocol(r)) {
+		return false
+	}
+	if len(rt.Query) > 0 {
+		query := r.URL.Query()
+		for key, value := range rt.Query {
+			if !slices.Contains(query[key], value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (rt *Route) matchesPath(p string) bool {
+	if rt.CaseInsensitive {
+		return len(p) >= len(rt.PathPrefix) && strings.EqualFold(p[:len(rt.PathPrefix)], rt.PathPrefix)
+	}
+	return strings.HasPrefix(p, rt.PathPrefix)
+}
+
+// normalizePath resolves "." and ".." segments and duplicate slashes in p, keeping a trailing
+// slash.
+func normalizePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func (rt *Route) allows(method string) bool {
+	return len(rt.Methods) == 0 || slices.Contains(rt.Methods, method)
+}
+
+// filterTags returns the servers carrying every tag required for r. When none does, all of
+// servers are returned so the request is still served.
+func (rt *Route) filterTags(r *http.Request, servers []Server) []Server {
+	required := maps.Clone(rt.Tags)
+	for tag, header := range rt.TagHeaders {
+		if value := r.Header.Get(header); value != "" {
+			if required == nil {
+				required = make(map[string]string)
+			}
+			required[tag] = value
+		}
+	}
+	if len(required) == 0 {
+		return servers
+	}
+
+	var matched []Server
+	for _, s := range servers {
+		if hasTags(s, required) {
+			matched = append(matched, s)
+		}
+	}
+	if len(matched) == 0 {
+		return servers
+	}
+	return matched
+}
+
+func hasTags(s Server, required map[string]string) bool {
+	tagged, ok := s.(interface{ Tags() map[string]string })
+	if !ok {
+		return false
+	}
+	tags := tagged.Tags()
+	for key, value := range required {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// negotiatedProtocol returns the ALPN protocol of r's connection.
+func negotiatedProtocol(r *http.Request) string {
+	if r.TLS != nil && r.TLS.NegotiatedProtocol != "" {
+		return r.TLS.NegotiatedProtocol
+	}
+	if r.ProtoMajor == 2 {
+		return "h2"
+	}
+	return "http/1.1"
+}
+
+func requestHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	// An IPv6 literal without a port, e.g. "[::1]".
+	return strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+}
+
+// WithRoutes adds routing rules. A request goes to the first matching route in this order:
+// higher Priority first, then routes with a Host before those without, longer PathPrefix
+// before shorter, and more Headers, Query and Protocols criteria before fewer. Routes that
+// tie on all of these are evaluated in the order they were added.
+func WithRoutes(routes ...*Route) Option {
+	return func(lb *LoadBalancer) {
+		lb.routes = append(lb.routes, routes...)
+	}
+}
+
+// WithUnmatchedStatus sets the status, 404 Not Found by default, answering requests that match
+// no route while the load balancer has no default servers to send them to.
+func WithUnmatchedStatus(code int) Option {
+	return func(lb *LoadBalancer) {
+		lb.unmatchedStatus = code
+	}
+}
+
+// unmatched reports whether route, as returned by matchRoute, leaves a request without
+// backends: no route matched and there are no default servers.
+func (lb *LoadBalancer) unmatched(route *Route) bool {
+	return route == nil && len(lb.routes) > 0 && len(lb.pool.All()) == 0
+}
+
+// replicasFor returns the read replicas that should serve r within route, or nil when r is
+// not a read, the route has no replicas or none of them is available.
+func (lb *LoadBalancer) replicasFor(route *Route, r *http.Request) []Server {
+	if route == nil || len(route.Replicas) == 0 {
+		return nil
+	}
+	methods := route.ReadMethods
+	if len(methods) == 0 {
+		methods = defaultReadMethods
+	}
+	if !slices.Contains(methods, r.Method) || lb.countAvailable(route.Replicas) == 0 {
+		return nil
+	}
+	return route.Replicas
+}
+
+// specificity ranks rt for sorting the routes, see WithRoutes.
+func (rt *Route) specificity() []int {
+	host := 0
+	if rt.Host != "" {
+		host = 1
+	}
+	return []int{rt.Priority, host, len(rt.PathPrefix), len(rt.Headers) + len(rt.Query) + len(rt.Protocols)}
+}
+
+// sortRoutes orders the routes by precedence, most specific first.
+func sortRoutes(routes []*Route) {
+	slices.SortStableFunc(routes, func(a, b *Route) int {
+		return slices.Compare(b.specificity(), a.specificity())
+	})
+}
+
+// matchRoute returns the matching route of highest precedence, or nil.
+func (lb *LoadBalancer) matchRoute(r *http.Request) *Route {
+	for _, rt := range lb.routes {
+		if rt.matches(r) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// allServers returns the default servers followed by the standby blue-green pool and the
+// servers only reachable through routes.
+func (lb *LoadBalancer) allServers() []Server {
+	seen := make(map[Server]bool)
+	var all []Server
+	add := func(servers []Server) {
+		for _, s := range servers {
+			if !seen[s] {
+				seen[s] = true
+				all = append(all, s)
+			}
+		}
+	}
+	add(lb.pool.All())
+	add(lb.blueGreen.standbyServers())
+	for _, rt := range lb.routes {
+		add(rt.Servers)
+		add(rt.Fallback)
+		add(rt.Replicas)
+	}
+	return all
+}
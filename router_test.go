@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// newNamedBackend starts a backend that answers with its name.
+func newNamedBackend(t *testing.T, name string) *simpleServer {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(name))
+	}))
+	t.Cleanup(backend.Close)
+	return newSimpleServer(backend.URL)
+}
+
+func TestLoadBalancer_RouteMethods(t *testing.T) {
+	readOnly := &Route{
+		PathPrefix: "/reports",
+		Methods:    []string{http.MethodGet, http.MethodHead},
+		Servers:    []Server{newNamedBackend(t, "reports")},
+	}
+	lb := NewLoadBalancer("8000", []Server{newNamedBackend(t, "default")}, WithRoutes(readOnly))
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantBody     string
+	}{
+		{http.MethodGet, "/reports/1", http.StatusOK, "reports"},
+		{http.MethodPost, "/reports/1", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, "/other", http.StatusOK, "default"},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if rw.Code != tt.wantStatus {
+			t.Errorf("%s %s: expected status %d; got %d", tt.method, tt.path, tt.wantStatus, rw.Code)
+		}
+		if tt.wantBody != "" && rw.Body.String() != tt.wantBody {
+			t.Errorf("%s %s: expected body %q; got %q", tt.method, tt.path, tt.wantBody, rw.Body.String())
+		}
+		if tt.wantStatus == http.StatusMethodNotAllowed && rw.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("Expected Allow header %q; got %q", "GET, HEAD", rw.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestRoute_Matches(t *testing.T) {
+	rt := &Route{Host: "api.example.com", PathPrefix: "/v1", Headers: map[string]string{"X-Tenant": "acme"}}
+
+	req := httptest.NewRequest("GET", "http://API.example.com:8000/v1/users", nil)
+	req.Header.Set("X-Tenant", "acme")
+	if !rt.matches(req) {
+		t.Errorf("Expected route to match host, path and header")
+	}
+
+	req.Header.Set("X-Tenant", "other")
+	if rt.matches(req) {
+		t.Errorf("Expected route not to match a different header value")
+	}
+}
+
+func TestRoute_MatchesIPv6Host(t *testing.T) {
+	rt := &Route{Host: "::1"}
+	for _, host := range []string{"[::1]:8000", "[::1]"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		if !rt.matches(req) {
+			t.Errorf("Expected route for %q to match host %q", rt.Host, host)
+		}
+	}
+}
+
+func TestRoute_MatchesNormalizedPath(t *testing.T) {
+	tests := []struct {
+		route   Route
+		path    string
+		matches bool
+	}{
+		{Route{PathPrefix: "/api"}, "/api/x", true},
+		{Route{PathPrefix: "/api/"}, "//api/../api/x", true},
+		{Route{PathPrefix: "/api/"}, "/api//x", true},
+		{Route{PathPrefix: "/api/"}, "/api/", true},
+		{Route{PathPrefix: "/admin"}, "/static/./../admin", true},
+		{Route{PathPrefix: "/api"}, "/api/../admin", false},
+		{Route{PathPrefix: "/api"}, "/API/x", false},
+		{Route{PathPrefix: "/api", CaseInsensitive: true}, "/API/x", true},
+		{Route{PathPrefix: "/api", CaseInsensitive: true}, "//Api/../aPI/x", true},
+		{Route{PathPrefix: "/api", CaseInsensitive: true}, "/ap", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://example.com"+tt.path, nil)
+		if got := tt.route.matches(req); got != tt.matches {
+			t.Errorf("Route %q (case-insensitive %v) matching %q = %v; expected %v",
+				tt.route.PathPrefix, tt.route.CaseInsensitive, tt.path, got, tt.matches)
+		}
+	}
+}
+
+func TestLoadBalancer_RoutePrecedence(t *testing.T) {
+	// Added least specific first, so that the order the routes were added in would pick the
+	// wrong ones.
+	catchAll := &Route{PathPrefix: "/", Priority: -1}
+	api := &Route{PathPrefix: "/api"}
+	apiUsers := &Route{PathPrefix: "/api/users"}
+	canary := &Route{PathPrefix: "/api", Headers: map[string]string{"X-Canary": "1"}}
+	host := &Route{Host: "admin.example.com"}
+	hostAPI := &Route{Host: "admin.example.com", PathPrefix: "/api"}
+	pinned := &Route{PathPrefix: "/api/legacy", Priority: 10}
+	first := &Route{PathPrefix: "/docs"}
+	second := &Route{PathPrefix: "/docs"}
+	lb := NewLoadBalancer("8000", nil, WithRoutes(catchAll, api, apiUsers, canary, host, hostAPI, pinned, first, second))
+
+	tests := []struct {
+		name   string
+		url    string
+		canary bool
+		want   *Route
+	}{
+		{"longer path prefix wins", "http://example.com/api/users/1", false, apiUsers},
+		{"shorter prefix still matches", "http://example.com/api/orders", false, api},
+		{"more criteria win on equal prefixes", "http://example.com/api/orders", true, canary},
+		{"host wins over a longer path", "http://admin.example.com/api/users/1", false, hostAPI},
+		{"host without a path", "http://admin.example.com/dashboard", false, host},
+		{"explicit priority wins over host", "http://admin.example.com/api/legacy", false, pinned},
+		{"negative priority is the fallback", "http://example.com/about", false, catchAll},
+		{"ties keep the order added", "http://example.com/docs", false, first},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		if tt.canary {
+			req.Header.Set("X-Canary", "1")
+		}
+		if got := lb.matchRoute(req); got != tt.want {
+			t.Errorf("%s: expected route %+v; got %+v", tt.name, *tt.want, got)
+		}
+	}
+}
+
+func TestLoadBalancer_QueryRouting(t *testing.T) {
+	lb := NewLoadBalancer("8000", []Server{newNamedBackend(t, "default")}, WithRoutes(
+		&Route{
+			PathPrefix: "/api",
+			Query:      map[string]string{"tenant": "acme", "region": "eu"},
+			Servers:    []Server{newNamedBackend(t, "acme-eu")},
+		},
+		&Route{
+			Query:   map[string]string{"tenant": "acme"},
+			Servers: []Server{newNamedBackend(t, "acme")},
+		},
+	))
+
+	tests := map[string]string{
+		"/api/items?tenant=acme":           "acme",
+		"/api/items?region=eu&tenant=acme": "acme-eu",
+		"/other?tenant=acme&region=eu":     "acme",
+		"/api/items?tenant=globex":         "default",
+		"/api/items":                       "default",
+	}
+	for target, want := range tests {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", target, nil))
+		if rw.Body.String() != want {
+			t.Errorf("%s: expected backend %q; got %q", target, want, rw.Body.String())
+		}
+	}
+}
+
+func TestLoadBalancer_TagRouting(t *testing.T) {
+	tagged := func(name string, tags map[string]string) Server {
+		s := newNamedBackend(t, name)
+		WithTags(tags)(s)
+		return s
+	}
+	servers := []Server{
+		tagged("east", map[string]string{"region": "us-east", "tier": "standard"}),
+		tagged("east-premium", map[string]string{"region": "us-east", "tier": "premium"}),
+		tagged("west", map[string]string{"region": "us-west", "tier": "standard"}),
+	}
+	premium := &Route{PathPrefix: "/premium", Tags: map[string]string{"tier": "premium"}}
+	regional := &Route{TagHeaders: map[string]string{"region": "X-Region"}}
+	lb := NewLoadBalancer("8000", servers, WithRoutes(premium, regional))
+
+	serve := func(path, region string) string {
+		req := httptest.NewRequest("GET", path, nil)
+		if region != "" {
+			req.Header.Set("X-Region", region)
+		}
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, req)
+		return rw.Body.String()
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := serve("/premium/report", ""); got != "east-premium" {
+			t.Errorf("Expected premium requests on the premium backend; got %q", got)
+		}
+		if got := serve("/", "us-west"); got != "west" {
+			t.Errorf("Expected us-west requests on the us-west backend; got %q", got)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		seen[serve("/", "us-east")] = true
+	}
+	if len(seen) != 2 || !seen["east"] || !seen["east-premium"] {
+		t.Errorf("Expected us-east requests balanced over both us-east backends; got %v", seen)
+	}
+
+	// Without a matching backend the request falls back to the route's full group.
+	seen = make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		seen[serve("/", "eu-central")] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected unmatched tags to fall back to all backends; got %v", seen)
+	}
+}
+
+func TestLoadBalancer_ALPNRouting(t *testing.T) {
+	newProtoBackend := func(name string) *simpleServer {
+		backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Write([]byte(name + " " + req.Proto))
+		}))
+		backend.EnableHTTP2 = true
+		backend.StartTLS()
+		t.Cleanup(backend.Close)
+
+		server := newSimpleServer(backend.URL)
+		server.transport.TLSClientConfig = backend.Client().Transport.(*http.Transport).TLSClientConfig
+		return server
+	}
+	h2 := newProtoBackend("h2-backend")
+	h1 := newProtoBackend("h1-backend")
+	lb := NewLoadBalancer("8000", []Server{h1}, WithRoutes(&Route{Protocols: []string{"h2"}, Servers: []Server{h2}}))
+
+	front := httptest.NewUnstartedServer(http.HandlerFunc(lb.serveProxy))
+	front.EnableHTTP2 = true
+	// Advertise both protocols like http.Server.ServeTLS does.
+	front.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	front.StartTLS()
+	defer front.Close()
+
+	h2Client := front.Client()
+	h1Transport := h2Client.Transport.(*http.Transport).Clone()
+	h1Transport.ForceAttemptHTTP2 = false
+	h1Transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	h1Client := &http.Client{Transport: h1Transport}
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		want   string
+	}{
+		{"h2", h2Client, "h2-backend HTTP/2.0"},
+		{"http/1.1", h1Client, "h1-backend HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		resp, err := tt.client.Get(front.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.TLS == nil || resp.TLS.NegotiatedProtocol != tt.name {
+			t.Errorf("Expected ALPN to negotiate %s", tt.name)
+		}
+		if string(body) != tt.want {
+			t.Errorf("Expected %s clients to get %q; got %q", tt.name, tt.want, body)
+		}
+	}
+}
+
+func TestLoadBalancer_UnmatchedRoutes(t *testing.T) {
+	api := &Route{PathPrefix: "/api", Servers: []Server{newNamedBackend(t, "api")}}
+	get := func(lb *LoadBalancer, path string) (int, string) {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", path, nil))
+		return rw.Code, rw.Body.String()
+	}
+
+	withDefault := NewLoadBalancer("8000", []Server{newNamedBackend(t, "default")}, WithRoutes(api))
+	if code, body := get(withDefault, "/api/users"); code != http.StatusOK || body != "api" {
+		t.Errorf("Expected the matched route's backend; got %d %q", code, body)
+	}
+	if code, body := get(withDefault, "/other"); code != http.StatusOK || body != "default" {
+		t.Errorf("Expected an unmatched request to go to the default servers; got %d %q", code, body)
+	}
+
+	withoutDefault := NewLoadBalancer("8000", nil, WithRoutes(api))
+	if code, body := get(withoutDefault, "/api/users"); code != http.StatusOK || body != "api" {
+		t.Errorf("Expected the matched route's backend; got %d %q", code, body)
+	}
+	if code, _ := get(withoutDefault, "/other"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unmatched request without default servers; got %d", code)
+	}
+
+	custom := NewLoadBalancer("8000", nil, WithRoutes(api), WithUnmatchedStatus(http.StatusMisdirectedRequest))
+	if code, _ := get(custom, "/other"); code != http.StatusMisdirectedRequest {
+		t.Errorf("Expected the configured status for an unmatched request; got %d", code)
+	}
+}
+
+func TestLoadBalancer_ReadWriteSplit(t *testing.T) {
+	var replicaHealthy atomic.Bool
+	replicaHealthy.Store(true)
+	replica := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead && !replicaHealthy.Load() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.Write([]byte("replica"))
+	}))
+	defer replica.Close()
+
+	split := &Route{
+		Servers:  []Server{newNamedBackend(t, "primary")},
+		Replicas: []Server{newSimpleServer(replica.URL)},
+	}
+	lb := NewLoadBalancer("8000", nil, WithRoutes(split))
+	send := func(method string) string {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest(method, "/items", nil))
+		return rw.Body.String()
+	}
+
+	for method, want := range map[string]string{"GET": "replica", "POST": "primary", "PUT": "primary", "DELETE": "primary"} {
+		if got := send(method); got != want {
+			t.Errorf("Expected %s to go to the %s; got %q", method, want, got)
+		}
+	}
+
+	replicaHealthy.Store(false)
+	if got := send("GET"); got != "primary" {
+		t.Errorf("Expected reads to fall back to the primary while no replica is healthy; got %q", got)
+	}
+
+	// Configured read methods replace the default ones.
+	split.ReadMethods = []string{"GET", "REPORT"}
+	replicaHealthy.Store(true)
+	if got := send("REPORT"); got != "replica" {
+		t.Errorf("Expected a configured read method to go to the replica; got %q", got)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// OnShutdown registers f to run when the load balancer shuts down, for cleanup such as
+// flushing metrics or delivering queued webhook events. f receives the shutdown context and
+// should return once its deadline passes.
+func (lb *LoadBalancer) OnShutdown(f func(ctx context.Context)) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.shutdownHooks = append(lb.shutdownHooks, f)
+}
+
+// Shutdown runs the registered shutdown hooks concurrently and waits for them to return. It
+// gives up when ctx is done, returning ctx's error, so a stuck hook can't hold up the exit
+// past the shutdown deadline. It is called after the HTTP server has shut down, with the
+// same context.
+func (lb *LoadBalancer) Shutdown(ctx context.Context) error {
+	lb.mu.Lock()
+	hooks := lb.shutdownHooks
+	lb.shutdownHooks = nil
+	lb.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, hook := range hooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hook(ctx)
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_OnShutdown(t *testing.T) {
+	lb := NewLoadBalancer("8000", []Server{newNamedBackend(t, "a")})
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	const hooks = 2
+	hookDeadlines := make(chan time.Time, hooks)
+	for i := 0; i < hooks; i++ {
+		lb.OnShutdown(func(ctx context.Context) {
+			d, _ := ctx.Deadline()
+			hookDeadlines <- d
+		})
+	}
+
+	if err := lb.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < hooks; i++ {
+		select {
+		case d := <-hookDeadlines:
+			if !d.Equal(deadline) {
+				t.Errorf("Expected hooks to get the shutdown deadline %v; got %v", deadline, d)
+			}
+		default:
+			t.Fatalf("Expected every hook to run on shutdown")
+		}
+	}
+}
+
+func TestLoadBalancer_ShutdownDeadline(t *testing.T) {
+	lb := NewLoadBalancer("8000", []Server{newNamedBackend(t, "a")})
+	stuck := make(chan struct{})
+	defer close(stuck)
+	lb.OnShutdown(func(ctx context.Context) { <-stuck })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := lb.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a stuck hook to give up at the deadline; got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxStaleBodySize is the largest response body kept for WithServeStaleOnError.
+const maxStaleBodySize = 1 << 20
+
+// staleWarning marks a response served from a copy because no backend could serve it.
+const staleWarning = `110 - "Response is Stale"`
+
+// WithServeStaleOnError keeps the last successful response to each GET request for maxAge
+// and, when no backend can serve the same request, answers it from that copy with a Warning
+// header instead of an error. Only 200 responses to shared, cacheable requests are kept:
+// those setting cookies, marked private or no-store, varying on anything but
+// Accept-Encoding, or larger than 1 MiB are not.
+func WithServeStaleOnError(maxAge time.Duration) Option {
+	return func(lb *LoadBalancer) {
+		lb.stale = &staleCache{
+			maxAge:  maxAge,
+			entries: make(map[string]*staleEntry),
+		}
+	}
+}
+
+type staleCache struct {
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*staleEntry
+}
+
+type staleEntry struct {
+	resp   *bufferedResponse
+	stored time.Time
+}
+
+// appliesTo reports whether r's response may be kept and served stale.
+func (c *staleCache) appliesTo(r *http.Request) bool {
+	return c != nil && r.Method == http.MethodGet
+}
+
+func (c *staleCache) key(r *http.Request) string {
+	return r.Host + r.URL.RequestURI() + "\n" + r.Header.Get("Accept-Encoding")
+}
+
+// store keeps the response recorded by w as the copy for r, if it may be shared.
+func (c *staleCache) store(r *http.Request, w *staleRecorder, now time.Time) {
+	if w == nil || w.overflow || w.resp.status != http.StatusOK || !staleShareable(w.resp.header) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep(now)
+	c.entries[c.key(r)] = &staleEntry{resp: w.resp, stored: now}
+}
+
+// serve answers r from its kept copy and reports whether there was one.
+func (c *staleCache) serve(rw http.ResponseWriter, r *http.Request, now time.Time) bool {
+	if !c.appliesTo(r) {
+		return false
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[c.key(r)]
+	c.mu.Unlock()
+	if !ok || now.Sub(entry.stored) >= c.maxAge {
+		return false
+	}
+	rw.Header().Set("Warning", staleWarning)
+	rw.Header().Set("Age", strconv.Itoa(int(now.Sub(entry.stored)/time.Second)))
+	entry.resp.writeTo(rw)
+	return true
+}
+
+// sweep drops the copies older than maxAge. c.mu must be held.
+func (c *staleCache) sweep(now time.Time) {
+	for key, entry := range c.entries {
+		if now.Sub(entry.stored) >= c.maxAge {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// staleShareable reports whether a response with header may be served to other clients.
+func staleShareable(header http.Header) bool {
+	if header.Get("Set-Cookie") != "" {
+		return false
+	}
+	for _, v := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "no-store", "private":
+				return false
+			}
+		}
+	}
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if !strings.EqualFold(strings.TrimSpace(name), "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// staleRecorder passes a response through to the client while keeping a copy of it, up to
+// maxStaleBodySize.
+type staleRecorder struct {
+	http.ResponseWriter
+	// before holds the headers set before the request was proxied, such as its X-Request-ID,
+	// which belong to this request rather than to the copy.
+	before   http.Header
+	resp     *bufferedResponse
+	overflow bool
+}
+
+func newStaleRecorder(rw http.ResponseWriter) *staleRecorder {
+	return &staleRecorder{ResponseWriter: rw, before: rw.Header().Clone(), resp: newBufferedResponse()}
+}
+
+func (w *staleRecorder) WriteHeader(code int) {
+	if w.resp.status == 0 && code >= 200 {
+		w.resp.header = upstreamHeader(w.ResponseWriter.Header(), w.before)
+	}
+	w.resp.WriteHeader(code)
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// upstreamHeader returns the values of header added since it held before.
+func upstreamHeader(header, before http.Header) http.Header {
+	added := make(http.Header, len(header))
+	for key, values := range header {
+		prev := before[key]
+		if len(values) >= len(prev) && slices.Equal(values[:len(prev)], prev) {
+			values = values[len(prev):]
+		}
+		if len(values) > 0 {
+			added[key] = slices.Clone(values)
+		}
+	}
+	return added
+}
+
+func (w *staleRecorder) Write(b []byte) (int, error) {
+	if w.resp.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	if !w.overflow {
+		if w.resp.body.Len()+n > maxStaleBodySize {
+			w.overflow = true
+			w.resp.body = bytes.Buffer{}
+		} else {
+			w.resp.body.Write(b[:n])
+		}
+	}
+	return n, err
+}
+
+func (w *staleRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *staleRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_ServeStaleOnError(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		if req.URL.Path == "/session" {
+			rw.Header().Set("Set-Cookie", "session=abc")
+		}
+		rw.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(rw, "content of %s", req.URL.Path)
+	}))
+
+	clock := newFakeClock()
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithClock(clock), WithServeStaleOnError(time.Hour))
+	get := func(path string) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", path, nil))
+		return rw
+	}
+
+	for _, path := range []string{"/page", "/session"} {
+		if rw := get(path); rw.Code != http.StatusOK || rw.Header().Get("Warning") != "" {
+			t.Fatalf("Expected a fresh response while the backend is up; got %d %q", rw.Code, rw.Header().Get("Warning"))
+		}
+	}
+	backendServer.Close()
+	clock.Advance(90 * time.Second)
+
+	rw := get("/page")
+	if rw.Code != http.StatusOK || rw.Body.String() != "content of /page" {
+		t.Fatalf("Expected the cached response while the backend is down; got %d %q", rw.Code, rw.Body.String())
+	}
+	if got := rw.Header().Get("Warning"); got != `110 - "Response is Stale"` {
+		t.Errorf("Expected a stale Warning header; got %q", got)
+	}
+	if rw.Header().Get("Age") != "90" || rw.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected the cached headers and an Age of 90; got %v", rw.Header())
+	}
+
+	if rw := get("/session"); rw.Code != http.StatusBadGateway && rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected responses setting cookies not to be kept; got %d", rw.Code)
+	}
+	if rw := get("/other"); rw.Code != http.StatusBadGateway && rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected an error for requests without a kept response; got %d", rw.Code)
+	}
+
+	clock.Advance(time.Hour)
+	if rw := get("/page"); rw.Code == http.StatusOK {
+		t.Errorf("Expected the kept response to expire after maxAge; got %d", rw.Code)
+	}
+}
+
+func TestLoadBalancer_ServeStaleKeepsRequestID(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		rw.Header().Set("X-Backend", "a")
+		rw.Write([]byte("page"))
+	}))
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithServeStaleOnError(time.Hour))
+	handler := requestIDMiddleware(http.HandlerFunc(lb.serveProxy))
+	get := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/page", nil)
+		req.Header.Set(requestIDHeader, id)
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := get("first"); rw.Code != http.StatusOK {
+		t.Fatalf("Expected a fresh response while the backend is up; got %d", rw.Code)
+	}
+	backendServer.Close()
+
+	rw := get("second")
+	if rw.Header().Get("Warning") == "" || rw.Body.String() != "page" {
+		t.Fatalf("Expected the stale response; got %d %q", rw.Code, rw.Body.String())
+	}
+	if got := rw.Header().Values(requestIDHeader); len(got) != 1 || got[0] != "second" {
+		t.Errorf("Expected the stale response to carry the current request's ID; got %v", got)
+	}
+	if got := rw.Header().Get("X-Backend"); got != "a" {
+		t.Errorf("Expected the backend's headers to be kept; got %q", got)
+	}
+}
+
+func TestStaleShareable(t *testing.T) {
+	cases := []struct {
+		header http.Header
+		want   bool
+	}{
+		{http.Header{}, true},
+		{http.Header{"Cache-Control": {"public, max-age=60"}}, true},
+		{http.Header{"Cache-Control": {"max-age=60, Private"}}, false},
+		{http.Header{"Cache-Control": {"no-store"}}, false},
+		{http.Header{"Vary": {"Accept-Encoding"}}, true},
+		{http.Header{"Vary": {"Accept-Encoding, Cookie"}}, false},
+		{http.Header{"Set-Cookie": {"id=1"}}, false},
+	}
+	for _, c := range cases {
+		if got := staleShareable(c.header); got != c.want {
+			t.Errorf("staleShareable(%v) = %v; want %v", c.header, got, c.want)
+		}
+	}
+}
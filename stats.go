@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// backendStats holds the traffic counters of a single backend.
+type backendStats struct {
+	requests       atomic.Int64
+	activeRequests atomic.Int64
+	bytesSent      atomic.Int64 // request body bytes sent to the backend
+	bytesReceived  atomic.Int64 // response body bytes received from the backend
+}
+
+// reset clears the cumulative counters. Active requests are left alone since they are
+// still in flight.
+func (st *backendStats) reset() {
+	st.requests.Store(0)
+	st.bytesSent.Store(0)
+	st.bytesReceived.Store(0)
+}
+
+// statsFor returns the counters for s, creating them on first use. It is called for every
+// candidate of every request, so looking up existing counters takes no lock.
+func (lb *LoadBalancer) statsFor(s Server) *backendStats {
+	if st, ok := lb.stats.Load(s); ok {
+		return st.(*backendStats)
+	}
+	st, _ := lb.stats.LoadOrStore(s, &backendStats{})
+	return st.(*backendStats)
+}
+
+// WithUntrackedPaths keeps requests to these exact paths, such as "/health", out of the
+// backend request and byte counters. They still count as active requests for balancing.
+func WithUntrackedPaths(paths ...string) Option {
+	return func(lb *LoadBalancer) {
+		lb.untrackedPaths = pathSet(paths)
+	}
+}
+
+func pathSet(paths []string) map[string]bool {
+	set := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		set[p] = true
+	}
+	return set
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
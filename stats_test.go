@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_ByteCounters(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(strings.Repeat("x", 1234)))
+	}))
+	defer backendServer.Close()
+
+	server := newSimpleServer(backendServer.URL)
+	lb := NewLoadBalancer("8000", []Server{server})
+
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("y", 100))))
+		if rw.Body.Len() != 1234 {
+			t.Fatalf("Expected 1234 byte response; got %d", rw.Body.Len())
+		}
+	}
+
+	st := lb.statsFor(server)
+	if got := st.bytesReceived.Load(); got != 2*1234 {
+		t.Errorf("Expected %d bytes received; got %d", 2*1234, got)
+	}
+	if got := st.bytesSent.Load(); got != 2*100 {
+		t.Errorf("Expected %d bytes sent; got %d", 2*100, got)
+	}
+	if got := st.requests.Load(); got != 2 {
+		t.Errorf("Expected 2 requests; got %d", got)
+	}
+
+	rw := httptest.NewRecorder()
+	lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("GET", "/status", nil))
+	var status struct {
+		Backends []backendStatus `json:"backends"`
+	}
+	if err := json.NewDecoder(rw.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Backends) != 1 || status.Backends[0].BytesReceived != 2*1234 || status.Backends[0].BytesSent != 2*100 {
+		t.Errorf("Expected byte counters in /status; got %+v", status.Backends)
+	}
+
+	rw = httptest.NewRecorder()
+	lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	want := `lb_backend_received_bytes_total{backend="` + backendServer.URL + `"} 2468`
+	if !strings.Contains(rw.Body.String(), want) {
+		t.Errorf("Expected %q in /metrics; got:\n%s", want, rw.Body.String())
+	}
+}
+
+func TestLoadBalancer_UntrackedPaths(t *testing.T) {
+	server := newNamedBackend(t, "ok")
+	lb := NewLoadBalancer("8000", []Server{server}, WithUntrackedPaths("/health", "/metrics"))
+
+	var buf bytes.Buffer
+	al := &accessLogger{format: accessLogJSON, out: &buf}
+	al.exclude("/health", "/metrics")
+	handler := accessLogMiddleware(al, http.HandlerFunc(lb.serveProxy))
+
+	for _, path := range []string{"/health", "/metrics", "/health", "/app"} {
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		if rw.Body.String() != "ok" {
+			t.Fatalf("Expected %s to be proxied; got %q", path, rw.Body.String())
+		}
+	}
+
+	if got := lb.statsFor(server).requests.Load(); got != 1 {
+		t.Errorf("Expected only /app to be counted; got %d requests", got)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"path":"/app"`) {
+		t.Errorf("Expected only /app in the access log; got:\n%s", buf.String())
+	}
+}
+
+func TestLoadBalancer_DistributionMetrics(t *testing.T) {
+	a, b := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	lb := NewLoadBalancer("8000", []Server{a, b})
+
+	// Round-robin spreads 4 requests evenly, then 2 more go to a while b drains.
+	for i := 0; i < 4; i++ {
+		lb.serveProxy(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+	lb.Drain(b)
+	for i := 0; i < 2; i++ {
+		lb.serveProxy(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	rw := httptest.NewRecorder()
+	lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("GET", "/status", nil))
+	var status struct {
+		Backends []backendStatus `json:"backends"`
+		Skew     float64         `json:"skew"`
+	}
+	if err := json.NewDecoder(rw.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	shares := map[string]float64{}
+	for _, st := range status.Backends {
+		shares[st.Address] = st.Share
+	}
+	if shares[a.Address()] != 4.0/6 || shares[b.Address()] != 2.0/6 {
+		t.Errorf("Expected shares of 4/6 and 2/6; got %v", shares)
+	}
+	// Counts 4 and 2: mean 3, standard deviation 1.
+	if math.Abs(status.Skew-1.0/3) > 1e-9 {
+		t.Errorf("Expected a skew of 1/3; got %v", status.Skew)
+	}
+
+	rw = httptest.NewRecorder()
+	lb.adminHandler().ServeHTTP(rw, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`lb_backend_request_share{backend="` + b.Address() + `"} 0.3333333333333333`,
+		"lb_request_distribution_skew 0.3333333333333333",
+	} {
+		if !strings.Contains(rw.Body.String(), want) {
+			t.Errorf("Expected %q in /metrics; got:\n%s", want, rw.Body.String())
+		}
+	}
+}
+
+func TestLoadBalancer_ChunkedResponses(t *testing.T) {
+	chunks := []string{"first,", "second,", "third"}
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		rw.Header().Set("Trailer", "X-Checksum")
+		for _, chunk := range chunks {
+			rw.Write([]byte(chunk))
+			rw.(http.Flusher).Flush()
+		}
+		rw.Header().Set("X-Checksum", "abc123")
+	}))
+	defer backendServer.Close()
+
+	want := strings.Join(chunks, "")
+	for _, tt := range []struct {
+		name string
+		opts []Option
+	}{
+		{"streamed", nil},
+		{"coalesced", []Option{WithCoalescing()}},
+		{"flush policy", []Option{WithFlushIntervals(map[string]time.Duration{"text/plain": 0})}},
+	} {
+		server := newSimpleServer(backendServer.URL)
+		lb := NewLoadBalancer("8000", []Server{server}, tt.opts...)
+		front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+
+		resp, err := http.Get(front.URL)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		front.Close()
+
+		if err != nil || string(body) != want {
+			t.Errorf("%s: Expected the chunked body %q; got %q (%v)", tt.name, want, body, err)
+		}
+		if resp.Trailer.Get("X-Checksum") != "abc123" {
+			t.Errorf("%s: Expected the trailer to pass through; got %v", tt.name, resp.Trailer)
+		}
+		if got := lb.statsFor(server).bytesReceived.Load(); got != int64(len(want)) {
+			t.Errorf("%s: Expected %d bytes received; got %d", tt.name, len(want), got)
+		}
+	}
+}
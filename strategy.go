@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+)
+
+// Candidate is an alive backend a Strategy may pick for a request.
+type Candidate struct {
+	Server Server
+	// Weight is the backend's configured weight scaled by the spare capacity it reports.
+	Weight         float64
+	ActiveRequests int64
+	// Priority breaks ties between otherwise equal candidates, higher first; see WithPriority.
+	Priority int
+}
+
+// Strategy picks the backend for a request among the alive candidates, which is never empty.
+// Implementations must be safe for concurrent use.
+type Strategy interface {
+	Next(r *http.Request, candidates []Candidate) Server
+}
+
+// WithStrategy replaces the default round-robin balancing strategy.
+func WithStrategy(s Strategy) Option {
+	return func(lb *LoadBalancer) {
+		lb.strategy = s
+	}
+}
+
+// StrategyConfig holds the options configured for a strategy, as string key-value pairs.
+type StrategyConfig map[string]string
+
+// StrategyFactory creates a strategy from its configured options.
+type StrategyFactory func(cfg StrategyConfig) Strategy
+
+var (
+	strategiesMu sync.RWMutex
+	strategies   = make(map[string]StrategyFactory)
+)
+
+// RegisterStrategy makes a strategy available by name, e.g. for the "strategy" of a config
+// file. It panics if the name is already registered, like database/sql.Register.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	strategiesMu.Lock()
+	defer strategiesMu.Unlock()
+
+	if factory == nil {
+		panic("RegisterStrategy: nil factory for " + name)
+	}
+	if _, dup := strategies[name]; dup {
+		panic("RegisterStrategy: strategy " + name + " registered twice")
+	}
+	strategies[name] = factory
+}
+
+func init() {
+	RegisterStrategy("round-robin", func(StrategyConfig) Strategy { return &roundRobin{} })
+	RegisterStrategy("weighted-round-robin", func(StrategyConfig) Strategy { return &weightedRoundRobin{} })
+	RegisterStrategy("weighted-p2c", func(StrategyConfig) Strategy { return &weightedP2C{} })
+	RegisterStrategy("least-connections", func(StrategyConfig) Strategy { return leastConnections{} })
+}
+
+// newStrategy returns the registered strategy with the given name.
+func newStrategy(name string, cfg StrategyConfig) (Strategy, error) {
+	strategiesMu.RLock()
+	factory, ok := strategies[name]
+	strategiesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q", name)
+	}
+	return factory(cfg), nil
+}
+
+// roundRobin cycles through the candidates in order, ignoring weights.
+type roundRobin struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (rr *roundRobin) Next(r *http.Request, candidates []Candidate) Server {
+	return candidates[rr.pickIndex(len(candidates))].Server
+}
+
+func (rr *roundRobin) pickIndex(n int) int {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	i := rr.count % n
+	rr.count++
+	return i
+}
+
+// indexPicker is implemented by strategies that only look at the order of the candidates,
+// like round-robin, so that the load balancer can pick among its available backends without
+// building candidates.
+type indexPicker interface {
+	// pickIndex returns the position of the pick among n candidates.
+	pickIndex(n int) int
+}
+
+// forgetter is implemented by strategies keeping state per backend, so that the state of a
+// backend removed from the load balancer can be dropped.
+type forgetter interface {
+	forget(s Server)
+}
+
+// weightedRoundRobin is nginx's smooth weighted round-robin: each backend is picked in
+// proportion to its weight, with picks of the same backend spread out rather than bunched.
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[Server]float64
+}
+
+func (wrr *weightedRoundRobin) Next(r *http.Request, candidates []Candidate) Server {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+	if wrr.current == nil {
+		wrr.current = make(map[Server]float64)
+	}
+
+	var best Server
+	total := 0.0
+	for _, c := range candidates {
+		wrr.current[c.Server] += c.Weight
+		total += c.Weight
+		if best == nil || wrr.current[c.Server] > wrr.current[best] {
+			best = c.Server
+		}
+	}
+	wrr.current[best] -= total
+	return best
+}
+
+func (wrr *weightedRoundRobin) forget(s Server) {
+	wrr.mu.Lock()
+	defer wrr.mu.Unlock()
+	delete(wrr.current, s)
+}
+
+// leastConnections picks the candidate with the fewest active requests. Ties go to the
+// candidate with the highest priority, e.g. backends in the local zone, and then to the
+// first one.
+type leastConnections struct{}
+
+func (leastConnections) Next(r *http.Request, candidates []Candidate) Server {
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.ActiveRequests < best.ActiveRequests ||
+			c.ActiveRequests == best.ActiveRequests && c.Priority > best.Priority {
+			best = c
+		}
+	}
+	return best.Server
+}
+
+// weightedP2C samples two distinct candidates with probability proportional to their weight
+// and picks the one with fewer active requests per unit of weight. It spreads load well on
+// heterogeneous fleets without any shared state between picks.
+type weightedP2C struct {
+	// rand is the source of the samples; nil uses the randomly seeded global source.
+	rand *lockedRand
+}
+
+// NewWeightedP2C returns the weighted power-of-two-choices strategy drawing its samples from
+// src, e.g. rand.NewPCG(1, 2) for a reproducible sequence of picks in tests. A nil src uses
+// the randomly seeded global source, which is what production should use.
+func NewWeightedP2C(src rand.Source) Strategy {
+	if src == nil {
+		return weightedP2C{}
+	}
+	return weightedP2C{rand: &lockedRand{rand: rand.New(src)}}
+}
+
+func (p weightedP2C) Next(r *http.Request, candidates []Candidate) Server {
+	if len(candidates) == 1 {
+		return candidates[0].Server
+	}
+
+	first := pickWeighted(candidates, -1, p.rand.Float64())
+	second := pickWeighted(candidates, first, p.rand.Float64())
+	a, b := candidates[first], candidates[second]
+	if float64(b.ActiveRequests)/b.Weight < float64(a.ActiveRequests)/a.Weight {
+		return b.Server
+	}
+	return a.Server
+}
+
+// lockedRand makes a rand.Rand, which is not safe for concurrent use, shareable between
+// requests.
+type lockedRand struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// Float64 returns a number in [0, 1), from the global source when lr is nil.
+func (lr *lockedRand) Float64() float64 {
+	if lr == nil {
+		return rand.Float64()
+	}
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return lr.rand.Float64()
+}
+
+// pickWeighted returns the index of the candidate chosen in proportion to its weight by the
+// random number r in [0, 1), never returning skip.
+func pickWeighted(candidates []Candidate, skip int, r float64) int {
+	total := 0.0
+	for i, c := range candidates {
+		if i != skip {
+			total += c.Weight
+		}
+	}
+
+	n := r * total
+	last := -1
+	for i, c := range candidates {
+		if i == skip {
+			continue
+		}
+		last = i
+		if n < c.Weight {
+			return i
+		}
+		n -= c.Weight
+	}
+	return last
+}
+
+// WithStickyHeader pins requests carrying the named header, such as "X-Session-ID", to a
+// backend chosen by the header's value. Sessions are spread in proportion to the backends'
+// configured weights. Requests without it use the balancing strategy.
+func WithStickyHeader(header string) Option {
+	return func(lb *LoadBalancer) {
+		lb.stickyHeader = header
+	}
+}
+
+// headerAffinity maps each header value to a backend with weighted rendezvous hashing, so a
+// value keeps its backend while that backend is alive and only its sessions move when it is
+// not, and a backend added to the pool only takes over its share of the sessions. Configured
+// weights are used rather than the candidates' weights, which change with reported load and
+// would move sessions around.
+type headerAffinity struct {
+	header string
+	next   Strategy
+}
+
+func (ha *headerAffinity) Next(r *http.Request, candidates []Candidate) Server {
+	if r == nil || r.Header.Get(ha.header) == "" {
+		return ha.next.Next(r, candidates)
+	}
+	key := r.Header.Get(ha.header)
+
+	var best Server
+	var bestScore float64
+	for _, c := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(c.Server.Address()))
+		// The hash as a uniform number in (0, 1) gives the backend a score whose chance of
+		// being the highest is proportional to its weight. FNV's high bits are too similar
+		// for similar keys, so they are mixed first.
+		u := (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+		if score := configuredWeight(c.Server) / -math.Log(u); best == nil || score > bestScore {
+			best, bestScore = c.Server, score
+		}
+	}
+	return best
+}
+
+func (ha *headerAffinity) forget(s Server) {
+	if f, ok := ha.next.(forgetter); ok {
+		f.forget(s)
+	}
+}
+
+// mix64 is the finalizer of MurmurHash3, spreading every input bit over the whole output.
+func mix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// configuredWeight returns the weight s was configured with, 1 unless it has one.
+func configuredWeight(s Server) float64 {
+	if w, ok := s.(interface{ Weight() int }); ok && w.Weight() > 0 {
+		return float64(w.Weight())
+	}
+	return 1
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWeightedP2C_TracksWeights(t *testing.T) {
+	servers := []Server{
+		newSimpleServer("http://a.internal"),
+		newSimpleServer("http://b.internal"),
+		newSimpleServer("http://c.internal"),
+	}
+	candidates := []Candidate{
+		{Server: servers[0], Weight: 1},
+		{Server: servers[1], Weight: 2},
+		{Server: servers[2], Weight: 3},
+	}
+
+	const iterations = 60000
+	strategy := weightedP2C{}
+	counts := map[Server]int{}
+	for i := 0; i < iterations; i++ {
+		counts[strategy.Next(nil, candidates)]++
+	}
+
+	for _, c := range candidates {
+		want := c.Weight / 6
+		got := float64(counts[c.Server]) / iterations
+		if math.Abs(got-want) > 0.02 {
+			t.Errorf("Expected %s to get about %.2f of the traffic; got %.3f", c.Server.Address(), want, got)
+		}
+	}
+}
+
+func TestWeightedP2C_Seeded(t *testing.T) {
+	var candidates []Candidate
+	for i, name := range []string{"a", "b", "c", "d"} {
+		candidates = append(candidates, Candidate{Server: newSimpleServer("http://" + name + ".internal"), Weight: float64(i + 1)})
+	}
+	picks := func(strategy Strategy) string {
+		var seq string
+		for i := 0; i < 12; i++ {
+			address := strategy.Next(nil, candidates).Address()
+			seq += address[len("http://") : len("http://")+1]
+		}
+		return seq
+	}
+
+	const want = "dcddddbddddc"
+	if got := picks(NewWeightedP2C(rand.NewPCG(1, 2))); got != want {
+		t.Errorf("Expected the seeded selection sequence %q; got %q", want, got)
+	}
+	if got := picks(NewWeightedP2C(rand.NewPCG(1, 2))); got != want {
+		t.Errorf("Expected the same seed to repeat the sequence %q; got %q", want, got)
+	}
+}
+
+func TestWeightedP2C_PrefersLessLoaded(t *testing.T) {
+	busy := newSimpleServer("http://busy.internal")
+	idle := newSimpleServer("http://idle.internal")
+	candidates := []Candidate{
+		{Server: busy, Weight: 1, ActiveRequests: 10},
+		{Server: idle, Weight: 1, ActiveRequests: 0},
+	}
+
+	// With two candidates both are always sampled, so the less loaded one must win.
+	for i := 0; i < 100; i++ {
+		if got := (weightedP2C{}).Next(nil, candidates); got != idle {
+			t.Fatalf("Expected the idle backend to be picked; got %s", got.Address())
+		}
+	}
+}
+
+func TestLeastConnections_PriorityTieBreak(t *testing.T) {
+	remote := newNamedBackend(t, "remote")
+	local, busyLocal := newNamedBackend(t, "local"), newNamedBackend(t, "busy-local")
+	WithPriority(10)(local)
+	WithPriority(10)(busyLocal)
+
+	lb := NewLoadBalancer("8000", []Server{remote, local, busyLocal}, WithStrategy(leastConnections{}))
+	lb.statsFor(busyLocal).activeRequests.Store(1)
+	if got := lb.getNextAvailableServer(); got != local {
+		t.Errorf("Expected the higher-priority backend to win a tie; got %s", got.Address())
+	}
+
+	lb.statsFor(local).activeRequests.Store(2)
+	if got := lb.getNextAvailableServer(); got != remote {
+		t.Errorf("Expected the least loaded backend regardless of priority; got %s", got.Address())
+	}
+}
+
+func TestLoadBalancer_WeightedStrategy(t *testing.T) {
+	light := newNamedBackend(t, "light")
+	heavy := newSimpleServer(newNamedBackend(t, "heavy").Address(), WithWeight(3))
+	lb := NewLoadBalancer("8000", []Server{light, heavy}, WithStrategy(&weightedRoundRobin{}))
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		counts[rw.Body.String()]++
+	}
+	if counts["light"] != 2 || counts["heavy"] != 6 {
+		t.Errorf("Expected a 2:6 split; got %v", counts)
+	}
+}
+
+func TestLoadBalancer_WeightedStrategyForgetsRemoved(t *testing.T) {
+	a, b := newNamedBackend(t, "a"), newNamedBackend(t, "b")
+	wrr := &weightedRoundRobin{}
+	lb := NewLoadBalancer("8000", []Server{a, b}, WithStrategy(wrr))
+	for i := 0; i < 2; i++ {
+		lb.serveProxy(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	lb.Pool().Remove(a)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		wrr.mu.Lock()
+		_, kept := wrr.current[a]
+		wrr.mu.Unlock()
+		if !kept {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the removed backend's weight to be dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadBalancer_StickyHeader(t *testing.T) {
+	servers := []Server{newNamedBackend(t, "a"), newNamedBackend(t, "b"), newNamedBackend(t, "c")}
+	lb := NewLoadBalancer("8000", servers, WithStickyHeader("X-Session-ID"))
+
+	serve := func(session string) string {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-Session-ID", session)
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, req)
+		return rw.Body.String()
+	}
+
+	backends := make(map[string]string)
+	used := make(map[string]bool)
+	for i := 0; i < 30; i++ {
+		session := fmt.Sprintf("session-%d", i)
+		backends[session] = serve(session)
+		used[backends[session]] = true
+	}
+	if len(used) != len(servers) {
+		t.Errorf("Expected sessions to spread over all %d backends; got %v", len(servers), used)
+	}
+	for session, backend := range backends {
+		if got := serve(session); got != backend {
+			t.Errorf("Expected %s to stick to backend %q; got %q", session, backend, got)
+		}
+	}
+
+	// Sessions of an unavailable backend move elsewhere; the others stay put.
+	lb.Drain(servers[0])
+	for session, backend := range backends {
+		got := serve(session)
+		if got == "a" || (backend != "a" && got != backend) {
+			t.Errorf("Expected %s on %q to move only if its backend is gone; got %q", session, backend, got)
+		}
+	}
+}
+
+func TestHeaderAffinity_Weighted(t *testing.T) {
+	var candidates []Candidate
+	for i, weight := range []int{1, 2, 3} {
+		s := newSimpleServer(fmt.Sprintf("http://backend-%d.internal", i), WithWeight(weight))
+		candidates = append(candidates, Candidate{Server: s, Weight: float64(weight)})
+	}
+	strategy := &headerAffinity{header: "X-Session-ID", next: &roundRobin{}}
+	assign := func(candidates []Candidate) map[string]Server {
+		assigned := make(map[string]Server)
+		for i := 0; i < 30000; i++ {
+			session := fmt.Sprintf("session-%d", i)
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-Session-ID", session)
+			assigned[session] = strategy.Next(req, candidates)
+		}
+		return assigned
+	}
+
+	before := assign(candidates)
+	counts := make(map[Server]int)
+	for _, s := range before {
+		counts[s]++
+	}
+	for _, c := range candidates {
+		want := c.Weight / 6
+		if got := float64(counts[c.Server]) / float64(len(before)); math.Abs(got-want) > 0.02 {
+			t.Errorf("Expected %s to get about %.2f of the sessions; got %.3f", c.Server.Address(), want, got)
+		}
+	}
+
+	// A backend of weight 2 joining takes over about 2/8 of the sessions, all from the others.
+	added := newSimpleServer("http://backend-new.internal", WithWeight(2))
+	after := assign(append(candidates, Candidate{Server: added, Weight: 2}))
+	moved := 0
+	for session, s := range before {
+		if after[session] == s {
+			continue
+		}
+		moved++
+		if after[session] != added {
+			t.Fatalf("Expected %s to stay on %s or move to the new backend; moved to %s", session, s.Address(), after[session].Address())
+		}
+	}
+	if got := float64(moved) / float64(len(before)); math.Abs(got-0.25) > 0.02 {
+		t.Errorf("Expected about 0.25 of the sessions to move to the new backend; got %.3f", got)
+	}
+}
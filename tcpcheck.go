@@ -0,0 +1,51 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"net"
+	"time"
+)
+
+// defaultTCPCheckTimeout bounds TCP health checks given no timeout.
+const defaultTCPCheckTimeout = 5 * time.Second
+
+// WithTCPHealthCheck probes the backend by opening a TCP connection to its host and port,
+// which must succeed within timeout (5s if 0), instead of sending a HEAD request. It suits
+// backends without an HTTP endpoint to probe, and is cheaper when port-level liveness is all
+// that matters. The connection is closed right away without sending anything, and is made
+// directly, not through WithUpstreamProxy.
+func WithTCPHealthCheck(timeout time.Duration) ServerOption {
+	return func(s *simpleServer) {
+		s.tcpCheckTimeout = cmp.Or(timeout, defaultTCPCheckTimeout)
+	}
+}
+
+// checkTCP connects to the backend, dialing freshly resolved addresses with WithDNSRefresh.
+func (s *simpleServer) checkTCP() probeResult {
+	ctx, cancel := context.WithTimeout(context.Background(), s.tcpCheckTimeout)
+	defer cancel()
+
+	dial := (&net.Dialer{}).DialContext
+	if s.transport.DialContext != nil {
+		dial = s.transport.DialContext
+	}
+	conn, err := dial(ctx, "tcp", s.dialAddress())
+	if err != nil {
+		return probeResult{reason: probeFailure(err)}
+	}
+	conn.Close()
+	return probeResult{alive: true}
+}
+
+// dialAddress returns the backend's host and port, defaulting the port from its scheme.
+func (s *simpleServer) dialAddress() string {
+	port := s.target.Port()
+	if port == "" {
+		port = "80"
+		if s.target.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(s.target.Hostname(), port)
+}
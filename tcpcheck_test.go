@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimpleServer_TCPHealthCheck(t *testing.T) {
+	// A backend speaking something other than HTTP: it accepts connections and closes them.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	addr := "http://" + l.Addr().String()
+
+	if result := probe(newSimpleServer(addr)); result.alive {
+		t.Errorf("Expected the HTTP check to fail against a backend without an HTTP endpoint")
+	}
+	tcpServer := newSimpleServer(addr, WithTCPHealthCheck(time.Second))
+	if result := probe(tcpServer); !result.alive {
+		t.Errorf("Expected the TCP check to pass; got %q", result.reason)
+	}
+
+	l.Close()
+	result := probe(tcpServer)
+	if result.alive {
+		t.Errorf("Expected the TCP check to fail once the port is closed")
+	}
+	if !strings.Contains(result.reason, "connection refused") {
+		t.Errorf("Expected the failure reason to be the refused connection; got %q", result.reason)
+	}
+}
+
+func TestNewServers_TCPHealthCheck(t *testing.T) {
+	cfg, err := parseConfig(strings.NewReader(`{"backends": [
+		{"address": "http://a.internal:6379", "health_check": "tcp", "health_check_timeout": "2s"},
+		{"address": "http://b.internal"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := newServers(cfg.Backends, nil)
+	if got := servers[0].(*simpleServer).tcpCheckTimeout; got != 2*time.Second {
+		t.Errorf("Expected a TCP check with a 2s timeout; got %v", got)
+	}
+	if got := servers[1].(*simpleServer).tcpCheckTimeout; got != 0 {
+		t.Errorf("Expected the HTTP check by default; got a TCP check with timeout %v", got)
+	}
+}
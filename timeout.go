@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	// errFirstByteTimeout is the cause of attempts cancelled by the first-byte timeout.
+	errFirstByteTimeout = errors.New("timed out waiting for the upstream's response headers")
+	// errIdleTimeout is the cause of attempts cancelled by the idle-read timeout.
+	errIdleTimeout = errors.New("upstream response body idle for too long")
+)
+
+// idlePeekSize is how much of a response body is read while waiting for it to start.
+const idlePeekSize = 512
+
+// WithFirstByteTimeout limits how long a backend may take to start responding. Only the time
+// to the response headers counts, so long-lived streams that start promptly aren't cut off.
+// Backends that miss it fail the attempt, which ends in 504 Gateway Timeout.
+func WithFirstByteTimeout(d time.Duration) Option {
+	return func(lb *LoadBalancer) {
+		lb.firstByteTimeout = d
+	}
+}
+
+// WithTimeout overrides the load balancer's first-byte timeout for this backend, e.g. to give
+// a slow report generator more time than a cache.
+func WithTimeout(d time.Duration) ServerOption {
+	return func(s *simpleServer) {
+		s.timeout = d
+	}
+}
+
+// WithIdleTimeout aborts responses whose backend sends no body data for d, freeing the
+// connection of a stream that hung. A response whose body doesn't start within d fails the
+// attempt, which ends in 504 Gateway Timeout; one that stalls after it started streaming is
+// cut off, aborting the client connection. Streams that keep sending data are not limited.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(lb *LoadBalancer) {
+		lb.idleTimeout = d
+	}
+}
+
+func (s *simpleServer) Timeout() time.Duration {
+	return s.timeout
+}
+
+// firstByteTimeoutFor returns the first-byte timeout applying to requests of route sent to
+// s. route is nil for requests to the default servers.
+func (lb *LoadBalancer) firstByteTimeoutFor(route *Route, s Server) time.Duration {
+	if route != nil && route.Timeout > 0 {
+		return route.Timeout
+	}
+	if t, ok := s.(interface{ Timeout() time.Duration }); ok && t.Timeout() > 0 {
+		return t.Timeout()
+	}
+	return lb.firstByteTimeout
+}
+
+// startAttempt binds attempt to req and, with a first-byte timeout for target, arms a timer
+// cancelling the attempt unless the backend responds in time. With an idle timeout, the
+// attempt can be cancelled once its response body stalls. done must be called after the
+// attempt.
+func (lb *LoadBalancer) startAttempt(req *http.Request, attempt *proxyAttempt, route *Route, target Server) (attemptReq *http.Request, done func()) {
+	req = withAttempt(req, attempt)
+	timeout := lb.firstByteTimeoutFor(route, target)
+	if timeout <= 0 && lb.idleTimeout <= 0 {
+		return req, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	if timeout > 0 {
+		attempt.firstByte = time.AfterFunc(timeout, func() {
+			cancel(errFirstByteTimeout)
+		})
+	}
+	if lb.idleTimeout > 0 {
+		attempt.idleTimeout = lb.idleTimeout
+		attempt.cancel = cancel
+	}
+	return req.WithContext(ctx), func() {
+		if attempt.firstByte != nil {
+			attempt.firstByte.Stop()
+		}
+		if attempt.idle != nil {
+			attempt.idle.Stop()
+		}
+		cancel(nil)
+	}
+}
+
+// responded disarms the first-byte timer once the backend's response headers arrived.
+func (a *proxyAttempt) responded() {
+	if a.firstByte != nil {
+		a.firstByte.Stop()
+	}
+}
+
+// watchIdle arms the idle timeout on resp's body. It waits for the body to start, so that a
+// response whose body never does can still be answered with 504 rather than its headers.
+func (a *proxyAttempt) watchIdle(resp *http.Response) error {
+	if a.idleTimeout <= 0 || !hasBody(resp) {
+		return nil
+	}
+	a.idle = time.AfterFunc(a.idleTimeout, func() {
+		a.cancel(errIdleTimeout)
+	})
+
+	first := make([]byte, idlePeekSize)
+	n, err := resp.Body.Read(first)
+	if n == 0 && err != nil && errors.Is(context.Cause(resp.Request.Context()), errIdleTimeout) {
+		return errIdleTimeout
+	}
+	a.idle.Reset(a.idleTimeout)
+	resp.Body = &idleBody{ReadCloser: resp.Body, timer: a.idle, timeout: a.idleTimeout, first: first[:n], firstErr: err}
+	return nil
+}
+
+// idleBody restarts the idle timer whenever data arrives.
+type idleBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+	// first holds the data read while waiting for the body to start, firstErr the error
+	// that read returned.
+	first    []byte
+	firstErr error
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	if len(b.first) > 0 {
+		n := copy(p, b.first)
+		b.first = b.first[n:]
+		return n, nil
+	}
+	if b.firstErr != nil {
+		return 0, b.firstErr
+	}
+	n, err := b.ReadCloser.Read(p)
+	switch {
+	case err != nil:
+		b.timer.Stop()
+	case n > 0:
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
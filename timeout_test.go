@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadBalancer_FirstByteTimeout(t *testing.T) {
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/slow-start":
+			select {
+			case <-time.After(time.Second):
+			case <-req.Context().Done():
+				return
+			}
+			rw.Write([]byte("too late"))
+		case "/stream":
+			// Starts right away, then streams for well over the timeout.
+			rw.WriteHeader(http.StatusOK)
+			for i := 0; i < 5; i++ {
+				rw.Write([]byte("chunk\n"))
+				rw.(http.Flusher).Flush()
+				time.Sleep(50 * time.Millisecond)
+			}
+		}
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithFirstByteTimeout(100*time.Millisecond))
+	front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+	defer front.Close()
+
+	resp, err := http.Get(front.URL + "/slow-start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504 for a backend slow to respond; got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(front.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || len(body) != 5*len("chunk\n") {
+		t.Errorf("Expected the whole slow stream; got %d %q (%v)", resp.StatusCode, body, err)
+	}
+}
+
+func TestLoadBalancer_BackendTimeouts(t *testing.T) {
+	newDelayedBackend := func(name string, delay time.Duration) *httptest.Server {
+		backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodHead {
+				return
+			}
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+				return
+			}
+			rw.Write([]byte(name))
+		}))
+		t.Cleanup(backend.Close)
+		return backend
+	}
+	// The global 100ms would let the cache through and time out the report generator.
+	cache := newSimpleServer(newDelayedBackend("cache", 75*time.Millisecond).URL, WithTimeout(50*time.Millisecond))
+	reports := newSimpleServer(newDelayedBackend("reports", 150*time.Millisecond).URL, WithTimeout(time.Second))
+	lb := NewLoadBalancer("8000", []Server{cache, reports}, WithFirstByteTimeout(100*time.Millisecond),
+		WithRoutes(&Route{PathPrefix: "/reports", Servers: []Server{reports}}, &Route{Servers: []Server{cache}}))
+
+	tests := map[string]int{"/reports/q3": http.StatusOK, "/cached": http.StatusGatewayTimeout}
+	for path, want := range tests {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", path, nil))
+		if rw.Code != want {
+			t.Errorf("Expected %s to get %d; got %d", path, want, rw.Code)
+		}
+	}
+
+	if got := lb.firstByteTimeoutFor(nil, newSimpleServer("http://default.internal")); got != 100*time.Millisecond {
+		t.Errorf("Expected backends without an override to use the global timeout; got %v", got)
+	}
+}
+
+func TestLoadBalancer_IdleTimeout(t *testing.T) {
+	aborted := make(chan string, 2)
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		switch req.URL.Path {
+		case "/stream":
+			// Streams for longer than the timeout, but never idles that long.
+			for i := 0; i < 5; i++ {
+				rw.Write([]byte("chunk\n"))
+				rw.(http.Flusher).Flush()
+				time.Sleep(50 * time.Millisecond)
+			}
+			return
+		case "/stall-mid":
+			rw.Write([]byte("chunk\n"))
+		}
+		rw.(http.Flusher).Flush()
+		// Stall until the load balancer gives up on the stream.
+		select {
+		case <-req.Context().Done():
+			aborted <- req.URL.Path
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)}, WithIdleTimeout(200*time.Millisecond))
+	front := httptest.NewServer(http.HandlerFunc(lb.serveProxy))
+	defer front.Close()
+
+	// A stream that never sends data is answered with 504.
+	start := time.Now()
+	resp, err := http.Get(front.URL + "/stall-start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("Expected 504 for a stream that never started; got %d", resp.StatusCode)
+	}
+
+	// A stream that stalls midway is cut off.
+	resp, err = http.Get(front.URL + "/stall-mid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err == nil || string(body) != "chunk\n" {
+		t.Errorf("Expected the stalled stream to be aborted after its first chunk; got %q (%v)", body, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the stalled streams to be aborted after the idle timeout; took %v", elapsed)
+	}
+	for range 2 {
+		select {
+		case <-aborted:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the stalled upstream requests to be cancelled")
+		}
+	}
+
+	// A stream that keeps sending data outlives the timeout.
+	resp, err = http.Get(front.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || len(body) != 5*len("chunk\n") {
+		t.Errorf("Expected the whole stream; got %d %q (%v)", resp.StatusCode, body, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a TLS certificate from disk and reloads it when the certificate or key
+// file changes, so renewed certificates are picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+// newCertReloader loads the certificate and key, failing if they can't be used.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the files if they changed since they were last loaded.
+func (r *certReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil && certInfo.ModTime().Equal(r.certTime) && keyInfo.ModTime().Equal(r.keyTime) {
+		return nil
+	}
+
+	// Remember the files even if they are unusable, so they are only retried once they change.
+	r.certTime, r.keyTime = certInfo.ModTime(), keyInfo.ModTime()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil {
+		fmt.Printf("Reloaded TLS certificate %q\n", r.certFile)
+	}
+	r.cert = &cert
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. When reloading fails, for example
+// while the files are being replaced, the previous certificate keeps being served.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if err := r.reload(); err != nil {
+		fmt.Printf("Reloading TLS certificate %q failed: %v\n", r.certFile, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, nil
+}
@@ -0,0 +1,100 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenerFDsEnv tells an upgraded process which inherited file descriptors are listeners,
+// as a comma-separated list of addr=fd pairs.
+const listenerFDsEnv = "LB_LISTENER_FDS"
+
+// listen returns the listener for addr inherited from the parent process during a binary
+// upgrade, or a new TCP listener on addr.
+func listen(addr string, reusePort bool) (net.Listener, error) {
+	for _, pair := range strings.Split(os.Getenv(listenerFDsEnv), ",") {
+		inheritedAddr, value, ok := strings.Cut(pair, "=")
+		if !ok || inheritedAddr != addr {
+			continue
+		}
+		fd, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %w", listenerFDsEnv, pair, err)
+		}
+		return inheritListener(uintptr(fd))
+	}
+	return newListener(addr, reusePort)
+}
+
+// inheritListener rebuilds a listener from an inherited file descriptor.
+func inheritListener(fd uintptr) (net.Listener, error) {
+	f := os.NewFile(fd, "inherited-listener")
+	if f == nil {
+		return nil, fmt.Errorf("invalid listener file descriptor %d", fd)
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// notifyUpgrade relays SIGUSR2, which requests a zero-downtime binary upgrade.
+func notifyUpgrade(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGUSR2)
+}
+
+// notifyReload relays SIGHUP, which requests a config reload.
+func notifyReload(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGHUP)
+}
+
+// upgrade starts the current binary again with the same arguments, passing it the listening
+// sockets keyed by the address they were opened for. The new process accepts connections from
+// the shared sockets while this one drains.
+func upgrade(listeners map[string]net.Listener) error {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	var fds []string
+	for addr, ln := range listeners {
+		fileListener, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %T cannot be passed to another process", ln)
+		}
+		f, err := fileListener.File()
+		if err != nil {
+			return err
+		}
+		// ExtraFiles start at descriptor 3 in the child.
+		fds = append(fds, fmt.Sprintf("%s=%d", addr, 3+len(files)))
+		files = append(files, f)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, listenerFDsEnv+"=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, listenerFDsEnv+"="+strings.Join(fds, ","))
+
+	return cmd.Start()
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+func listen(addr string, reusePort bool) (net.Listener, error) {
+	return newListener(addr, reusePort)
+}
+
+func notifyUpgrade(c chan<- os.Signal) {}
+
+func notifyReload(c chan<- os.Signal) {}
+
+func upgrade(listeners map[string]net.Listener) error {
+	return errors.New("binary upgrades are not supported on this platform")
+}
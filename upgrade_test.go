@@ -0,0 +1,55 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestListen_InheritedListener(t *testing.T) {
+	parent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+
+	// File duplicates the descriptor, just like passing it to a child process does.
+	f, err := parent.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	addr := parent.Addr().String()
+	t.Setenv(listenerFDsEnv, "localhost:9=7,"+addr+"="+strconv.Itoa(int(f.Fd())))
+	ln, err := listen(addr, false)
+	if err != nil {
+		t.Fatalf("Expected listener to be rebuilt from the inherited descriptor: %v", err)
+	}
+	defer ln.Close()
+
+	if ln.Addr().String() != parent.Addr().String() {
+		t.Errorf("Expected inherited listener on %s; got %s", parent.Addr(), ln.Addr())
+	}
+
+	// Connections arriving on the shared socket are served through the inherited listener.
+	parent.Close()
+	srv := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte("upgraded"))
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "upgraded" {
+		t.Errorf("Expected response from inherited listener; got %q", body)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, injected at build time with e.g.
+//
+//	go build -ldflags "-X main.version=v1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// When they are not set, the module version and VCS revision recorded by the Go toolchain
+// are used where available.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// VersionInfo describes the running build.
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// buildVersion is the information about the running build.
+var buildVersion = currentVersion()
+
+func currentVersion() VersionInfo {
+	v := VersionInfo{Version: version, Commit: commit, Date: buildDate, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v.Version == "" && info.Main.Version != "" {
+			v.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && v.Commit == "":
+				v.Commit = setting.Value
+			case setting.Key == "vcs.time" && v.Date == "":
+				v.Date = setting.Value
+			}
+		}
+	}
+	if v.Version == "" {
+		v.Version = "(devel)"
+	}
+	if v.Commit == "" {
+		v.Commit = "unknown"
+	}
+	if v.Date == "" {
+		v.Date = "unknown"
+	}
+	return v
+}
+
+func (v VersionInfo) String() string {
+	return "load_balancer " + v.Version + " (commit " + v.Commit + ", built " + v.Date + ", " + v.GoVersion + ")"
+}
+
+func handleVersion(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(buildVersion)
+}
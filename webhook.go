@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type stateEvent struct {
+	Backend string    `json:"backend"`
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+}
+
+// webhookNotifier POSTs backend state changes to a URL. Delivery happens in the background
+// with retries so the health checker and proxy are never blocked by a slow receiver.
+type webhookNotifier struct {
+	url     string
+	client  *http.Client
+	retries int
+	backoff time.Duration
+	clock   Clock
+
+	// mu guards closed so events reported after Close are dropped instead of being sent on
+	// the closed channel.
+	mu     sync.Mutex
+	closed bool
+	events chan stateEvent
+	wg     sync.WaitGroup
+}
+
+func newWebhookNotifier(url string, retries int, clock Clock) *webhookNotifier {
+	n := &webhookNotifier{
+		url:     url,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		retries: retries,
+		backoff: 500 * time.Millisecond,
+		clock:   clock,
+		events:  make(chan stateEvent, 128),
+	}
+	n.wg.Add(1)
+	go n.run()
+	return n
+}
+
+// notify queues ev for delivery, dropping it if the queue is full or the notifier is closed.
+func (n *webhookNotifier) notify(ev stateEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		fmt.Printf("Webhook closed, dropping %s event for %q\n", ev.Event, ev.Backend)
+		return
+	}
+	select {
+	case n.events <- ev:
+	default:
+		fmt.Printf("Webhook queue full, dropping %s event for %q\n", ev.Event, ev.Backend)
+	}
+}
+
+// Close delivers the queued events and stops the notifier.
+func (n *webhookNotifier) Close() {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.events)
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+}
+
+func (n *webhookNotifier) run() {
+	defer n.wg.Done()
+	for ev := range n.events {
+		n.deliver(ev)
+	}
+}
+
+func (n *webhookNotifier) deliver(ev stateEvent) {
+	payload, _ := json.Marshal(ev)
+
+	delay := n.backoff
+	for attempt := 0; ; attempt++ {
+		err := n.post(payload)
+		if err == nil {
+			return
+		}
+		if attempt >= n.retries {
+			fmt.Printf("Giving up delivering %s event for %q: %v\n", ev.Event, ev.Backend, err)
+			return
+		}
+		<-n.clock.After(delay)
+		delay *= 2
+	}
+}
+
+func (n *webhookNotifier) post(payload []byte) error {
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// WithStateWebhook POSTs a JSON event to url whenever a backend changes state, retrying
+// failed deliveries up to retries times.
+func WithStateWebhook(url string, retries int) Option {
+	return func(lb *LoadBalancer) {
+		lb.webhookURL = url
+		lb.webhookRetries = retries
+	}
+}
+
+// notifyWebhook reports the backend state changes among events to the configured webhook.
+func (lb *LoadBalancer) notifyWebhook(ev Event) {
+	switch ev.Type {
+	case EventBackendHealthy, EventBackendUnhealthy, EventBackendDraining, EventBackendUndrained:
+		lb.webhook.notify(stateEvent{Backend: ev.Backend.Address(), Event: string(ev.Type), Time: ev.Time})
+	}
+}
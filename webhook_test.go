@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhook_HealthStateChange(t *testing.T) {
+	var deliveries atomic.Int32
+	received := make(chan stateEvent, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Fail the first delivery to exercise retries.
+		if deliveries.Add(1) == 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var ev stateEvent
+		if err := json.NewDecoder(req.Body).Decode(&ev); err != nil {
+			t.Errorf("Expected JSON payload: %v", err)
+		}
+		received <- ev
+	}))
+	defer receiver.Close()
+
+	backendServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backendServer.Close()
+
+	lb := NewLoadBalancer("8000", []Server{newSimpleServer(backendServer.URL)},
+		WithHealthCheck(time.Minute, time.Minute), WithStateWebhook(receiver.URL, 2))
+	lb.webhook.backoff = time.Millisecond
+
+	lb.health.probeDue(lb.pool.All())
+
+	select {
+	case ev := <-received:
+		if ev.Backend != backendServer.URL || ev.Event != string(EventBackendUnhealthy) {
+			t.Errorf("Expected unhealthy event for %q; got %+v", backendServer.URL, ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the webhook notification")
+	}
+	lb.webhook.Close()
+
+	if deliveries.Load() != 2 {
+		t.Errorf("Expected delivery to be retried once; got %d deliveries", deliveries.Load())
+	}
+}
+
+func TestWebhook_NotifyDoesNotBlock(t *testing.T) {
+	n := &webhookNotifier{events: make(chan stateEvent, 1)}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			n.notify(stateEvent{Backend: "b", Event: string(EventBackendHealthy)})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected notify to drop events instead of blocking")
+	}
+}
+
+func TestWebhook_NotifyAfterClose(t *testing.T) {
+	n := newWebhookNotifier("http://127.0.0.1:0", 0, newFakeClock())
+	n.Close()
+
+	// A probe or drain finishing during shutdown must not panic.
+	n.notify(stateEvent{Backend: "b", Event: string(EventBackendUnhealthy)})
+	n.Close()
+}
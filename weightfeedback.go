@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// Bounds and smoothing of weights advertised by backends.
+const (
+	minAdvertisedWeight = 1
+	maxAdvertisedWeight = 1000
+	// advertisedWeightSmoothing is the share of a new observation in the smoothed weight, so
+	// one odd response doesn't swing the traffic.
+	advertisedWeightSmoothing = 0.3
+)
+
+// WithWeightHeader lets backends adjust their weight through the named response header, e.g.
+// "X-LB-Weight: 50". Observed values are clamped to [1, 1000], smoothed with an exponential
+// moving average and replace the configured weight of the backend. The header is not passed on
+// to clients. Unless another strategy is set, weighted round-robin is used.
+func WithWeightHeader(name string) Option {
+	return func(lb *LoadBalancer) {
+		lb.weightHeader = name
+	}
+}
+
+// parseAdvertisedWeight returns the weight in a header value, clamped to the allowed bounds.
+func parseAdvertisedWeight(value string) (float64, bool) {
+	weight, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(weight) {
+		return 0, false
+	}
+	return min(max(weight, minAdvertisedWeight), maxAdvertisedWeight), true
+}
+
+// observeWeight folds a weight advertised by s into its smoothed weight.
+func (lb *LoadBalancer) observeWeight(s Server, weight float64) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	if lb.advertised == nil {
+		lb.advertised = make(map[Server]float64)
+	}
+	current, ok := lb.advertised[s]
+	if !ok {
+		current = 1
+		if w, ok := s.(interface{ Weight() int }); ok {
+			current = float64(w.Weight())
+		}
+	}
+	lb.advertised[s] = current + advertisedWeightSmoothing*(weight-current)
+}
+
+// advertisedWeight returns the smoothed weight advertised by s, if any.
+func (lb *LoadBalancer) advertisedWeight(s Server) (float64, bool) {
+	if lb.weightHeader == "" {
+		return 0, false
+	}
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	weight, ok := lb.advertised[s]
+	return weight, ok
+}
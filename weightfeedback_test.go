@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoadBalancer_WeightHeader(t *testing.T) {
+	newWeightedBackend := func(name, weight string) Server {
+		backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set("X-LB-Weight", weight)
+			rw.Write([]byte(name))
+		}))
+		t.Cleanup(backend.Close)
+		return newSimpleServer(backend.URL)
+	}
+	big := newWeightedBackend("big", "50")
+	small := newWeightedBackend("small", "10")
+	lb := NewLoadBalancer("8000", []Server{big, small}, WithWeightHeader("X-LB-Weight"))
+
+	counts := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		rw := httptest.NewRecorder()
+		lb.serveProxy(rw, httptest.NewRequest("GET", "/", nil))
+		if rw.Header().Get("X-LB-Weight") != "" {
+			t.Fatalf("Expected the weight header to be stripped from the response")
+		}
+		if i >= 100 {
+			counts[rw.Body.String()]++
+		}
+	}
+
+	// Once the smoothed weights have converged traffic splits 5:1.
+	if counts["big"] < 4*counts["small"] || counts["big"] > 6*counts["small"] {
+		t.Errorf("Expected about 5x more traffic to the backend advertising a higher weight; got %v", counts)
+	}
+	if w, _ := lb.advertisedWeight(big); w < 49 || w > 50 {
+		t.Errorf("Expected smoothed weight near 50; got %v", w)
+	}
+}
+
+func TestParseAdvertisedWeight(t *testing.T) {
+	tests := map[string]float64{"50": 50, "0": minAdvertisedWeight, "-3": minAdvertisedWeight, "1e9": maxAdvertisedWeight}
+	for value, want := range tests {
+		if got, ok := parseAdvertisedWeight(value); !ok || got != want {
+			t.Errorf("parseAdvertisedWeight(%q) = %v, %v; want %v", value, got, ok, want)
+		}
+	}
+	for _, value := range []string{"", "heavy", "NaN"} {
+		if _, ok := parseAdvertisedWeight(value); ok {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}